@@ -1,6 +1,8 @@
 package adbmanager
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"path/filepath"
 	"time"
@@ -15,8 +17,24 @@ type Model struct {
 	binPth     string
 	cmdFactory command.Factory
 	logger     log.Logger
+	// serverPort is the port of the adb server, adb's default is used if 0.
+	serverPort int
 }
 
+// Client is implemented by the adb binary backed Model and by WireClient, which talks to the adb server directly.
+type Client interface {
+	ListDevices() ([]Device, error)
+	DeviceInfo(serial string) (DeviceInfo, error)
+	Push(serial, localPath, remotePath string, opts TransferOptions) (TransferResult, error)
+	Pull(serial, remotePath, localPath string, opts TransferOptions) (TransferResult, error)
+	Shell(ctx context.Context, serial string, args ...string) (ShellResult, error)
+}
+
+var (
+	_ Client = Model{}
+	_ Client = WireClient{}
+)
+
 func New(sdk sdk.AndroidSdkInterface, cmdFactory command.Factory, logger log.Logger) (*Model, error) {
 	binPth := filepath.Join(sdk.GetAndroidHome(), "platform-tools", "adb")
 	if exist, err := pathutil.IsPathExists(binPth); err != nil {
@@ -33,24 +51,14 @@ func New(sdk sdk.AndroidSdkInterface, cmdFactory command.Factory, logger log.Log
 }
 
 func (model Model) DevicesCmd() *command.Command {
-	cmd := model.cmdFactory.Create(model.binPth, []string{"devices"}, nil)
+	cmd := model.adbCmd([]string{"devices"}, nil)
 	return &cmd
 }
 
-func (model Model) UnlockDevice(serial string) error {
-	keyEvent82Cmd := model.cmdFactory.Create(model.binPth, []string{"-s", serial, "shell", "input", "82", "&"}, nil)
-	if err := keyEvent82Cmd.Run(); err != nil {
-		return err
-	}
-
-	keyEvent1Cmd := model.cmdFactory.Create(model.binPth, []string{"-s", serial, "shell", "input", "1", "&"}, nil)
-	return keyEvent1Cmd.Run()
-}
-
 // InstallAPKCmd builds and returns a `Command` for installing APKs on an attached device or emulator.
 // The `Command` can than be run by the consumer without needing to know the implementation details.
 func (model Model) InstallAPKCmd(pathToAPK string, commandOptions *command.Opts) command.Command {
-	cmd := model.cmdFactory.Create(model.binPth, []string{"install", pathToAPK}, commandOptions)
+	cmd := model.adbCmd([]string{"install", pathToAPK}, commandOptions)
 	return cmd
 }
 
@@ -67,12 +75,35 @@ func (model Model) InstallAPKCmd(pathToAPK string, commandOptions *command.Opts)
 // adb shell am instrument -e "KEY1" "value1" "KEY2" "value2" [...]
 //
 // See `adb` documentation for more info: https://developer.android.com/studio/command-line/adb#am
+//
+// Use RunInstrumentationCmd for typed and validated instrumentation options.
 func (model Model) RunInstrumentedTestsCmd(
 	packageName string,
 	testRunnerClass string,
 	additionalTestingOptions []string,
 	commandOptions *command.Opts,
 ) command.Command {
+	args := instrumentArgs(false, packageName, testRunnerClass, additionalTestingOptions)
+	cmd := model.adbCmd(args, commandOptions)
+	return cmd
+}
+
+// RunInstrumentedTestsRawCmd is the same as RunInstrumentedTestsCmd, but it adds the `-r` flag to `am instrument`,
+// so that the output contains the raw test statuses.
+// The output can be parsed with the testresult/instrumentation package, pass an instrumentation.Parser
+// as `commandOptions.Stdout` to process the results while the tests are running.
+func (model Model) RunInstrumentedTestsRawCmd(
+	packageName string,
+	testRunnerClass string,
+	additionalTestingOptions []string,
+	commandOptions *command.Opts,
+) command.Command {
+	args := instrumentArgs(true, packageName, testRunnerClass, additionalTestingOptions)
+	cmd := model.adbCmd(args, commandOptions)
+	return cmd
+}
+
+func instrumentArgs(rawOutput bool, packageName, testRunnerClass string, additionalTestingOptions []string) []string {
 	args := []string{
 		"shell",
 		"am",
@@ -80,6 +111,10 @@ func (model Model) RunInstrumentedTestsCmd(
 		"-w", // Tells `am` (activity manager) to wait for instrumentation to finish before returning
 	}
 
+	if rawOutput {
+		args = append(args, "-r")
+	}
+
 	if len(additionalTestingOptions) > 0 {
 		args = append(args, "-e")
 		args = append(args, additionalTestingOptions...)
@@ -88,39 +123,34 @@ func (model Model) RunInstrumentedTestsCmd(
 	component := packageName + "/" + testRunnerClass
 	args = append(args, component)
 
-	cmd := model.cmdFactory.Create(model.binPth, args, commandOptions)
-	return cmd
+	return args
 }
 
+// WaitForDevice blocks until the device with the given serial boots or the timeout is reached.
+// The adb server is restarted if the device can't be reached.
 func (model Model) WaitForDevice(serial string, timeout time.Duration) error {
-	startTime := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	for {
-		model.logger.Printf("Waiting for emulator to boot...")
-
-		bootCompleteChan := model.getBootCompleteEvent(serial, timeout)
-		result := <-bootCompleteChan
-		switch {
-		case result.Error != nil:
-			model.logger.Warnf("Failed to check emulator boot status: %s", result.Error)
-			model.logger.Warnf("Killing ADB server before retry...")
-			killCmd := model.KillServerCmd(nil)
-			if out, err := killCmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
-				return fmt.Errorf("terminate adb server: %s", out)
-			}
-		case result.Booted:
-			model.logger.Donef("Device boot completed in %d seconds", time.Since(startTime)/time.Second)
-			return nil
-		}
+	model.logger.Printf("Waiting for emulator to boot...")
 
-		if time.Now().After(startTime.Add(timeout)) {
-			return fmt.Errorf("emulator boot check timed out after %s seconds", time.Since(startTime)/time.Second)
+	opts := WaitOptions{
+		TargetStage: BootStageBootCompleted,
+		OnStage: func(stage BootStage, elapsed time.Duration) {
+			model.logger.Printf("Device state: %s (%d seconds)", stage, elapsed/time.Second)
+		},
+		RestartServerAfterFailures: 1,
+	}
+	startTime := time.Now()
+	if err := model.WaitForDeviceContext(ctx, serial, opts); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("emulator boot check timed out after %d seconds: %w", time.Since(startTime)/time.Second, err)
 		}
-
-		delay := 5 * time.Second
-		model.logger.Printf("Device is online but still booting, retrying in %d seconds", delay/time.Second)
-		time.Sleep(delay)
+		return err
 	}
+
+	model.logger.Donef("Device boot completed in %d seconds", time.Since(startTime)/time.Second)
+	return nil
 }
 
 // WaitForDeviceThenShellCmd returns a command that first waits for a device to come online, then executes the provided
@@ -133,13 +163,13 @@ func (model Model) WaitForDeviceThenShellCmd(serial string, commandOptions *comm
 	args = append(args, "wait-for-device", "shell")
 	args = append(args, commands...)
 
-	cmd := model.cmdFactory.Create(model.binPth, args, commandOptions)
+	cmd := model.adbCmd(args, commandOptions)
 	return cmd
 }
 
 // KillServerCmd returns a command that kills the ADB server if it is running.
 // The next ADB command will automatically start the server.
 func (model Model) KillServerCmd(commandOptions *command.Opts) command.Command {
-	cmd := model.cmdFactory.Create(model.binPth, []string{"kill-server"}, commandOptions)
+	cmd := model.adbCmd([]string{"kill-server"}, commandOptions)
 	return cmd
 }
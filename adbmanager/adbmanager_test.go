@@ -1,10 +1,17 @@
 package adbmanager
 
 import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/bitrise-io/go-utils/v2/command"
 	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
 	"github.com/stretchr/testify/require"
 )
 
@@ -63,6 +70,26 @@ func Test_GivenAdditionalTestingOptions_WhenCreateRunInstrumentedTestsCmd_ThenCr
 	require.Equal(t, expectedArgs, actualArgs)
 }
 
+func Test_GivenAdditionalTestingOptions_WhenCreateRunInstrumentedTestsRawCmd_ThenCreatesExpectedCommand(t *testing.T) {
+	// Given
+	mockPackageName := "com.package.name"
+	mockTestRunnerClass := "mock.testrunner.class"
+	mockAdditionalTestingOptions := []string{"opt1", "opt2"}
+
+	// When
+	testCommand := mockModel().RunInstrumentedTestsRawCmd(
+		mockPackageName,
+		mockTestRunnerClass,
+		mockAdditionalTestingOptions,
+		&command.Opts{},
+	)
+
+	// Then
+	actualArgs := testCommand.PrintableCommandArgs()
+	expectedArgs := `adb "shell" "am" "instrument" "-w" "-r" "-e" "opt1" "opt2" "com.package.name/mock.testrunner.class"`
+	require.Equal(t, expectedArgs, actualArgs)
+}
+
 func Test_GivenShellCommand_WhenCreateWaitForDeviceCmd_ThenCreatesExpectedCommand(t *testing.T) {
 	// Given
 	serial := "emulator-5554"
@@ -89,3 +116,223 @@ func mockModel() Model {
 		cmdFactory: command.NewFactory(env.NewRepository()),
 	}
 }
+
+func mockModelWithFactory(factory command.Factory) Model {
+	return Model{
+		binPth:     "adb",
+		cmdFactory: factory,
+		logger:     log.NewLogger(),
+	}
+}
+
+// fakeResult is the outcome of a command created by fakeCommandFactory.
+type fakeResult struct {
+	stdout   string
+	stderr   string
+	exitCode int
+	// delay postpones the command's completion, used to simulate hanging adb calls.
+	delay time.Duration
+}
+
+// fakeCommandFactory creates commands which don't execute anything, but return the result of the handler
+// for the given arguments. All created commands are recorded.
+type fakeCommandFactory struct {
+	handler func(args []string) fakeResult
+
+	mu    sync.Mutex
+	calls [][]string
+}
+
+func newFakeCommandFactory(handler func(args []string) fakeResult) *fakeCommandFactory {
+	return &fakeCommandFactory{handler: handler}
+}
+
+func (f *fakeCommandFactory) Create(name string, args []string, opts *command.Opts) command.Command {
+	return f.CreateWithContext(context.Background(), name, args, opts)
+}
+
+// CreateWithContext creates a command which is killed once ctx is done, before its delay is over.
+func (f *fakeCommandFactory) CreateWithContext(ctx context.Context, name string, args []string, opts *command.Opts) command.Command {
+	f.mu.Lock()
+	f.calls = append(f.calls, args)
+	f.mu.Unlock()
+
+	return &fakeCommand{ctx: ctx, name: name, args: args, opts: opts, handler: f.handler}
+}
+
+func (f *fakeCommandFactory) Calls() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([][]string{}, f.calls...)
+}
+
+type fakeCommand struct {
+	ctx     context.Context
+	name    string
+	args    []string
+	opts    *command.Opts
+	handler func(args []string) fakeResult
+
+	done chan fakeResult
+}
+
+func (c *fakeCommand) PrintableCommandArgs() string {
+	quoted := []string{c.name}
+	for _, arg := range c.args {
+		quoted = append(quoted, fmt.Sprintf("%q", arg))
+	}
+	return strings.Join(quoted, " ")
+}
+
+func (c *fakeCommand) execute() (fakeResult, error) {
+	result := c.handler(c.args)
+	timer := time.NewTimer(result.delay)
+	defer timer.Stop()
+	select {
+	case <-c.ctx.Done():
+		return fakeResult{exitCode: -1}, fmt.Errorf("signal: killed (%s)", c.PrintableCommandArgs())
+	case <-timer.C:
+	}
+
+	if result.exitCode != 0 {
+		return result, fmt.Errorf("command failed with exit status %d (%s)", result.exitCode, c.PrintableCommandArgs())
+	}
+	return result, nil
+}
+
+func (c *fakeCommand) writeOutputs(result fakeResult) {
+	if c.opts == nil {
+		return
+	}
+	if c.opts.Stdout != nil {
+		_, _ = io.WriteString(c.opts.Stdout, result.stdout)
+	}
+	if c.opts.Stderr != nil {
+		_, _ = io.WriteString(c.opts.Stderr, result.stderr)
+	}
+}
+
+func (c *fakeCommand) Run() error {
+	result, err := c.execute()
+	c.writeOutputs(result)
+	return err
+}
+
+func (c *fakeCommand) RunAndReturnExitCode() (int, error) {
+	result, err := c.execute()
+	c.writeOutputs(result)
+	return result.exitCode, err
+}
+
+func (c *fakeCommand) RunAndReturnTrimmedOutput() (string, error) {
+	result, err := c.execute()
+	return strings.TrimSpace(result.stdout), err
+}
+
+func (c *fakeCommand) RunAndReturnTrimmedCombinedOutput() (string, error) {
+	result, err := c.execute()
+	return strings.TrimSpace(result.stdout + result.stderr), err
+}
+
+func (c *fakeCommand) Start() error {
+	c.done = make(chan fakeResult, 1)
+	go func() {
+		result, _ := c.execute()
+		c.writeOutputs(result)
+		c.done <- result
+	}()
+	return nil
+}
+
+func (c *fakeCommand) Wait() error {
+	result := <-c.done
+	if result.exitCode != 0 {
+		return fmt.Errorf("command failed with exit status %d (%s)", result.exitCode, c.PrintableCommandArgs())
+	}
+	return nil
+}
+
+// interruptibleCommandFactory fakes the device processes started with interruptibleShellArgs: the n-th started
+// process prints outputs[n]. The last process runs until it is interrupted with `kill -INT`, the previous ones exit
+// right after printing their output. Other commands succeed without output.
+type interruptibleCommandFactory struct {
+	*fakeCommandFactory
+	outputs    []string
+	ignoreKill bool
+	killed     chan struct{}
+	// outputDelay postpones printing the output (and the pid) of the started processes.
+	outputDelay time.Duration
+
+	mu      sync.Mutex
+	started int
+}
+
+func newInterruptibleCommandFactory(outputs ...string) *interruptibleCommandFactory {
+	return &interruptibleCommandFactory{
+		fakeCommandFactory: newFakeCommandFactory(func(args []string) fakeResult { return fakeResult{} }),
+		outputs:            outputs,
+		killed:             make(chan struct{}),
+	}
+}
+
+func (f *interruptibleCommandFactory) Create(name string, args []string, opts *command.Opts) command.Command {
+	cmd := f.fakeCommandFactory.Create(name, args, opts)
+	switch {
+	case len(args) > 3 && args[3] == "echo":
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		c := &interruptibleCommand{Command: cmd, opts: opts, output: f.outputs[f.started], outputDelay: f.outputDelay, exit: f.killed}
+		if f.started < len(f.outputs)-1 {
+			exited := make(chan struct{})
+			close(exited)
+			c.exit = exited
+		}
+		f.started++
+		return c
+	case len(args) > 3 && args[3] == "kill" && !f.ignoreKill:
+		f.release()
+	}
+	return cmd
+}
+
+func (f *interruptibleCommandFactory) CreateWithContext(ctx context.Context, name string, args []string, opts *command.Opts) command.Command {
+	return f.Create(name, args, opts)
+}
+
+func (f *interruptibleCommandFactory) release() {
+	close(f.killed)
+}
+
+type interruptibleCommand struct {
+	command.Command
+	opts        *command.Opts
+	output      string
+	outputDelay time.Duration
+	exit        chan struct{}
+	done        chan struct{}
+}
+
+func (c *interruptibleCommand) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+func (c *interruptibleCommand) Start() error {
+	c.done = make(chan struct{})
+	go func() {
+		time.Sleep(c.outputDelay)
+		_, _ = io.WriteString(c.opts.Stdout, c.output)
+		<-c.exit
+		close(c.done)
+	}()
+	return nil
+}
+
+func (c *interruptibleCommand) Wait() error {
+	<-c.done
+	return nil
+}
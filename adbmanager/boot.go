@@ -1,48 +1,203 @@
 package adbmanager
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
 )
 
-type WaitForBootCompleteResult struct {
-	// Booted is true if the device is online AND it's booted. A false value doesn't
-	// necessarily mean an error, a retry might resolve things.
-	Booted bool
-	// Error signals a non-retryable problem.
-	Error  error
-}
-
-func (model *Model) getBootCompleteEvent(serial string, timeout time.Duration) <-chan WaitForBootCompleteResult {
-	doneChan := make(chan WaitForBootCompleteResult)
-
-	go func() {
-		time.AfterFunc(timeout, func() {
-			doneChan <- WaitForBootCompleteResult{Error: fmt.Errorf("timeout while waiting for boot complete event")}
-		})
-	}()
-
-	go func() {
-		cmd := model.WaitForDeviceThenShellCmd(serial, nil, "getprop sys.boot_completed")
-		out, err := cmd.RunAndReturnTrimmedCombinedOutput()
-		if err != nil {
-			fmt.Println(cmd.PrintableCommandArgs())
-			fmt.Println(out)
-			doneChan <- WaitForBootCompleteResult{Error: err}
-			return
+// BootStage is a step of the device boot sequence, in the order they are reached by a booting device.
+type BootStage int
+
+// Boot stages checked by WaitForDeviceContext.
+const (
+	// BootStageOffline means the device is not (yet) visible to adb, or it is offline.
+	BootStageOffline BootStage = iota
+	// BootStageDevice means adb reports the device in `device` state.
+	BootStageDevice
+	// BootStageBootCompleted means `sys.boot_completed` is set.
+	BootStageBootCompleted
+	// BootStageDevBootComplete means `dev.bootcomplete` is set.
+	BootStageDevBootComplete
+	// BootStagePackageManagerReady means the package manager service responds to queries.
+	BootStagePackageManagerReady
+	// BootStageLauncherIdle means a window has input focus, so the UI is ready to be driven.
+	BootStageLauncherIdle
+)
+
+func (stage BootStage) String() string {
+	switch stage {
+	case BootStageOffline:
+		return "offline"
+	case BootStageDevice:
+		return "device"
+	case BootStageBootCompleted:
+		return "sys.boot_completed"
+	case BootStageDevBootComplete:
+		return "dev.bootcomplete"
+	case BootStagePackageManagerReady:
+		return "package manager ready"
+	case BootStageLauncherIdle:
+		return "launcher idle"
+	default:
+		return fmt.Sprintf("unknown (%d)", int(stage))
+	}
+}
+
+// Backoff configures the delay between two boot state checks.
+// The delay starts at Initial, is multiplied by Multiplier after every unsuccessful check up to Max,
+// and is reset to Initial whenever the device advances to a new stage.
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+}
+
+// DefaultBackoff ...
+var DefaultBackoff = Backoff{
+	Initial:    time.Second,
+	Max:        5 * time.Second,
+	Multiplier: 2,
+}
+
+func (backoff Backoff) next(current time.Duration) time.Duration {
+	next := time.Duration(float64(current) * backoff.Multiplier)
+	if next < backoff.Initial {
+		next = backoff.Initial
+	}
+	if backoff.Max > 0 && next > backoff.Max {
+		next = backoff.Max
+	}
+	return next
+}
+
+// WaitOptions configures WaitForDeviceContext.
+type WaitOptions struct {
+	// TargetStage is the stage to wait for, defaults to BootStageLauncherIdle.
+	TargetStage BootStage
+	// Backoff defaults to DefaultBackoff.
+	Backoff *Backoff
+	// OnStage is called every time the device reaches a new stage, or falls back to an earlier one.
+	OnStage func(stage BootStage, elapsed time.Duration)
+	// RestartServerAfterFailures kills the adb server after the given number of consecutive failed adb calls,
+	// the next adb call starts it again. Zero disables restarting the server.
+	RestartServerAfterFailures int
+}
+
+// WaitForDeviceContext blocks until the device with the given serial reaches opts.TargetStage,
+// or ctx is done.
+func (model Model) WaitForDeviceContext(ctx context.Context, serial string, opts WaitOptions) error {
+	target := opts.TargetStage
+	if target == BootStageOffline {
+		target = BootStageLauncherIdle
+	}
+	backoff := DefaultBackoff
+	if opts.Backoff != nil {
+		backoff = *opts.Backoff
+	}
+
+	startTime := time.Now()
+	stage := BootStageOffline
+	delay := backoff.Initial
+	failures := 0
+
+	for {
+		reached, err := model.checkBootStage(ctx, serial, stage+1)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("wait for device (%s) to reach %s, last stage: %s: %w", serial, target, stage, ctxErr)
 		}
 
-		lines := strings.Split(out, "\n")
-		for _, line := range lines {
-			if strings.TrimSpace(line) == "1" {
-				doneChan <- WaitForBootCompleteResult{Booted: true}
-				return
+		switch {
+		case err != nil:
+			failures++
+			if stage != BootStageOffline {
+				stage = BootStageOffline
+				model.notifyBootStage(opts, stage, startTime)
 			}
+			if opts.RestartServerAfterFailures > 0 && failures >= opts.RestartServerAfterFailures {
+				failures = 0
+				model.logger.Warnf("Failed to check device (%s) boot state: %s", serial, err)
+				model.logger.Warnf("Killing ADB server before retry...")
+				if out, err := model.KillServerCmd(nil).RunAndReturnTrimmedCombinedOutput(); err != nil {
+					return fmt.Errorf("terminate adb server: %s", out)
+				}
+			}
+		case reached:
+			failures = 0
+			stage++
+			delay = backoff.Initial
+			model.notifyBootStage(opts, stage, startTime)
+			if stage >= target {
+				return nil
+			}
+			continue
+		default:
+			failures = 0
+		}
+
+		if err := sleepWithContext(ctx, delay); err != nil {
+			return fmt.Errorf("wait for device (%s) to reach %s, last stage: %s: %w", serial, target, stage, err)
 		}
+		delay = backoff.next(delay)
+	}
+}
+
+func (model Model) notifyBootStage(opts WaitOptions, stage BootStage, startTime time.Time) {
+	if opts.OnStage != nil {
+		opts.OnStage(stage, time.Since(startTime))
+	}
+}
 
-		doneChan <- WaitForBootCompleteResult{Booted: false}
-	}()
+// checkBootStage returns whether the device has reached the given stage. An error means adb could not reach the device.
+func (model Model) checkBootStage(ctx context.Context, serial string, stage BootStage) (bool, error) {
+	switch stage {
+	case BootStageDevice:
+		// adb exits with an error while the device is not yet visible or still offline,
+		// it counts as a failure so a wedged server is eventually restarted
+		state, err := model.deviceState(ctx, serial)
+		return state == DeviceStateDevice, err
+	case BootStageBootCompleted:
+		out, err := model.bootShell(ctx, serial, "getprop", "sys.boot_completed")
+		return lastLine(out) == "1", err
+	case BootStageDevBootComplete:
+		out, err := model.bootShell(ctx, serial, "getprop", "dev.bootcomplete")
+		return lastLine(out) == "1", err
+	case BootStagePackageManagerReady:
+		out, err := model.bootShell(ctx, serial, "pm", "path", "android")
+		return strings.HasPrefix(lastLine(out), "package:"), err
+	case BootStageLauncherIdle:
+		out, err := model.bootShell(ctx, serial, "dumpsys", "window")
+		return hasFocusedWindow(out), err
+	default:
+		return false, fmt.Errorf("unknown boot stage: %s", stage)
+	}
+}
+
+func (model Model) bootShell(ctx context.Context, serial string, args ...string) (string, error) {
+	cmd := model.adbCmdContext(ctx, deviceArgs(serial, append([]string{"shell"}, args...)...), nil)
+	return runWithContext(ctx, cmd)
+}
+
+// deviceArgs selects the device with the given serial, or leaves the selection to adb if serial is empty.
+func deviceArgs(serial string, args ...string) []string {
+	if serial == "" {
+		return args
+	}
+	return append([]string{"-s", serial}, args...)
+}
+
+func hasFocusedWindow(dumpsysWindowOut string) bool {
+	for _, line := range strings.Split(dumpsysWindowOut, "\n") {
+		focus, found := strings.CutPrefix(strings.TrimSpace(line), "mCurrentFocus=")
+		if found && focus != "null" && focus != "" {
+			return true
+		}
+	}
+	return false
+}
 
-	return doneChan
+func lastLine(out string) string {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
 }
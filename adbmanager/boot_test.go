@@ -0,0 +1,222 @@
+package adbmanager
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testBackoff = Backoff{Initial: time.Millisecond, Max: 2 * time.Millisecond, Multiplier: 2}
+
+func Test_GivenBootingDevice_WhenWaitForDeviceContext_ThenReportsEveryStage(t *testing.T) {
+	// Given
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		key := strings.Join(args[2:], " ")
+		mu.Lock()
+		attempts[key]++
+		attempt := attempts[key]
+		mu.Unlock()
+
+		switch key {
+		case "get-state":
+			if attempt == 1 {
+				return fakeResult{stderr: "error: device 'emulator-5554' not found", exitCode: 1}
+			}
+			return fakeResult{stdout: "device\n"}
+		case "shell getprop sys.boot_completed":
+			if attempt < 3 {
+				return fakeResult{stdout: "\n"}
+			}
+			return fakeResult{stdout: "1\n"}
+		case "shell getprop dev.bootcomplete":
+			return fakeResult{stdout: "1\n"}
+		case "shell pm path android":
+			if attempt == 1 {
+				return fakeResult{stdout: "Error: Could not access the Package Manager.  Is the system running?\n"}
+			}
+			return fakeResult{stdout: "package:/system/framework/framework-res.apk\n"}
+		case "shell dumpsys window":
+			return fakeResult{stdout: dumpsysWindowFocused}
+		}
+		return fakeResult{exitCode: 1}
+	})
+
+	var stages []BootStage
+	opts := WaitOptions{
+		Backoff: &testBackoff,
+		OnStage: func(stage BootStage, _ time.Duration) {
+			stages = append(stages, stage)
+		},
+	}
+
+	// When
+	err := mockModelWithFactory(factory).WaitForDeviceContext(context.Background(), "emulator-5554", opts)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []BootStage{
+		BootStageDevice,
+		BootStageBootCompleted,
+		BootStageDevBootComplete,
+		BootStagePackageManagerReady,
+		BootStageLauncherIdle,
+	}, stages)
+	require.Equal(t, []string{"-s", "emulator-5554", "get-state"}, factory.Calls()[0])
+}
+
+func Test_GivenDeviceGoesOffline_WhenWaitForDeviceContext_ThenFallsBackToOfflineAndRestartsServer(t *testing.T) {
+	// Given
+	var mu sync.Mutex
+	shellFailed := false
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		switch strings.Join(args, " ") {
+		case "kill-server":
+			return fakeResult{}
+		case "-s emulator-5554 get-state":
+			return fakeResult{stdout: "device"}
+		case "-s emulator-5554 shell getprop sys.boot_completed":
+			mu.Lock()
+			defer mu.Unlock()
+			if !shellFailed {
+				shellFailed = true
+				return fakeResult{stderr: "error: device offline", exitCode: 1}
+			}
+			return fakeResult{stdout: "1"}
+		}
+		return fakeResult{exitCode: 1}
+	})
+
+	var stages []BootStage
+	opts := WaitOptions{
+		TargetStage: BootStageBootCompleted,
+		Backoff:     &testBackoff,
+		OnStage: func(stage BootStage, _ time.Duration) {
+			stages = append(stages, stage)
+		},
+		RestartServerAfterFailures: 1,
+	}
+
+	// When
+	err := mockModelWithFactory(factory).WaitForDeviceContext(context.Background(), "emulator-5554", opts)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []BootStage{BootStageDevice, BootStageOffline, BootStageDevice, BootStageBootCompleted}, stages)
+	require.Contains(t, factory.Calls(), []string{"kill-server"})
+}
+
+func Test_GivenGetStateFails_WhenWaitForDeviceContext_ThenRestartsServer(t *testing.T) {
+	// Given
+	var mu sync.Mutex
+	serverKilled := false
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		mu.Lock()
+		defer mu.Unlock()
+		switch strings.Join(args, " ") {
+		case "kill-server":
+			serverKilled = true
+			return fakeResult{}
+		case "-s emulator-5554 get-state":
+			if !serverKilled {
+				return fakeResult{stderr: "error: protocol fault (couldn't read status): Connection reset by peer", exitCode: 1}
+			}
+			return fakeResult{stdout: "device"}
+		}
+		return fakeResult{exitCode: 1}
+	})
+
+	// When
+	err := mockModelWithFactory(factory).WaitForDeviceContext(context.Background(), "emulator-5554", WaitOptions{
+		TargetStage:                BootStageDevice,
+		Backoff:                    &testBackoff,
+		RestartServerAfterFailures: 1,
+	})
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"-s", "emulator-5554", "get-state"},
+		{"kill-server"},
+		{"-s", "emulator-5554", "get-state"},
+	}, factory.Calls())
+}
+
+func Test_GivenHangingAdb_WhenContextIsCancelled_ThenWaitForDeviceContextReturns(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: "device", delay: time.Second}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// When
+	startTime := time.Now()
+	err := mockModelWithFactory(factory).WaitForDeviceContext(ctx, "emulator-5554", WaitOptions{Backoff: &testBackoff})
+
+	// Then
+	require.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	require.Less(t, time.Since(startTime), time.Second)
+}
+
+func Test_GivenNotBootedDevice_WhenContextTimesOut_ThenReturnsLastStage(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		if args[len(args)-1] == "get-state" {
+			return fakeResult{stdout: "device"}
+		}
+		return fakeResult{stdout: "0"}
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	// When
+	err := mockModelWithFactory(factory).WaitForDeviceContext(ctx, "emulator-5554", WaitOptions{Backoff: &testBackoff})
+
+	// Then
+	require.EqualError(t, err, "wait for device (emulator-5554) to reach launcher idle, last stage: device: context deadline exceeded")
+}
+
+func Test_GivenNoSerial_WhenWaitForDeviceContext_ThenAdbSelectsTheDevice(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		switch strings.Join(args, " ") {
+		case "get-state":
+			return fakeResult{stdout: "device"}
+		case "shell getprop sys.boot_completed":
+			return fakeResult{stdout: "1"}
+		}
+		return fakeResult{exitCode: 1}
+	})
+
+	// When
+	err := mockModelWithFactory(factory).WaitForDeviceContext(context.Background(), "", WaitOptions{
+		TargetStage: BootStageBootCompleted,
+		Backoff:     &testBackoff,
+	})
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"get-state"}, {"shell", "getprop", "sys.boot_completed"}}, factory.Calls())
+}
+
+func Test_hasFocusedWindow(t *testing.T) {
+	require.True(t, hasFocusedWindow(dumpsysWindowFocused))
+	require.False(t, hasFocusedWindow(`WINDOW MANAGER WINDOWS (dumpsys window windows)
+  mCurrentFocus=null
+  mFocusedApp=null
+`))
+}
+
+const dumpsysWindowFocused = `WINDOW MANAGER WINDOWS (dumpsys window windows)
+  Window #0 Window{a1b2c3 u0 com.google.android.apps.nexuslauncher/com.google.android.apps.nexuslauncher.NexusLauncherActivity}:
+    mDisplayId=0 rootTaskId=1 mSession=Session{e4f5 1234:u0a10123} mClient=android.os.BinderProxy@9a8b7c
+  mCurrentFocus=Window{a1b2c3 u0 com.google.android.apps.nexuslauncher/com.google.android.apps.nexuslauncher.NexusLauncherActivity}
+  mFocusedApp=ActivityRecord{d6e7f8 u0 com.google.android.apps.nexuslauncher/.NexusLauncherActivity t7}
+`
@@ -0,0 +1,236 @@
+package adbmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
+)
+
+// processWaitDelay bounds waiting for the outputs of a killed process, which might be held open by its children.
+const processWaitDelay = 5 * time.Second
+
+// ContextFactory creates commands whose process is killed once ctx is done.
+// The context-aware APIs of Model use the command factory if it implements ContextFactory, otherwise they fall back to
+// its Create method: ctx is checked before and after every command, but a hanging `command.Command` can't be killed.
+type ContextFactory interface {
+	command.Factory
+	CreateWithContext(ctx context.Context, name string, args []string, opts *command.Opts) command.Command
+}
+
+type contextFactory struct {
+	command.Factory
+	envRepository env.Repository
+}
+
+// NewContextFactory returns a ContextFactory creating commands the same way as command.NewFactory.
+func NewContextFactory(envRepository env.Repository) ContextFactory {
+	return contextFactory{Factory: command.NewFactory(envRepository), envRepository: envRepository}
+}
+
+// CreateWithContext ...
+func (f contextFactory) CreateWithContext(ctx context.Context, name string, args []string, opts *command.Opts) command.Command {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.WaitDelay = processWaitDelay
+	if opts != nil {
+		cmd.Stdout = opts.Stdout
+		cmd.Stderr = opts.Stderr
+		cmd.Stdin = opts.Stdin
+		cmd.Env = append(f.envRepository.List(), opts.Env...)
+		cmd.Dir = opts.Dir
+	}
+	c := &contextCommand{cmd: cmd}
+	if opts != nil {
+		c.errorFinder = opts.ErrorFinder
+	}
+	return c
+}
+
+// contextCommand is a command.Command backed by a context bound exec.Cmd.
+type contextCommand struct {
+	cmd         *exec.Cmd
+	errorFinder command.ErrorFinder
+
+	mu         sync.Mutex
+	errorLines []string
+}
+
+func (c *contextCommand) PrintableCommandArgs() string {
+	quoted := []string{c.cmd.Args[0]}
+	for _, arg := range c.cmd.Args[1:] {
+		quoted = append(quoted, fmt.Sprintf("\"%s\"", arg))
+	}
+	return strings.Join(quoted, " ")
+}
+
+func (c *contextCommand) Run() error {
+	c.wrapOutputs()
+	return c.wrapError(c.cmd.Run())
+}
+
+func (c *contextCommand) RunAndReturnExitCode() (int, error) {
+	c.wrapOutputs()
+	err := c.cmd.Run()
+	return c.cmd.ProcessState.ExitCode(), c.wrapError(err)
+}
+
+func (c *contextCommand) RunAndReturnTrimmedOutput() (string, error) {
+	out, err := c.cmd.Output()
+	if err != nil {
+		c.collectErrors(out)
+	}
+	return strings.TrimSpace(string(out)), c.wrapError(err)
+}
+
+func (c *contextCommand) RunAndReturnTrimmedCombinedOutput() (string, error) {
+	out, err := c.cmd.CombinedOutput()
+	if err != nil {
+		c.collectErrors(out)
+	}
+	return strings.TrimSpace(string(out)), c.wrapError(err)
+}
+
+func (c *contextCommand) Start() error {
+	c.wrapOutputs()
+	return c.cmd.Start()
+}
+
+func (c *contextCommand) Wait() error {
+	return c.wrapError(c.cmd.Wait())
+}
+
+func (c *contextCommand) wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		c.mu.Lock()
+		errorLines := c.errorLines
+		c.mu.Unlock()
+		return command.NewExitStatusError(c.PrintableCommandArgs(), exitErr, errorLines)
+	}
+	return fmt.Errorf("executing command failed (%s): %w", c.PrintableCommandArgs(), err)
+}
+
+// Write collects the error lines of the command outputs, the same way as the commands of command.NewFactory.
+func (c *contextCommand) Write(p []byte) (int, error) {
+	c.collectErrors(p)
+	return len(p), nil
+}
+
+func (c *contextCommand) collectErrors(out []byte) {
+	if c.errorFinder == nil {
+		return
+	}
+	lines := c.errorFinder(string(out))
+	c.mu.Lock()
+	c.errorLines = append(c.errorLines, lines...)
+	c.mu.Unlock()
+}
+
+func (c *contextCommand) wrapOutputs() {
+	if c.errorFinder == nil {
+		return
+	}
+	if c.cmd.Stdout != nil {
+		c.cmd.Stdout = io.MultiWriter(c, c.cmd.Stdout)
+	} else {
+		c.cmd.Stdout = c
+	}
+	if c.cmd.Stderr != nil {
+		c.cmd.Stderr = io.MultiWriter(c, c.cmd.Stderr)
+	} else {
+		c.cmd.Stderr = c
+	}
+}
+
+// adbCmdContext is adbCmd for commands which are killed once ctx is done, if the command factory is a ContextFactory.
+func (model Model) adbCmdContext(ctx context.Context, args []string, opts *command.Opts) command.Command {
+	if factory, ok := model.cmdFactory.(ContextFactory); ok {
+		return factory.CreateWithContext(ctx, model.binPth, model.serverArgs(args), opts)
+	}
+	return model.adbCmd(args, opts)
+}
+
+// runWithContext runs a command created by adbCmdContext and returns its trimmed stdout, or ctx's error if the
+// command was killed because ctx is done.
+func runWithContext(ctx context.Context, cmd command.Command) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	out, err := cmd.RunAndReturnTrimmedOutput()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return "", ctxErr
+	}
+	return out, err
+}
+
+// runAndReturnExitCodeWithContext is the same as runWithContext for commands writing to their own outputs.
+func runAndReturnExitCodeWithContext(ctx context.Context, cmd command.Command) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return -1, err
+	}
+
+	exitCode, err := cmd.RunAndReturnExitCode()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return -1, ctxErr
+	}
+	return exitCode, err
+}
+
+// sleepWithContext waits for the given duration, or returns ctx's error if it is done first.
+func sleepWithContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// interruptibleShellArgs returns the arguments of an `adb shell` command, which prints the pid of the device process
+// on its first output line. `command.Command` can't be killed, but the device process can be interrupted by its pid
+// (see interruptDeviceProcess), which makes the adb process exit too. The arguments are quoted for the device shell.
+func interruptibleShellArgs(serial string, args ...string) []string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quoteShellArg(arg)
+	}
+	return interruptibleQuotedShellArgs(serial, quoted...)
+}
+
+// interruptibleQuotedShellArgs is interruptibleShellArgs for arguments which are already quoted for the device shell.
+func interruptibleQuotedShellArgs(serial string, quotedArgs ...string) []string {
+	return append([]string{"-s", serial, "shell", "echo", "$$;", "exec"}, quotedArgs...)
+}
+
+// interruptDeviceProcess sends SIGINT to the device process, started with interruptibleShellArgs.
+func (model Model) interruptDeviceProcess(ctx context.Context, serial, pid string) error {
+	cmd := model.adbCmdContext(ctx, []string{"-s", serial, "shell", "kill", "-INT", pid}, nil)
+	if out, err := runWithContext(ctx, cmd); err != nil {
+		return fmt.Errorf("interrupt process %s: %s: %w", pid, out, err)
+	}
+	return nil
+}
+
+// parsePIDLine returns the pid printed by an interruptibleShellArgs command.
+func parsePIDLine(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if _, err := strconv.Atoi(line); err != nil {
+		return "", false
+	}
+	return line, true
+}
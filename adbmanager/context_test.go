@@ -0,0 +1,67 @@
+package adbmanager
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GivenRunningProcess_WhenContextIsCancelled_ThenProcessIsKilled(t *testing.T) {
+	// Given
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	cmd := NewContextFactory(env.NewRepository()).CreateWithContext(ctx, "sleep", []string{"10"}, nil)
+
+	// When
+	startTime := time.Now()
+	_, err := runWithContext(ctx, cmd)
+
+	// Then
+	require.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	require.Less(t, time.Since(startTime), 5*time.Second)
+	require.False(t, cmd.(*contextCommand).cmd.ProcessState.Exited(), "the process should be killed by a signal")
+}
+
+func Test_GivenFactoryWithoutContextSupport_WhenAdbCmdContext_ThenCreatesCommandWithTheFactory(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: "device"}
+	})
+	model := mockModelWithFactory(struct{ command.Factory }{factory}).WithServerPort(5041)
+
+	// When
+	out, err := runWithContext(context.Background(), model.adbCmdContext(context.Background(), []string{"-s", "emulator-5554", "get-state"}, nil))
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "device", out)
+	require.Equal(t, [][]string{{"-P", "5041", "-s", "emulator-5554", "get-state"}}, factory.Calls())
+}
+
+func Test_GivenErrorFinder_WhenContextCommandFails_ThenErrorContainsTheFoundLines(t *testing.T) {
+	// Given
+	opts := &command.Opts{
+		ErrorFinder: func(out string) []string {
+			var lines []string
+			for _, line := range strings.Split(out, "\n") {
+				if strings.HasPrefix(line, "error:") {
+					lines = append(lines, line)
+				}
+			}
+			return lines
+		},
+	}
+	cmd := NewContextFactory(env.NewRepository()).CreateWithContext(context.Background(), "sh", []string{"-c", "printf 'error: device %s\\n' offline >&2; exit 1"}, opts)
+
+	// When
+	err := cmd.Run()
+
+	// Then
+	require.ErrorContains(t, err, "error: device offline")
+}
@@ -0,0 +1,129 @@
+package adbmanager
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+// ErrNotDebuggable is returned when the coverage file in the app's private storage can't be read with `run-as`,
+// because the app is not a debuggable build.
+var ErrNotDebuggable = errors.New("package is not debuggable")
+
+// jacocoHeader starts every JaCoCo execution data file: the header block id and the 0xC0C0 magic number.
+var jacocoHeader = []byte{0x01, 0xC0, 0xC0}
+
+// CoverageOptions configures the coverage collection of an instrumented run.
+type CoverageOptions struct {
+	// TargetPackage is the package of the app under test, the test runner writes the coverage file into its private storage.
+	TargetPackage string
+	// DeviceFile is the path of the coverage file on the device, defaults to /data/data/<TargetPackage>/files/coverage.ec.
+	// Files under /data are read with `run-as`, which requires a debuggable build.
+	DeviceFile string
+	// OutputDir is the local directory of the pulled coverage files.
+	OutputDir string
+}
+
+func (opts CoverageOptions) deviceFile() string {
+	if opts.DeviceFile != "" {
+		return opts.DeviceFile
+	}
+	return "/data/data/" + opts.TargetPackage + "/files/coverage.ec"
+}
+
+// CoverageFileCmd builds and returns a `Command` writing the coverage file of the device to its stdout:
+// `adb exec-out [run-as <package>] cat <file>`.
+func (model Model) CoverageFileCmd(serial string, opts CoverageOptions, commandOptions *command.Opts) command.Command {
+	args := []string{"-s", serial, "exec-out"}
+	deviceFile := opts.deviceFile()
+	if strings.HasPrefix(deviceFile, "/data/") {
+		args = append(args, "run-as", opts.TargetPackage)
+	}
+	args = append(args, "cat", quoteShellArg(deviceFile))
+
+	return model.adbCmd(args, commandOptions)
+}
+
+// RemoveCoverageFile deletes the coverage file of the device: `adb shell [run-as <package>] rm -f <file>`.
+// Call it before the run, so the coverage file of a previous run isn't pulled if the runner doesn't write a new one.
+func (model Model) RemoveCoverageFile(serial string, opts CoverageOptions) error {
+	args := []string{"-s", serial, "shell"}
+	deviceFile := opts.deviceFile()
+	if strings.HasPrefix(deviceFile, "/data/") {
+		args = append(args, "run-as", opts.TargetPackage)
+	}
+	args = append(args, "rm", "-f", quoteShellArg(deviceFile))
+
+	out, err := model.adbCmd(args, nil).RunAndReturnTrimmedCombinedOutput()
+	// rm -f prints nothing, older devices exit with 0 even if run-as fails
+	if err != nil || out != "" {
+		return fmt.Errorf("remove coverage file: %w", coverageError(opts, out))
+	}
+	return nil
+}
+
+// PullCoverage copies the coverage file of the shard's device into opts.OutputDir, as
+// coverage-<serial>-shard<index>.ec, and returns its path.
+func (model Model) PullCoverage(shard ShardInfo, opts CoverageOptions) (string, error) {
+	if err := os.MkdirAll(opts.OutputDir, 0o755); err != nil {
+		return "", fmt.Errorf("pull coverage: %w", err)
+	}
+	localPath := filepath.Join(opts.OutputDir, coverageFileName(shard))
+	file, err := os.Create(localPath)
+	if err != nil {
+		return "", fmt.Errorf("pull coverage: %w", err)
+	}
+
+	var stderr bytes.Buffer
+	cmd := model.CoverageFileCmd(shard.Serial, opts, &command.Opts{Stdout: file, Stderr: &stderr})
+	model.logger.Printf("$ %s", cmd.PrintableCommandArgs())
+	runErr := cmd.Run()
+	if err := file.Close(); err != nil && runErr == nil {
+		runErr = err
+	}
+
+	if runErr != nil {
+		err = fmt.Errorf("pull coverage of %s: %s: %w", shard, strings.TrimSpace(stderr.String()), runErr)
+	} else if header, readErr := readFileHeader(localPath, 512); readErr != nil {
+		err = fmt.Errorf("pull coverage of %s: %w", shard, readErr)
+	} else if !bytes.HasPrefix(header, jacocoHeader) {
+		// exec-out doesn't return the exit code of the device command, its errors are written to stdout
+		err = fmt.Errorf("pull coverage of %s: %w", shard, coverageError(opts, strings.TrimSpace(stderr.String()+string(header))))
+	}
+	if err != nil {
+		_ = os.Remove(localPath)
+		return "", err
+	}
+	return localPath, nil
+}
+
+func coverageError(opts CoverageOptions, out string) error {
+	switch {
+	case out == "":
+		return errors.New("coverage file is empty")
+	// run-as: package not debuggable: com.example, run-as: Package 'com.example' is not debuggable (before API 24)
+	case strings.Contains(out, "not debuggable"):
+		return fmt.Errorf("%s: %w, coverage can only be read from debuggable builds", opts.TargetPackage, ErrNotDebuggable)
+	// run-as: unknown package: com.example, run-as: Package 'com.example' is unknown (before API 24)
+	case strings.Contains(out, "unknown package") || strings.Contains(out, "is unknown"):
+		return fmt.Errorf("%s: %w", opts.TargetPackage, ErrPackageNotInstalled)
+	case strings.Contains(out, "No such file or directory"):
+		return fmt.Errorf("coverage file not found, was the instrumentation run with coverage enabled: %s", out)
+	}
+	return fmt.Errorf("output is not a coverage file: %s", out)
+}
+
+var unsafeFileNameCharPattern = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// coverageFileName returns the local name of the shard's coverage file, network device serials
+// (192.168.1.2:5555) are made file system safe.
+func coverageFileName(shard ShardInfo) string {
+	serial := unsafeFileNameCharPattern.ReplaceAllString(shard.Serial, "_")
+	return fmt.Sprintf("coverage-%s-shard%d.ec", serial, shard.Index)
+}
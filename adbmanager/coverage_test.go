@@ -0,0 +1,162 @@
+package adbmanager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const fakeCoverageData = "\x01\xc0\xc0\x10\x07execution data"
+
+func Test_GivenCoverageOptions_WhenRunShardedInstrumentedTests_ThenPullsCoverageOfEveryShard(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		switch {
+		case args[2] == "get-state":
+			return fakeResult{stdout: "device"}
+		case args[2] == "exec-out":
+			return fakeResult{stdout: fakeCoverageData + args[1]}
+		case args[3] == "run-as":
+			return fakeResult{}
+		}
+		return fakeResult{stdout: instrumentationOutput(instrumentationTest("com.example.LoginTest", "validLogin", 0))}
+	})
+	outputDir := t.TempDir()
+	opts := ShardedTestOptions{
+		PackageName:         "com.example.test",
+		TestRunnerClass:     "androidx.test.runner.AndroidJUnitRunner",
+		HealthCheckInterval: time.Millisecond,
+		Coverage:            &CoverageOptions{TargetPackage: "com.example", OutputDir: outputDir},
+	}
+
+	// When
+	result, err := mockModelWithFactory(factory).RunShardedInstrumentedTests(context.Background(), []string{"emulator-5554", "192.168.1.2:5555"}, opts)
+
+	// Then
+	require.NoError(t, err)
+	require.Contains(t, factory.Calls(), []string{
+		"-s", "emulator-5554", "shell", "am", "instrument", "-w", "-r",
+		"-e", "numShards", "2", "-e", "shardIndex", "0",
+		"-e", "coverage", "true", "-e", "coverageFile", "/data/data/com.example/files/coverage.ec",
+		"com.example.test/androidx.test.runner.AndroidJUnitRunner",
+	})
+	require.Contains(t, factory.Calls(), []string{"-s", "192.168.1.2:5555", "shell", "run-as", "com.example", "rm", "-f", "/data/data/com.example/files/coverage.ec"})
+	require.Contains(t, factory.Calls(), []string{"-s", "192.168.1.2:5555", "exec-out", "run-as", "com.example", "cat", "/data/data/com.example/files/coverage.ec"})
+
+	require.NoError(t, result.Shards[0].CoverageErr)
+	require.Equal(t, filepath.Join(outputDir, "coverage-emulator-5554-shard0.ec"), result.Shards[0].CoverageFile)
+	require.NoError(t, result.Shards[1].CoverageErr)
+	require.Equal(t, filepath.Join(outputDir, "coverage-192.168.1.2_5555-shard1.ec"), result.Shards[1].CoverageFile)
+	data, err := os.ReadFile(result.Shards[1].CoverageFile)
+	require.NoError(t, err)
+	require.Equal(t, fakeCoverageData+"192.168.1.2:5555", string(data))
+}
+
+func Test_GivenReleaseBuild_WhenRunShardedInstrumentedTestsWithCoverage_ThenRunsTestsWithoutPullingCoverage(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		switch {
+		case args[2] == "get-state":
+			return fakeResult{stdout: "device"}
+		case args[2] == "exec-out":
+			return fakeResult{stdout: fakeCoverageData}
+		case args[3] == "run-as":
+			return fakeResult{stdout: "run-as: package not debuggable: com.example", exitCode: 1}
+		}
+		return fakeResult{stdout: instrumentationOutput(instrumentationTest("com.example.LoginTest", "validLogin", 0))}
+	})
+	opts := ShardedTestOptions{
+		PackageName:         "com.example.test",
+		TestRunnerClass:     "androidx.test.runner.AndroidJUnitRunner",
+		HealthCheckInterval: time.Millisecond,
+		Coverage:            &CoverageOptions{TargetPackage: "com.example", OutputDir: t.TempDir()},
+	}
+
+	// When
+	result, err := mockModelWithFactory(factory).RunShardedInstrumentedTests(context.Background(), []string{"emulator-5554"}, opts)
+
+	// Then
+	require.NoError(t, err)
+	require.NoError(t, result.Shards[0].Err)
+	require.False(t, result.Failed())
+	require.ErrorIs(t, result.Shards[0].CoverageErr, ErrNotDebuggable)
+	require.Empty(t, result.Shards[0].CoverageFile)
+	for _, call := range factory.Calls() {
+		require.NotEqual(t, "exec-out", call[2])
+	}
+}
+
+func Test_GivenCoverageFileOption_WhenRunShardedInstrumentedTestsWithCoverage_ThenFails(t *testing.T) {
+	// Given
+	opts := ShardedTestOptions{
+		PackageName:     "com.example.test",
+		TestRunnerClass: "androidx.test.runner.AndroidJUnitRunner",
+		Options:         InstrumentationOptions{Coverage: true, CoverageFile: "/sdcard/coverage.ec"},
+		Coverage:        &CoverageOptions{TargetPackage: "com.example", OutputDir: t.TempDir()},
+	}
+
+	// When
+	_, err := mockModel().RunShardedInstrumentedTests(context.Background(), []string{"emulator-5554"}, opts)
+
+	// Then
+	require.EqualError(t, err, "coverage file is set by the coverage options of the sharded test run")
+}
+
+func Test_GivenReleaseBuild_WhenPullCoverage_ThenReturnsNotDebuggableError(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: "run-as: package not debuggable: com.example\n"}
+	})
+	outputDir := t.TempDir()
+
+	// When
+	_, err := mockModelWithFactory(factory).PullCoverage(ShardInfo{Serial: "emulator-5554"}, CoverageOptions{TargetPackage: "com.example", OutputDir: outputDir})
+
+	// Then
+	require.ErrorIs(t, err, ErrNotDebuggable)
+	require.EqualError(t, err, "pull coverage of shard 0 (emulator-5554): com.example: package is not debuggable, coverage can only be read from debuggable builds")
+	entries, err := os.ReadDir(outputDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func Test_GivenSharedStorageDeviceFile_WhenPullCoverage_ThenReadsWithoutRunAs(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: fakeCoverageData}
+	})
+	opts := CoverageOptions{TargetPackage: "com.example", DeviceFile: "/sdcard/Download/coverage.ec", OutputDir: t.TempDir()}
+
+	// When
+	pth, err := mockModelWithFactory(factory).PullCoverage(ShardInfo{Index: 2, Serial: "emulator-5554"}, opts)
+
+	// Then
+	require.NoError(t, err)
+	require.True(t, strings.HasSuffix(pth, "coverage-emulator-5554-shard2.ec"))
+	require.Equal(t, [][]string{{"-s", "emulator-5554", "exec-out", "cat", "/sdcard/Download/coverage.ec"}}, factory.Calls())
+}
+
+func Test_coverageError(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want string
+	}{
+		{name: "legacy not debuggable", out: "run-as: Package 'com.example' is not debuggable", want: "com.example: package is not debuggable, coverage can only be read from debuggable builds"},
+		{name: "unknown package", out: "run-as: unknown package: com.example", want: "com.example: package is not installed"},
+		{name: "missing file", out: "cat: coverage.ec: No such file or directory", want: "coverage file not found, was the instrumentation run with coverage enabled: cat: coverage.ec: No such file or directory"},
+		{name: "empty", out: "", want: "coverage file is empty"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := coverageError(CoverageOptions{TargetPackage: "com.example"}, tt.out).Error(); got != tt.want {
+				t.Errorf("coverageError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
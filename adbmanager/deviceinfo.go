@@ -0,0 +1,90 @@
+package adbmanager
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-android/v2/metaparser/androidartifact"
+)
+
+// DeviceInfo is the device information parsed from `getprop`.
+type DeviceInfo struct {
+	Serial string
+	// APILevel is ro.build.version.sdk, Release is ro.build.version.release (for example 14).
+	APILevel int
+	Release  string
+	// ABIs are the supported ABIs in order of preference (ro.product.cpu.abilist).
+	ABIs         []string
+	Manufacturer string
+	Model        string
+	// Emulator is true for the official emulator (ro.kernel.qemu or ro.boot.qemu).
+	Emulator bool
+	// Density is the screen density in dpi (ro.sf.lcd_density).
+	Density int
+	// Properties are all the system properties.
+	Properties map[string]string
+}
+
+// DeviceInfo returns the system properties of the device.
+func (model Model) DeviceInfo(serial string) (DeviceInfo, error) {
+	cmd := model.adbCmd([]string{"-s", serial, "shell", "getprop"}, nil)
+	out, err := cmd.RunAndReturnTrimmedOutput()
+	if err != nil {
+		return DeviceInfo{}, fmt.Errorf("getprop: %w", err)
+	}
+
+	info := parseDeviceInfo(parseGetprop(out))
+	info.Serial = serial
+	return info, nil
+}
+
+// SelectAPK returns the APK of the artifact which best matches the device's ABIs and screen density.
+func (info DeviceInfo) SelectAPK(artifact androidartifact.Artifact) (string, error) {
+	return androidartifact.SelectSplitAPK(artifact, info.ABIs, info.Density)
+}
+
+func parseDeviceInfo(props map[string]string) DeviceInfo {
+	info := DeviceInfo{
+		Release:      props["ro.build.version.release"],
+		Manufacturer: props["ro.product.manufacturer"],
+		Model:        props["ro.product.model"],
+		Emulator:     props["ro.kernel.qemu"] == "1" || props["ro.boot.qemu"] == "1",
+		Properties:   props,
+	}
+	info.APILevel, _ = strconv.Atoi(props["ro.build.version.sdk"])
+
+	density := props["ro.sf.lcd_density"]
+	if density == "" {
+		// set instead of ro.sf.lcd_density by some emulator images
+		density = props["qemu.sf.lcd_density"]
+	}
+	info.Density, _ = strconv.Atoi(density)
+
+	if abiList := props["ro.product.cpu.abilist"]; abiList != "" {
+		info.ABIs = strings.Split(abiList, ",")
+	} else {
+		// pre API 21 devices
+		for _, key := range []string{"ro.product.cpu.abi", "ro.product.cpu.abi2"} {
+			if abi := props[key]; abi != "" {
+				info.ABIs = append(info.ABIs, abi)
+			}
+		}
+	}
+
+	return info
+}
+
+// [ro.build.version.sdk]: [34]
+var getpropLinePattern = regexp.MustCompile(`^\[([^\]]+)\]: \[(.*)\]$`)
+
+func parseGetprop(out string) map[string]string {
+	props := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		if match := getpropLinePattern.FindStringSubmatch(strings.TrimSpace(line)); match != nil {
+			props[match[1]] = match[2]
+		}
+	}
+	return props
+}
@@ -0,0 +1,82 @@
+package adbmanager
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/go-android/v2/metaparser/androidartifact"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GivenEmulatorProperties_WhenDeviceInfo_ThenReturnsParsedInfo(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: `[dalvik.vm.heapsize]: [512m]
+[ro.boot.qemu]: [1]
+[ro.build.version.release]: [14]
+[ro.build.version.sdk]: [34]
+[ro.product.cpu.abilist]: [x86_64,arm64-v8a]
+[ro.product.manufacturer]: [Google]
+[ro.product.model]: [sdk_gphone64_x86_64]
+[ro.sf.lcd_density]: [420]
+`}
+	})
+
+	// When
+	info, err := mockModelWithFactory(factory).DeviceInfo("emulator-5554")
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"-s", "emulator-5554", "shell", "getprop"}}, factory.Calls())
+	require.Equal(t, "emulator-5554", info.Serial)
+	require.Equal(t, 34, info.APILevel)
+	require.Equal(t, "14", info.Release)
+	require.Equal(t, []string{"x86_64", "arm64-v8a"}, info.ABIs)
+	require.Equal(t, "Google", info.Manufacturer)
+	require.Equal(t, "sdk_gphone64_x86_64", info.Model)
+	require.True(t, info.Emulator)
+	require.Equal(t, 420, info.Density)
+	require.Equal(t, "512m", info.Properties["dalvik.vm.heapsize"])
+
+	apk, err := info.SelectAPK(androidartifact.Artifact{Split: []string{"app-arm64-v8a-debug.apk", "app-x86_64-debug.apk"}})
+	require.NoError(t, err)
+	require.Equal(t, "app-x86_64-debug.apk", apk)
+}
+
+func Test_parseDeviceInfo(t *testing.T) {
+	tests := []struct {
+		name  string
+		props map[string]string
+		want  DeviceInfo
+	}{
+		{
+			name: "pre API 21 physical device",
+			props: map[string]string{
+				"ro.build.version.sdk": "19",
+				"ro.product.cpu.abi":   "armeabi-v7a",
+				"ro.product.cpu.abi2":  "armeabi",
+				"ro.kernel.qemu":       "0",
+			},
+			want: DeviceInfo{
+				APILevel: 19,
+				ABIs:     []string{"armeabi-v7a", "armeabi"},
+			},
+		},
+		{
+			name: "legacy emulator density",
+			props: map[string]string{
+				"ro.kernel.qemu":      "1",
+				"qemu.sf.lcd_density": "320",
+			},
+			want: DeviceInfo{
+				Emulator: true,
+				Density:  320,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.want.Properties = tt.props
+			require.Equal(t, tt.want, parseDeviceInfo(tt.props))
+		})
+	}
+}
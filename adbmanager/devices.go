@@ -0,0 +1,135 @@
+package adbmanager
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DeviceState is the connection state of a device as reported by `adb devices`.
+type DeviceState string
+
+// Device states reported by adb, see: https://developer.android.com/studio/command-line/adb#devicestatus
+const (
+	DeviceStateDevice        DeviceState = "device"
+	DeviceStateOffline       DeviceState = "offline"
+	DeviceStateUnauthorized  DeviceState = "unauthorized"
+	DeviceStateAuthorizing   DeviceState = "authorizing"
+	DeviceStateConnecting    DeviceState = "connecting"
+	DeviceStateRecovery      DeviceState = "recovery"
+	DeviceStateRescue        DeviceState = "rescue"
+	DeviceStateSideload      DeviceState = "sideload"
+	DeviceStateBootloader    DeviceState = "bootloader"
+	DeviceStateHost          DeviceState = "host"
+	DeviceStateNoPermissions DeviceState = "no permissions"
+	DeviceStateUnknown       DeviceState = "unknown"
+)
+
+// Device is a single entry of the `adb devices -l` output.
+type Device struct {
+	Serial      string
+	State       DeviceState
+	TransportID string
+	USB         string
+	Product     string
+	Model       string
+	DeviceName  string
+	// Emulator is true if the serial has the `emulator-<console port>` form adb assigns to local emulators.
+	Emulator bool
+}
+
+// ListDevices runs `adb devices -l` and returns the attached devices and emulators.
+func (model Model) ListDevices() ([]Device, error) {
+	cmd := model.adbCmd([]string{"devices", "-l"}, nil)
+	out, err := cmd.RunAndReturnTrimmedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("list devices: %w", err)
+	}
+
+	return parseDevices(out), nil
+}
+
+func parseDevices(out string) []Device {
+	var devices []Device
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" ||
+			strings.HasPrefix(line, "*") || // * daemon not running; starting now at tcp:5037
+			strings.HasPrefix(line, "List of devices attached") ||
+			strings.HasPrefix(line, "adb server") { // adb server version (40) doesn't match this client (41); killing...
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		device := Device{Serial: fields[0]}
+		var stateFields []string
+		for _, field := range fields[1:] {
+			key, value, found := strings.Cut(field, ":")
+			if !found {
+				stateFields = append(stateFields, field)
+				continue
+			}
+
+			switch key {
+			case "usb":
+				device.USB = value
+			case "product":
+				device.Product = value
+			case "model":
+				device.Model = value
+			case "device":
+				device.DeviceName = value
+			case "transport_id":
+				device.TransportID = value
+			default:
+				// `no permissions` is followed by a free text hint which might contain a URL
+				stateFields = append(stateFields, field)
+			}
+		}
+
+		device.State = parseDeviceState(strings.Join(stateFields, " "))
+		device.Emulator = isEmulatorSerial(device.Serial)
+		devices = append(devices, device)
+	}
+
+	return devices
+}
+
+func parseDeviceState(state string) DeviceState {
+	if strings.HasPrefix(state, string(DeviceStateNoPermissions)) {
+		return DeviceStateNoPermissions
+	}
+
+	switch s := DeviceState(state); s {
+	case DeviceStateDevice,
+		DeviceStateOffline,
+		DeviceStateUnauthorized,
+		DeviceStateAuthorizing,
+		DeviceStateConnecting,
+		DeviceStateRecovery,
+		DeviceStateRescue,
+		DeviceStateSideload,
+		DeviceStateBootloader,
+		DeviceStateHost:
+		return s
+	default:
+		return DeviceStateUnknown
+	}
+}
+
+func isEmulatorSerial(serial string) bool {
+	port, found := strings.CutPrefix(serial, "emulator-")
+	if !found || port == "" {
+		return false
+	}
+
+	for _, r := range port {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
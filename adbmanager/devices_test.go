@@ -0,0 +1,85 @@
+package adbmanager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseDevices(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want []Device
+	}{
+		{
+			name: "No devices",
+			out:  "List of devices attached\n",
+			want: nil,
+		},
+		{
+			name: "Daemon start messages",
+			out: `* daemon not running; starting now at tcp:5037
+* daemon started successfully
+List of devices attached
+emulator-5554	device product:sdk_gphone64_x86_64 model:sdk_gphone64_x86_64 device:emu64xa transport_id:1
+`,
+			want: []Device{
+				{Serial: "emulator-5554", State: DeviceStateDevice, Product: "sdk_gphone64_x86_64", Model: "sdk_gphone64_x86_64", DeviceName: "emu64xa", TransportID: "1", Emulator: true},
+			},
+		},
+		{
+			name: "adb 1.0.32 (no transport id)",
+			out: `List of devices attached
+emulator-5554          device product:sdk_google_phone_x86 model:Android_SDK_built_for_x86 device:generic_x86
+0a388e93               device usb:1-1 product:razor model:Nexus_7 device:flo
+`,
+			want: []Device{
+				{Serial: "emulator-5554", State: DeviceStateDevice, Product: "sdk_google_phone_x86", Model: "Android_SDK_built_for_x86", DeviceName: "generic_x86", Emulator: true},
+				{Serial: "0a388e93", State: DeviceStateDevice, USB: "1-1", Product: "razor", Model: "Nexus_7", DeviceName: "flo"},
+			},
+		},
+		{
+			name: "adb 1.0.41 mixed states",
+			out: `List of devices attached
+emulator-5554          offline transport_id:1
+emulator-5556          device product:sdk_gphone_x86 model:sdk_gphone_x86 device:generic_x86_arm transport_id:2
+R58M41ABCDE            unauthorized usb:337641472X transport_id:3
+192.168.1.12:5555      device product:redfin model:Pixel_5 device:redfin transport_id:4
+HT4CJJT00001           recovery usb:1-2 product:omni_shamu model:Nexus_6 device:shamu transport_id:5
+`,
+			want: []Device{
+				{Serial: "emulator-5554", State: DeviceStateOffline, TransportID: "1", Emulator: true},
+				{Serial: "emulator-5556", State: DeviceStateDevice, Product: "sdk_gphone_x86", Model: "sdk_gphone_x86", DeviceName: "generic_x86_arm", TransportID: "2", Emulator: true},
+				{Serial: "R58M41ABCDE", State: DeviceStateUnauthorized, USB: "337641472X", TransportID: "3"},
+				{Serial: "192.168.1.12:5555", State: DeviceStateDevice, Product: "redfin", Model: "Pixel_5", DeviceName: "redfin", TransportID: "4"},
+				{Serial: "HT4CJJT00001", State: DeviceStateRecovery, USB: "1-2", Product: "omni_shamu", Model: "Nexus_6", DeviceName: "shamu", TransportID: "5"},
+			},
+		},
+		{
+			name: "No permissions",
+			out: `List of devices attached
+0123456789ABCDEF       no permissions (user in plugdev group; are your udev rules wrong?); see [http://developer.android.com/tools/device.html] usb:1-1 transport_id:6
+`,
+			want: []Device{
+				{Serial: "0123456789ABCDEF", State: DeviceStateNoPermissions, USB: "1-1", TransportID: "6"},
+			},
+		},
+		{
+			name: "Unknown state and mdns serial",
+			out: `List of devices attached
+adb-R58M41ABCDE-abc123._adb-tls-connect._tcp	host transport_id:7
+emulator-abc	weird transport_id:8
+`,
+			want: []Device{
+				{Serial: "adb-R58M41ABCDE-abc123._adb-tls-connect._tcp", State: DeviceStateHost, TransportID: "7"},
+				{Serial: "emulator-abc", State: DeviceStateUnknown, TransportID: "8"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, parseDevices(tt.out))
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package adbmanager
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+// DiagnosticKind ...
+type DiagnosticKind string
+
+// Diagnostic kinds ...
+const (
+	DiagnosticKindBugreport  DiagnosticKind = "bugreport"
+	DiagnosticKindANR        DiagnosticKind = "anr"
+	DiagnosticKindTombstones DiagnosticKind = "tombstones"
+	DiagnosticKindDumpsys    DiagnosticKind = "dumpsys"
+)
+
+// defaultDumpsysServices are the services dumped for the package if DiagnosticsOptions.DumpsysServices is empty.
+var defaultDumpsysServices = []string{"package", "meminfo", "gfxinfo"}
+
+// DiagnosticsOptions selects the diagnostics collected by CollectDiagnostics.
+type DiagnosticsOptions struct {
+	Bugreport  bool
+	ANRTraces  bool
+	Tombstones bool
+	// PackageName enables the `dumpsys <service> <package>` snapshots.
+	PackageName string
+	// DumpsysServices defaults to package, meminfo and gfxinfo.
+	DumpsysServices []string
+}
+
+// CollectedDiagnostic is a file or directory collected from the device.
+type CollectedDiagnostic struct {
+	Kind DiagnosticKind `json:"kind"`
+	Name string         `json:"name"`
+	Path string         `json:"path"`
+}
+
+// FailedDiagnostic is a diagnostic which could not be collected.
+type FailedDiagnostic struct {
+	Kind DiagnosticKind `json:"kind"`
+	Name string         `json:"name"`
+	// PermissionDenied is true if the device doesn't allow reading the diagnostic, for example /data/anr on user builds.
+	PermissionDenied bool   `json:"permission_denied"`
+	Reason           string `json:"reason"`
+	// Path is set if some of the output was collected before the failure, for example the readable files of a
+	// partially pulled directory.
+	Path string `json:"path,omitempty"`
+}
+
+// DiagnosticsManifest lists what CollectDiagnostics collected and what failed.
+type DiagnosticsManifest struct {
+	Serial    string                `json:"serial"`
+	Collected []CollectedDiagnostic `json:"collected"`
+	Failed    []FailedDiagnostic    `json:"failed"`
+}
+
+// CollectDiagnostics collects the selected diagnostics of the device into outputDir. A diagnostic which can't be
+// collected is recorded in the manifest's Failed list, an error is only returned if outputDir can't be created.
+func (model Model) CollectDiagnostics(serial, outputDir string, opts DiagnosticsOptions) (DiagnosticsManifest, error) {
+	if err := os.MkdirAll(outputDir, 0o755); err != nil {
+		return DiagnosticsManifest{}, fmt.Errorf("create diagnostics directory: %w", err)
+	}
+
+	manifest := DiagnosticsManifest{Serial: serial}
+	record := func(kind DiagnosticKind, name, path string, err error) {
+		if err == nil {
+			manifest.Collected = append(manifest.Collected, CollectedDiagnostic{Kind: kind, Name: name, Path: path})
+			return
+		}
+
+		model.logger.Warnf("Failed to collect %s: %s", name, err)
+		manifest.Failed = append(manifest.Failed, FailedDiagnostic{
+			Kind:             kind,
+			Name:             name,
+			PermissionDenied: errors.Is(err, errPermissionDenied),
+			Reason:           err.Error(),
+			Path:             partialOutputPath(path),
+		})
+	}
+
+	if opts.PackageName != "" {
+		services := opts.DumpsysServices
+		if len(services) == 0 {
+			services = defaultDumpsysServices
+		}
+		for _, service := range services {
+			path := filepath.Join(outputDir, fmt.Sprintf("dumpsys-%s.txt", service))
+			record(DiagnosticKindDumpsys, "dumpsys "+service, path, model.dumpsysToFile(serial, service, opts.PackageName, path))
+		}
+	}
+	if opts.ANRTraces {
+		path := filepath.Join(outputDir, "anr")
+		record(DiagnosticKindANR, "/data/anr", path, model.pullDiagnostic(serial, "/data/anr", path))
+	}
+	if opts.Tombstones {
+		path := filepath.Join(outputDir, "tombstones")
+		record(DiagnosticKindTombstones, "/data/tombstones", path, model.pullDiagnostic(serial, "/data/tombstones", path))
+	}
+	if opts.Bugreport {
+		// bugreport takes minutes, it is collected last so the quicker diagnostics are not delayed by it
+		path := filepath.Join(outputDir, "bugreport.zip")
+		record(DiagnosticKindBugreport, "bugreport", path, model.bugreport(serial, path))
+	}
+
+	return manifest, nil
+}
+
+var errPermissionDenied = errors.New("permission denied")
+
+func (model Model) dumpsysToFile(serial, service, packageName, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	cmd := model.adbCmd([]string{"-s", serial, "shell", "dumpsys", service, packageName}, &command.Opts{Stdout: file, Stderr: &stderr})
+	runErr := cmd.Run()
+	if err := file.Close(); err != nil && runErr == nil {
+		runErr = err
+	}
+	if runErr != nil {
+		return diagnosticError(stderr.String(), runErr)
+	}
+	return nil
+}
+
+func (model Model) pullDiagnostic(serial, remotePath, localPath string) error {
+	cmd := model.PullCmd(serial, remotePath, localPath, nil)
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	// pull exits with 0 if some of the files could be pulled, the unreadable ones are reported in the output
+	if err != nil || isPermissionDenied(out) {
+		return diagnosticError(out, err)
+	}
+	return nil
+}
+
+// partialOutputPath returns path if a failed diagnostic left any output there.
+func partialOutputPath(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	if info.IsDir() {
+		if entries, err := os.ReadDir(path); err != nil || len(entries) == 0 {
+			return ""
+		}
+	} else if info.Size() == 0 {
+		return ""
+	}
+	return path
+}
+
+func (model Model) bugreport(serial, path string) error {
+	cmd := model.adbCmd([]string{"-s", serial, "bugreport", path}, nil)
+	if out, err := cmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return diagnosticError(out, err)
+	}
+	return nil
+}
+
+func diagnosticError(out string, err error) error {
+	if isPermissionDenied(out) {
+		return fmt.Errorf("%s: %w", out, errPermissionDenied)
+	}
+	if out == "" {
+		return err
+	}
+	return fmt.Errorf("%s: %w", out, errOrUnexpectedOutput(err))
+}
+
+// adb: error: failed to stat remote object '/data/anr': Permission denied
+// adb: error: failed to copy '/data/tombstones/tombstone_00' to './tombstones/tombstone_00': remote open failed: Permission denied
+func isPermissionDenied(out string) bool {
+	return strings.Contains(strings.ToLower(out), "permission denied")
+}
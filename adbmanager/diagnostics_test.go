@@ -0,0 +1,99 @@
+package adbmanager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GivenUserBuild_WhenCollectDiagnostics_ThenReportsPermissionFailures(t *testing.T) {
+	// Given
+	outputDir := filepath.Join(t.TempDir(), "diagnostics")
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		switch args[2] {
+		case "shell":
+			return fakeResult{stdout: "dumpsys " + args[4] + " output"}
+		case "pull":
+			if args[3] == "/data/anr" {
+				return fakeResult{stderr: "adb: error: failed to stat remote object '/data/anr': Permission denied", exitCode: 1}
+			}
+			return fakeResult{stdout: "/data/tombstones/: 2 files pulled, 0 skipped."}
+		case "bugreport":
+			return fakeResult{stderr: "Failed to take bugreport: device offline", exitCode: 1}
+		}
+		return fakeResult{}
+	})
+
+	// When
+	manifest, err := mockModelWithFactory(factory).CollectDiagnostics("emulator-5554", outputDir, DiagnosticsOptions{
+		Bugreport:       true,
+		ANRTraces:       true,
+		Tombstones:      true,
+		PackageName:     "com.example",
+		DumpsysServices: []string{"package", "meminfo"},
+	})
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "emulator-5554", manifest.Serial)
+	require.Equal(t, []CollectedDiagnostic{
+		{Kind: DiagnosticKindDumpsys, Name: "dumpsys package", Path: filepath.Join(outputDir, "dumpsys-package.txt")},
+		{Kind: DiagnosticKindDumpsys, Name: "dumpsys meminfo", Path: filepath.Join(outputDir, "dumpsys-meminfo.txt")},
+		{Kind: DiagnosticKindTombstones, Name: "/data/tombstones", Path: filepath.Join(outputDir, "tombstones")},
+	}, manifest.Collected)
+
+	require.Len(t, manifest.Failed, 2)
+	require.Equal(t, DiagnosticKindANR, manifest.Failed[0].Kind)
+	require.True(t, manifest.Failed[0].PermissionDenied)
+	require.Equal(t, DiagnosticKindBugreport, manifest.Failed[1].Kind)
+	require.False(t, manifest.Failed[1].PermissionDenied)
+	require.Contains(t, manifest.Failed[1].Reason, "device offline")
+
+	content, err := os.ReadFile(filepath.Join(outputDir, "dumpsys-meminfo.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "dumpsys meminfo output", string(content))
+
+	require.Equal(t, []string{"-s", "emulator-5554", "shell", "dumpsys", "package", "com.example"}, factory.Calls()[0])
+	require.Equal(t, []string{"-s", "emulator-5554", "bugreport", filepath.Join(outputDir, "bugreport.zip")}, factory.Calls()[4])
+}
+
+func Test_GivenPartiallyReadableDirectory_WhenPullDiagnostic_ThenReportsPermissionDenied(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stderr: "adb: error: failed to copy '/data/tombstones/tombstone_00' to './tombstones/tombstone_00': remote open failed: Permission denied"}
+	})
+
+	// When
+	err := mockModelWithFactory(factory).pullDiagnostic("emulator-5554", "/data/tombstones", "tombstones")
+
+	// Then
+	require.ErrorIs(t, err, errPermissionDenied)
+}
+
+func Test_GivenPartialPull_WhenCollectDiagnostics_ThenRecordsPartialOutput(t *testing.T) {
+	// Given
+	outputDir := filepath.Join(t.TempDir(), "diagnostics")
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		// the readable tombstone is copied before adb fails on the unreadable one
+		localDir := args[4]
+		if err := os.MkdirAll(localDir, 0o755); err != nil {
+			return fakeResult{stderr: err.Error(), exitCode: 1}
+		}
+		if err := os.WriteFile(filepath.Join(localDir, "tombstone_01"), []byte("tombstone"), 0o644); err != nil {
+			return fakeResult{stderr: err.Error(), exitCode: 1}
+		}
+		return fakeResult{stderr: "adb: error: failed to copy '/data/tombstones/tombstone_00' to '" + localDir + "/tombstone_00': remote open failed: Permission denied", exitCode: 1}
+	})
+
+	// When
+	manifest, err := mockModelWithFactory(factory).CollectDiagnostics("emulator-5554", outputDir, DiagnosticsOptions{Tombstones: true})
+
+	// Then
+	require.NoError(t, err)
+	require.Empty(t, manifest.Collected)
+	require.Len(t, manifest.Failed, 1)
+	require.True(t, manifest.Failed[0].PermissionDenied)
+	require.Equal(t, filepath.Join(outputDir, "tombstones"), manifest.Failed[0].Path)
+}
@@ -0,0 +1,277 @@
+package adbmanager
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SocketProtocol is the protocol of an adb socket spec.
+type SocketProtocol string
+
+// Socket protocols, see: https://developer.android.com/tools/adb#forwardports
+const (
+	SocketProtocolTCP             SocketProtocol = "tcp"
+	SocketProtocolLocalAbstract   SocketProtocol = "localabstract"
+	SocketProtocolLocalReserved   SocketProtocol = "localreserved"
+	SocketProtocolLocalFilesystem SocketProtocol = "localfilesystem"
+	// SocketProtocolJDWP is the debug port of a process, it is only valid as the device side of a forward.
+	SocketProtocolJDWP SocketProtocol = "jdwp"
+)
+
+// SocketSpec is a `<protocol>:<address>` socket spec of adb forward and reverse.
+type SocketSpec struct {
+	Protocol SocketProtocol
+	// Address is the port for tcp, the socket name or path for the local* protocols and the process id for jdwp.
+	Address string
+}
+
+// TCPSocket returns the spec of a tcp port, 0 lets adb allocate a free port.
+func TCPSocket(port int) SocketSpec {
+	return SocketSpec{Protocol: SocketProtocolTCP, Address: strconv.Itoa(port)}
+}
+
+// LocalAbstractSocket returns the spec of an abstract unix domain socket, for example chrome_devtools_remote.
+func LocalAbstractSocket(name string) SocketSpec {
+	return SocketSpec{Protocol: SocketProtocolLocalAbstract, Address: name}
+}
+
+// JDWPSocket returns the spec of the debug port of the process.
+func JDWPSocket(pid int) SocketSpec {
+	return SocketSpec{Protocol: SocketProtocolJDWP, Address: strconv.Itoa(pid)}
+}
+
+func (spec SocketSpec) String() string {
+	return string(spec.Protocol) + ":" + spec.Address
+}
+
+// ParseSocketSpec parses a `<protocol>:<address>` socket spec.
+func ParseSocketSpec(spec string) (SocketSpec, error) {
+	protocol, address, ok := strings.Cut(spec, ":")
+	if !ok || address == "" {
+		return SocketSpec{}, fmt.Errorf("invalid socket spec: %s", spec)
+	}
+
+	parsed := SocketSpec{Protocol: SocketProtocol(protocol), Address: address}
+	if err := parsed.validate(); err != nil {
+		return SocketSpec{}, err
+	}
+	return parsed, nil
+}
+
+func (spec SocketSpec) validate() error {
+	switch spec.Protocol {
+	case SocketProtocolTCP:
+		if _, err := strconv.ParseUint(spec.Address, 10, 16); err != nil {
+			return fmt.Errorf("invalid port in socket spec: %s", spec)
+		}
+	case SocketProtocolJDWP:
+		if _, err := strconv.ParseUint(spec.Address, 10, 32); err != nil {
+			return fmt.Errorf("invalid process id in socket spec: %s", spec)
+		}
+	case SocketProtocolLocalAbstract, SocketProtocolLocalReserved, SocketProtocolLocalFilesystem:
+		if spec.Address == "" {
+			return fmt.Errorf("empty socket name in socket spec: %s", spec)
+		}
+	default:
+		return fmt.Errorf("unsupported protocol in socket spec: %s", spec)
+	}
+	return nil
+}
+
+// PortMapping is an entry of `adb forward --list` or `adb reverse --list`.
+// For a forward Local is on the host, for a reverse Local is on the device.
+type PortMapping struct {
+	Serial string
+	Local  SocketSpec
+	Remote SocketSpec
+}
+
+// Forward forwards connections to the host socket (local) to the device socket (remote). noRebind fails if local is
+// already forwarded. It returns the host socket, which has the allocated port if local is tcp:0.
+func (model Model) Forward(serial string, local, remote SocketSpec, noRebind bool) (SocketSpec, error) {
+	if local.Protocol == SocketProtocolJDWP {
+		return SocketSpec{}, errors.New("jdwp is only supported on the device side of a forward")
+	}
+	return model.mapPort("forward", serial, local, remote, noRebind)
+}
+
+// Reverse forwards connections to the device socket (remote) to the host socket (local). noRebind fails if remote is
+// already reversed. It returns the device socket, which has the allocated port if remote is tcp:0.
+func (model Model) Reverse(serial string, remote, local SocketSpec, noRebind bool) (SocketSpec, error) {
+	if remote.Protocol == SocketProtocolJDWP || local.Protocol == SocketProtocolJDWP {
+		return SocketSpec{}, errors.New("jdwp is not supported by reverse")
+	}
+	return model.mapPort("reverse", serial, remote, local, noRebind)
+}
+
+func (model Model) mapPort(subcommand, serial string, from, to SocketSpec, noRebind bool) (SocketSpec, error) {
+	for _, spec := range []SocketSpec{from, to} {
+		if err := spec.validate(); err != nil {
+			return SocketSpec{}, err
+		}
+	}
+
+	args := []string{"-s", serial, subcommand}
+	if noRebind {
+		args = append(args, "--no-rebind")
+	}
+	args = append(args, from.String(), to.String())
+
+	out, err := model.adbCmd(args, nil).RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return SocketSpec{}, fmt.Errorf("%s %s %s: %s: %w", subcommand, from, to, out, err)
+	}
+
+	// adb prints the allocated port for tcp:0
+	if from == TCPSocket(0) {
+		port, err := strconv.Atoi(lastLine(out))
+		if err != nil {
+			return SocketSpec{}, fmt.Errorf("%s %s %s: unexpected output: %s", subcommand, from, to, out)
+		}
+		return TCPSocket(port), nil
+	}
+	return from, nil
+}
+
+// ListForwards returns the forwards of the device, or of every device if serial is empty.
+func (model Model) ListForwards(serial string) ([]PortMapping, error) {
+	out, err := model.adbCmd([]string{"forward", "--list"}, nil).RunAndReturnTrimmedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("list forwards: %s: %w", out, err)
+	}
+
+	var mappings []PortMapping
+	for _, mapping := range parsePortMappings(out) {
+		if serial == "" || mapping.Serial == serial {
+			mappings = append(mappings, mapping)
+		}
+	}
+	return mappings, nil
+}
+
+// ListReverses returns the reverses of the device.
+func (model Model) ListReverses(serial string) ([]PortMapping, error) {
+	out, err := model.adbCmd([]string{"-s", serial, "reverse", "--list"}, nil).RunAndReturnTrimmedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("list reverses: %s: %w", out, err)
+	}
+
+	// the first column is the transport name (for example UsbFfs or host-19), not the serial
+	mappings := parsePortMappings(out)
+	for i := range mappings {
+		mappings[i].Serial = serial
+	}
+	return mappings, nil
+}
+
+// RemoveForward removes the forward of the host socket.
+func (model Model) RemoveForward(serial string, local SocketSpec) error {
+	return model.removePortMapping(serial, "forward", "--remove", local.String())
+}
+
+// RemoveReverse removes the reverse of the device socket.
+func (model Model) RemoveReverse(serial string, remote SocketSpec) error {
+	return model.removePortMapping(serial, "reverse", "--remove", remote.String())
+}
+
+// RemoveAllForwards removes every forward of the device.
+func (model Model) RemoveAllForwards(serial string) error {
+	return model.removePortMapping(serial, "forward", "--remove-all")
+}
+
+// RemoveAllReverses removes every reverse of the device.
+func (model Model) RemoveAllReverses(serial string) error {
+	return model.removePortMapping(serial, "reverse", "--remove-all")
+}
+
+func (model Model) removePortMapping(serial string, args ...string) error {
+	cmd := model.adbCmd(append([]string{"-s", serial}, args...), nil)
+	if out, err := cmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s: %w", strings.Join(args, " "), out, err)
+	}
+	return nil
+}
+
+// emulator-5554 tcp:8080 tcp:8080
+func parsePortMappings(out string) []PortMapping {
+	var mappings []PortMapping
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+
+		local, err := ParseSocketSpec(fields[1])
+		if err != nil {
+			continue
+		}
+		remote, err := ParseSocketSpec(fields[2])
+		if err != nil {
+			continue
+		}
+		mappings = append(mappings, PortMapping{Serial: fields[0], Local: local, Remote: remote})
+	}
+	return mappings
+}
+
+// PortSession records the forwards and reverses created through it, so that they can be removed together
+// at the end of a test session.
+type PortSession struct {
+	model  Model
+	serial string
+
+	mu       sync.Mutex
+	forwards []SocketSpec
+	reverses []SocketSpec
+}
+
+// NewPortSession ...
+func (model Model) NewPortSession(serial string) *PortSession {
+	return &PortSession{model: model, serial: serial}
+}
+
+// Forward is the same as Model.Forward, the forward is removed by Close.
+func (session *PortSession) Forward(local, remote SocketSpec) (SocketSpec, error) {
+	spec, err := session.model.Forward(session.serial, local, remote, false)
+	if err != nil {
+		return SocketSpec{}, err
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.forwards = append(session.forwards, spec)
+	return spec, nil
+}
+
+// Reverse is the same as Model.Reverse, the reverse is removed by Close.
+func (session *PortSession) Reverse(remote, local SocketSpec) (SocketSpec, error) {
+	spec, err := session.model.Reverse(session.serial, remote, local, false)
+	if err != nil {
+		return SocketSpec{}, err
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	session.reverses = append(session.reverses, spec)
+	return spec, nil
+}
+
+// Close removes the forwards and reverses of the session, mappings created outside the session are kept.
+func (session *PortSession) Close() error {
+	session.mu.Lock()
+	forwards, reverses := session.forwards, session.reverses
+	session.forwards, session.reverses = nil, nil
+	session.mu.Unlock()
+
+	var errs []error
+	for _, spec := range forwards {
+		errs = append(errs, session.model.RemoveForward(session.serial, spec))
+	}
+	for _, spec := range reverses {
+		errs = append(errs, session.model.RemoveReverse(session.serial, spec))
+	}
+	return errors.Join(errs...)
+}
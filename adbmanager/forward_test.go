@@ -0,0 +1,115 @@
+package adbmanager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GivenDynamicPort_WhenForward_ThenReturnsAllocatedPort(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: "38211"}
+	})
+
+	// When
+	local, err := mockModelWithFactory(factory).Forward("emulator-5554", TCPSocket(0), JDWPSocket(4242), true)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, TCPSocket(38211), local)
+	require.Equal(t, [][]string{{"-s", "emulator-5554", "forward", "--no-rebind", "tcp:0", "jdwp:4242"}}, factory.Calls())
+}
+
+func Test_GivenJDWP_WhenReverse_ThenFails(t *testing.T) {
+	// When
+	_, err := mockModel().Reverse("emulator-5554", TCPSocket(8080), JDWPSocket(4242), false)
+
+	// Then
+	require.EqualError(t, err, "jdwp is not supported by reverse")
+}
+
+func Test_GivenForwardsOfMultipleDevices_WhenListForwards_ThenReturnsDeviceForwards(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: "emulator-5554 tcp:8080 tcp:8080\n" +
+			"emulator-5556 tcp:9222 localabstract:chrome_devtools_remote\n" +
+			"emulator-5554 tcp:9223 localabstract:chrome_devtools_remote\n"}
+	})
+
+	// When
+	mappings, err := mockModelWithFactory(factory).ListForwards("emulator-5554")
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []PortMapping{
+		{Serial: "emulator-5554", Local: TCPSocket(8080), Remote: TCPSocket(8080)},
+		{Serial: "emulator-5554", Local: TCPSocket(9223), Remote: LocalAbstractSocket("chrome_devtools_remote")},
+	}, mappings)
+	require.Equal(t, [][]string{{"forward", "--list"}}, factory.Calls())
+}
+
+func Test_GivenReverses_WhenListReverses_ThenUsesSerial(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: "host-19 tcp:8080 tcp:3000\n"}
+	})
+
+	// When
+	mappings, err := mockModelWithFactory(factory).ListReverses("emulator-5554")
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []PortMapping{{Serial: "emulator-5554", Local: TCPSocket(8080), Remote: TCPSocket(3000)}}, mappings)
+	require.Equal(t, [][]string{{"-s", "emulator-5554", "reverse", "--list"}}, factory.Calls())
+}
+
+func Test_GivenSessionMappings_WhenClose_ThenRemovesOnlySessionMappings(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{}
+	})
+	session := mockModelWithFactory(factory).NewPortSession("emulator-5554")
+	_, err := session.Reverse(TCPSocket(8080), TCPSocket(3000))
+	require.NoError(t, err)
+	_, err = session.Forward(TCPSocket(9222), LocalAbstractSocket("chrome_devtools_remote"))
+	require.NoError(t, err)
+
+	// When
+	err = session.Close()
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"-s", "emulator-5554", "reverse", "tcp:8080", "tcp:3000"},
+		{"-s", "emulator-5554", "forward", "tcp:9222", "localabstract:chrome_devtools_remote"},
+		{"-s", "emulator-5554", "forward", "--remove", "tcp:9222"},
+		{"-s", "emulator-5554", "reverse", "--remove", "tcp:8080"},
+	}, factory.Calls())
+}
+
+func Test_ParseSocketSpec(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    SocketSpec
+		wantErr string
+	}{
+		{spec: "tcp:8080", want: TCPSocket(8080)},
+		{spec: "localfilesystem:/data/local/tmp/socket", want: SocketSpec{Protocol: SocketProtocolLocalFilesystem, Address: "/data/local/tmp/socket"}},
+		{spec: "jdwp:1234", want: JDWPSocket(1234)},
+		{spec: "tcp:99999", wantErr: "invalid port in socket spec: tcp:99999"},
+		{spec: "udp:53", wantErr: "unsupported protocol in socket spec: udp:53"},
+		{spec: "tcp", wantErr: "invalid socket spec: tcp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseSocketSpec(tt.spec)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package adbmanager
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+// InstallOptions are the options of `adb install`, `adb install-multiple` and `adb install-multi-package`.
+// See: https://developer.android.com/tools/adb#pm
+type InstallOptions struct {
+	// Replace reinstalls an existing app, keeping its data (-r).
+	Replace bool
+	// AllowTestPackages allows installing APKs with android:testOnly (-t).
+	AllowTestPackages bool
+	// AllowDowngrade allows installing a lower version code, only for debuggable packages (-d).
+	AllowDowngrade bool
+	// GrantPermissions grants all runtime permissions declared in the manifest (-g).
+	GrantPermissions bool
+	// UserID is the user to install the app for, all users are used if empty.
+	UserID string
+	// ABI overrides the platform's default ABI.
+	ABI string
+	// Streaming forces (--streaming) or disables (--no-streaming) streamed install, adb decides if nil.
+	Streaming *bool
+}
+
+// Args returns the install flags of the options.
+func (opts InstallOptions) Args() []string {
+	var args []string
+	if opts.Replace {
+		args = append(args, "-r")
+	}
+	if opts.AllowTestPackages {
+		args = append(args, "-t")
+	}
+	if opts.AllowDowngrade {
+		args = append(args, "-d")
+	}
+	if opts.GrantPermissions {
+		args = append(args, "-g")
+	}
+	if opts.UserID != "" {
+		args = append(args, "--user", opts.UserID)
+	}
+	if opts.ABI != "" {
+		args = append(args, "--abi", opts.ABI)
+	}
+	if opts.Streaming != nil {
+		if *opts.Streaming {
+			args = append(args, "--streaming")
+		} else {
+			args = append(args, "--no-streaming")
+		}
+	}
+	return args
+}
+
+// InstallCmd builds and returns a `Command` installing a single APK on the device with the given serial.
+func (model Model) InstallCmd(serial, apk string, opts InstallOptions, commandOptions *command.Opts) command.Command {
+	return model.installCmd("install", serial, []string{apk}, opts, commandOptions)
+}
+
+// InstallMultipleCmd builds and returns a `Command` installing a single app from a base APK and its split APKs.
+func (model Model) InstallMultipleCmd(serial string, apks []string, opts InstallOptions, commandOptions *command.Opts) (command.Command, error) {
+	if len(apks) == 0 {
+		return nil, errors.New("no APKs provided for install-multiple")
+	}
+	return model.installCmd("install-multiple", serial, apks, opts, commandOptions), nil
+}
+
+// InstallMultiPackageCmd builds and returns a `Command` installing multiple apps atomically, for example an app and
+// its test APK. Streamed install is not supported by install-multi-package.
+func (model Model) InstallMultiPackageCmd(serial string, apks []string, opts InstallOptions, commandOptions *command.Opts) (command.Command, error) {
+	if len(apks) == 0 {
+		return nil, errors.New("no APKs provided for install-multi-package")
+	}
+	if opts.Streaming != nil {
+		return nil, errors.New("streaming options are not supported by install-multi-package")
+	}
+	return model.installCmd("install-multi-package", serial, apks, opts, commandOptions), nil
+}
+
+func (model Model) installCmd(subcommand, serial string, apks []string, opts InstallOptions, commandOptions *command.Opts) command.Command {
+	var args []string
+	if serial != "" {
+		args = append(args, "-s", serial)
+	}
+	args = append(args, subcommand)
+	args = append(args, opts.Args()...)
+	args = append(args, apks...)
+
+	return model.adbCmd(args, commandOptions)
+}
+
+// InstallAPKs installs a single app on the device: a single APK is installed with `adb install`,
+// a base APK with its splits with `adb install-multiple`. A failed install is returned as an *InstallError
+// if adb reported an INSTALL_* failure code.
+func (model Model) InstallAPKs(serial string, apks []string, opts InstallOptions) error {
+	if len(apks) == 1 {
+		return model.runInstall(model.InstallCmd(serial, apks[0], opts, nil))
+	}
+
+	cmd, err := model.InstallMultipleCmd(serial, apks, opts, nil)
+	if err != nil {
+		return err
+	}
+	return model.runInstall(cmd)
+}
+
+// InstallMultiPackage installs multiple apps on the device with `adb install-multi-package`.
+func (model Model) InstallMultiPackage(serial string, apks []string, opts InstallOptions) error {
+	cmd, err := model.InstallMultiPackageCmd(serial, apks, opts, nil)
+	if err != nil {
+		return err
+	}
+	return model.runInstall(cmd)
+}
+
+func (model Model) runInstall(cmd command.Command) error {
+	model.logger.Printf("$ %s", cmd.PrintableCommandArgs())
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	// Older adb versions exit with 0 on a failed install.
+	if installErr := parseInstallError(out); installErr != nil {
+		return installErr
+	}
+	if err != nil {
+		return fmt.Errorf("install failed: %s: %w", out, err)
+	}
+	return nil
+}
+
+// InstallFailureCode is the INSTALL_FAILED_* (or INSTALL_PARSE_FAILED_*) code reported by the package manager.
+type InstallFailureCode string
+
+// Common install failure codes, see: https://android.googlesource.com/platform/frameworks/base/+/refs/heads/main/core/java/android/content/pm/PackageManager.java
+const (
+	InstallFailedAlreadyExists             InstallFailureCode = "INSTALL_FAILED_ALREADY_EXISTS"
+	InstallFailedInvalidAPK                InstallFailureCode = "INSTALL_FAILED_INVALID_APK"
+	InstallFailedInsufficientStorage       InstallFailureCode = "INSTALL_FAILED_INSUFFICIENT_STORAGE"
+	InstallFailedUpdateIncompatible        InstallFailureCode = "INSTALL_FAILED_UPDATE_INCOMPATIBLE"
+	InstallFailedVersionDowngrade          InstallFailureCode = "INSTALL_FAILED_VERSION_DOWNGRADE"
+	InstallFailedOlderSDK                  InstallFailureCode = "INSTALL_FAILED_OLDER_SDK"
+	InstallFailedNoMatchingABIs            InstallFailureCode = "INSTALL_FAILED_NO_MATCHING_ABIS"
+	InstallFailedTestOnly                  InstallFailureCode = "INSTALL_FAILED_TEST_ONLY"
+	InstallFailedMissingSplit              InstallFailureCode = "INSTALL_FAILED_MISSING_SPLIT"
+	InstallFailedUserRestricted            InstallFailureCode = "INSTALL_FAILED_USER_RESTRICTED"
+	InstallFailedVerificationFailure       InstallFailureCode = "INSTALL_FAILED_VERIFICATION_FAILURE"
+	InstallFailedDuplicatePermission       InstallFailureCode = "INSTALL_FAILED_DUPLICATE_PERMISSION"
+	InstallFailedAborted                   InstallFailureCode = "INSTALL_FAILED_ABORTED"
+	InstallParseFailedNoCertificates       InstallFailureCode = "INSTALL_PARSE_FAILED_NO_CERTIFICATES"
+	InstallParseFailedInconsistentCerts    InstallFailureCode = "INSTALL_PARSE_FAILED_INCONSISTENT_CERTIFICATES"
+	InstallParseFailedManifestMalformed    InstallFailureCode = "INSTALL_PARSE_FAILED_MANIFEST_MALFORMED"
+	InstallFailedInternalError             InstallFailureCode = "INSTALL_FAILED_INTERNAL_ERROR"
+	InstallFailedSharedUserIncompatible    InstallFailureCode = "INSTALL_FAILED_SHARED_USER_INCOMPATIBLE"
+	InstallFailedConflictingProvider       InstallFailureCode = "INSTALL_FAILED_CONFLICTING_PROVIDER"
+	InstallFailedDeprecatedSDKVersion      InstallFailureCode = "INSTALL_FAILED_DEPRECATED_SDK_VERSION"
+	InstallFailedMultiPackageInconsistency InstallFailureCode = "INSTALL_FAILED_MULTIPACKAGE_INCONSISTENCY"
+)
+
+// InstallError is returned when the package manager rejected the install.
+type InstallError struct {
+	Code InstallFailureCode
+	// Message is the package manager's description of the failure, if any.
+	Message string
+	// Output is the complete adb output.
+	Output string
+}
+
+func (err *InstallError) Error() string {
+	if err.Message == "" {
+		return fmt.Sprintf("install failed: %s", err.Code)
+	}
+	return fmt.Sprintf("install failed: %s: %s", err.Code, err.Message)
+}
+
+// RequiresUninstall returns true if the install can only succeed after uninstalling the existing app
+// (signature mismatch or version downgrade).
+func (err *InstallError) RequiresUninstall() bool {
+	switch err.Code {
+	case InstallFailedUpdateIncompatible, InstallFailedVersionDowngrade, InstallParseFailedInconsistentCerts,
+		InstallFailedSharedUserIncompatible:
+		return true
+	}
+	return false
+}
+
+// Failure [INSTALL_FAILED_UPDATE_INCOMPATIBLE: Package com.example signatures do not match previously installed version; ignoring!]
+// Failure [INSTALL_FAILED_INSUFFICIENT_STORAGE]
+// The message can contain brackets, it lasts until the last `]` of the line.
+var installFailurePattern = regexp.MustCompile(`Failure \[(INSTALL_[A-Z_]+)(?::[ \t]*([^\r\n]*))?\]`)
+
+func parseInstallError(out string) *InstallError {
+	match := installFailurePattern.FindStringSubmatch(out)
+	if match == nil {
+		return nil
+	}
+	return &InstallError{
+		Code:    InstallFailureCode(match[1]),
+		Message: strings.TrimSpace(match[2]),
+		Output:  out,
+	}
+}
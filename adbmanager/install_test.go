@@ -0,0 +1,126 @@
+package adbmanager
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GivenAllOptions_WhenInstallMultipleCmd_ThenCreatesExpectedCommand(t *testing.T) {
+	// Given
+	streaming := false
+	opts := InstallOptions{
+		Replace:           true,
+		AllowTestPackages: true,
+		AllowDowngrade:    true,
+		GrantPermissions:  true,
+		UserID:            "0",
+		ABI:               "x86_64",
+		Streaming:         &streaming,
+	}
+
+	// When
+	testCommand, err := mockModel().InstallMultipleCmd("emulator-5554", []string{"base.apk", "split_config.xxhdpi.apk"}, opts, nil)
+
+	// Then
+	require.NoError(t, err)
+	expectedArgs := `adb "-s" "emulator-5554" "install-multiple" "-r" "-t" "-d" "-g" "--user" "0" "--abi" "x86_64" "--no-streaming" "base.apk" "split_config.xxhdpi.apk"`
+	require.Equal(t, expectedArgs, testCommand.PrintableCommandArgs())
+}
+
+func Test_GivenStreaming_WhenInstallMultiPackageCmd_ThenFails(t *testing.T) {
+	// Given
+	streaming := true
+
+	// When
+	_, err := mockModel().InstallMultiPackageCmd("emulator-5554", []string{"app.apk", "app-test.apk"}, InstallOptions{Streaming: &streaming}, nil)
+
+	// Then
+	require.EqualError(t, err, "streaming options are not supported by install-multi-package")
+}
+
+func Test_GivenAPKs_WhenInstallMultiPackageCmd_ThenCreatesExpectedCommand(t *testing.T) {
+	// When
+	testCommand, err := mockModel().InstallMultiPackageCmd("emulator-5554", []string{"app.apk", "app-test.apk"}, InstallOptions{Replace: true}, nil)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, `adb "-s" "emulator-5554" "install-multi-package" "-r" "app.apk" "app-test.apk"`, testCommand.PrintableCommandArgs())
+}
+
+func Test_GivenSingleAPK_WhenInstallAPKs_ThenUsesInstall(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: "Performing Streamed Install\nSuccess"}
+	})
+
+	// When
+	err := mockModelWithFactory(factory).InstallAPKs("emulator-5554", []string{"app.apk"}, InstallOptions{Replace: true})
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"-s", "emulator-5554", "install", "-r", "app.apk"}}, factory.Calls())
+}
+
+func Test_GivenInstallFailure_WhenInstallAPKs_ThenReturnsInstallError(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{
+			stderr:   "adb: failed to install app.apk: Failure [INSTALL_FAILED_UPDATE_INCOMPATIBLE: Package com.example signatures do not match previously installed version; ignoring!]",
+			exitCode: 1,
+		}
+	})
+
+	// When
+	err := mockModelWithFactory(factory).InstallAPKs("emulator-5554", []string{"base.apk", "split.apk"}, InstallOptions{})
+
+	// Then
+	var installErr *InstallError
+	require.True(t, errors.As(err, &installErr))
+	require.Equal(t, InstallFailedUpdateIncompatible, installErr.Code)
+	require.Equal(t, "Package com.example signatures do not match previously installed version; ignoring!", installErr.Message)
+	require.True(t, installErr.RequiresUninstall())
+	require.Equal(t, "install-multiple", factory.Calls()[0][2])
+}
+
+func Test_parseInstallError(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want *InstallError
+	}{
+		{
+			name: "success",
+			out:  "Performing Streamed Install\nSuccess",
+		},
+		{
+			name: "code without message, legacy adb exiting with 0",
+			out:  "Failure [INSTALL_FAILED_INSUFFICIENT_STORAGE]",
+			want: &InstallError{Code: InstallFailedInsufficientStorage, Output: "Failure [INSTALL_FAILED_INSUFFICIENT_STORAGE]"},
+		},
+		{
+			name: "parse failure",
+			out:  "adb: failed to install app.apk: Failure [INSTALL_PARSE_FAILED_NO_CERTIFICATES: Failed to collect certificates from /data/app/vmdl.tmp/base.apk]",
+			want: &InstallError{
+				Code:    InstallParseFailedNoCertificates,
+				Message: "Failed to collect certificates from /data/app/vmdl.tmp/base.apk",
+				Output:  "adb: failed to install app.apk: Failure [INSTALL_PARSE_FAILED_NO_CERTIFICATES: Failed to collect certificates from /data/app/vmdl.tmp/base.apk]",
+			},
+		},
+		{
+			name: "message with brackets",
+			out:  "Performing Streamed Install\nadb: failed to install app.apk: Failure [INSTALL_FAILED_MISSING_SHARED_LIBRARY: Package couldn't be installed in /data/app/[com.example-1]: Reason: missing lib]\n",
+			want: &InstallError{
+				Code:    "INSTALL_FAILED_MISSING_SHARED_LIBRARY",
+				Message: "Package couldn't be installed in /data/app/[com.example-1]: Reason: missing lib",
+				Output:  "Performing Streamed Install\nadb: failed to install app.apk: Failure [INSTALL_FAILED_MISSING_SHARED_LIBRARY: Package couldn't be installed in /data/app/[com.example-1]: Reason: missing lib]\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, parseInstallError(tt.out))
+		})
+	}
+}
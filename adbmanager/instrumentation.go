@@ -0,0 +1,227 @@
+package adbmanager
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/sliceutil"
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+// TestSize is the value of the test runner's `size` argument.
+type TestSize string
+
+// Test sizes, see: https://developer.android.com/training/testing/instrumented-tests/androidx-test-libraries/runner#filter-tests
+const (
+	TestSizeSmall  TestSize = "small"
+	TestSizeMedium TestSize = "medium"
+	TestSizeLarge  TestSize = "large"
+)
+
+// InstrumentationExtra is an arbitrary `-e <key> <value>` argument of `am instrument`.
+type InstrumentationExtra struct {
+	Key   string
+	Value string
+}
+
+// InstrumentationOptions are the options of an `am instrument` invocation.
+// See: https://developer.android.com/studio/test/command-line#am-instrument-options
+// and https://developer.android.com/training/testing/instrumented-tests/androidx-test-libraries/runner#runner-arguments
+type InstrumentationOptions struct {
+	// Classes are test classes or methods (com.example.FooTest#testBar) to run.
+	Classes    []string
+	NotClasses []string
+	Packages   []string
+	// NotPackages are packages to exclude.
+	NotPackages []string
+	// Annotation runs only the tests annotated with the given (fully qualified) annotation.
+	Annotation string
+	// NotAnnotations skips the tests annotated with any of the given annotations.
+	NotAnnotations []string
+	Size           TestSize
+	// NumShards and ShardIndex split the tests into NumShards shards and run the one with the given (0 based) index.
+	NumShards  int
+	ShardIndex int
+	Coverage   bool
+	// CoverageFile is the on-device path of the coverage data file, requires Coverage.
+	CoverageFile string
+	Debug        bool
+	// Listeners are RunListener implementations to register.
+	Listeners []string
+	// UserID is the user to run the instrumentation as, the current user is used if empty.
+	UserID            string
+	NoWindowAnimation bool
+	// RawOutput adds `-r`, see RunInstrumentedTestsRawCmd.
+	RawOutput bool
+	// Extras are passed to the runner as is, after the typed options.
+	Extras []InstrumentationExtra
+}
+
+// reservedExtraKeys are the runner arguments which have typed fields in InstrumentationOptions.
+var reservedExtraKeys = []string{
+	"class", "notClass", "package", "notPackage", "annotation", "notAnnotation", "size",
+	"numShards", "shardIndex", "coverage", "coverageFile", "debug", "listener",
+}
+
+// extraKeyPattern matches the keys which are passed to the device shell unquoted.
+var extraKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.]+$`)
+
+// Validate returns an error if the options can't be rendered into a valid `am instrument` command.
+func (opts InstrumentationOptions) Validate() error {
+	var errs []error
+
+	switch opts.Size {
+	case "", TestSizeSmall, TestSizeMedium, TestSizeLarge:
+	default:
+		errs = append(errs, fmt.Errorf("invalid test size: %s", opts.Size))
+	}
+
+	switch {
+	case opts.NumShards < 0:
+		errs = append(errs, fmt.Errorf("invalid number of shards: %d", opts.NumShards))
+	case opts.ShardIndex < 0:
+		errs = append(errs, fmt.Errorf("invalid shard index: %d", opts.ShardIndex))
+	case opts.NumShards == 0 && opts.ShardIndex > 0:
+		errs = append(errs, errors.New("shard index is set without the number of shards"))
+	case opts.NumShards > 0 && opts.ShardIndex >= opts.NumShards:
+		errs = append(errs, fmt.Errorf("shard index (%d) should be less than the number of shards (%d)", opts.ShardIndex, opts.NumShards))
+	}
+
+	if opts.CoverageFile != "" && !opts.Coverage {
+		errs = append(errs, errors.New("coverage file is set without enabling coverage"))
+	}
+
+	for _, extra := range opts.Extras {
+		switch {
+		case extra.Key == "":
+			errs = append(errs, errors.New("extra with empty key"))
+		case !extraKeyPattern.MatchString(extra.Key):
+			errs = append(errs, fmt.Errorf("invalid extra key: %q", extra.Key))
+		case sliceutil.IsStringInSlice(extra.Key, reservedExtraKeys):
+			errs = append(errs, fmt.Errorf("extra key (%s) should be set with the dedicated option", extra.Key))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Args returns the `am instrument` flags of the options, values are quoted for the device shell.
+func (opts InstrumentationOptions) Args() ([]string, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid instrumentation options: %w", err)
+	}
+
+	var args []string
+	if opts.RawOutput {
+		args = append(args, "-r")
+	}
+	if opts.UserID != "" {
+		args = append(args, "--user", quoteShellArg(opts.UserID))
+	}
+	if opts.NoWindowAnimation {
+		args = append(args, "--no-window-animation")
+	}
+
+	extra := func(key, value string) {
+		args = append(args, "-e", key, quoteShellArg(value))
+	}
+	list := func(key string, values []string) {
+		if len(values) > 0 {
+			extra(key, strings.Join(values, ","))
+		}
+	}
+
+	list("class", opts.Classes)
+	list("notClass", opts.NotClasses)
+	list("package", opts.Packages)
+	list("notPackage", opts.NotPackages)
+	if opts.Annotation != "" {
+		extra("annotation", opts.Annotation)
+	}
+	list("notAnnotation", opts.NotAnnotations)
+	if opts.Size != "" {
+		extra("size", string(opts.Size))
+	}
+	if opts.NumShards > 0 {
+		extra("numShards", strconv.Itoa(opts.NumShards))
+		extra("shardIndex", strconv.Itoa(opts.ShardIndex))
+	}
+	if opts.Coverage {
+		extra("coverage", "true")
+	}
+	if opts.CoverageFile != "" {
+		extra("coverageFile", opts.CoverageFile)
+	}
+	if opts.Debug {
+		extra("debug", "true")
+	}
+	list("listener", opts.Listeners)
+	for _, e := range opts.Extras {
+		extra(e.Key, e.Value)
+	}
+
+	return args, nil
+}
+
+// RunInstrumentationCmd builds and returns a `Command` for running instrumented tests with typed options.
+// The device is selected by serial, the only attached device is used if serial is empty.
+func (model Model) RunInstrumentationCmd(
+	serial string,
+	packageName string,
+	testRunnerClass string,
+	opts InstrumentationOptions,
+	commandOptions *command.Opts,
+) (command.Command, error) {
+	shellArgs, err := instrumentShellArgs(packageName, testRunnerClass, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var args []string
+	if serial != "" {
+		args = append(args, "-s", serial)
+	}
+	args = append(args, "shell")
+	args = append(args, shellArgs...)
+
+	return model.adbCmd(args, commandOptions), nil
+}
+
+// instrumentShellArgs returns the `am instrument` command line, quoted for the device shell.
+func instrumentShellArgs(packageName, testRunnerClass string, opts InstrumentationOptions) ([]string, error) {
+	instrumentationArgs, err := opts.Args()
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{"am", "instrument", "-w"}
+	args = append(args, instrumentationArgs...)
+	return append(args, packageName+"/"+testRunnerClass), nil
+}
+
+// quoteShellArg quotes the argument for the device shell: adb joins the arguments of `adb shell` with spaces,
+// so arguments with whitespace or shell metacharacters would otherwise be split or interpreted on the device.
+func quoteShellArg(arg string) string {
+	if arg == "" {
+		return "''"
+	}
+
+	safe := true
+	for i, r := range arg {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("_-.,:/@%+=", r):
+		case r == '#' && i > 0: // comment only at the start of a word: com.example.FooTest#testBar
+		default:
+			safe = false
+		}
+	}
+	if safe {
+		return arg
+	}
+
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
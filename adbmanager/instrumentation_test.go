@@ -0,0 +1,126 @@
+package adbmanager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GivenTypedOptions_WhenRunInstrumentationCmd_ThenCreatesExpectedCommand(t *testing.T) {
+	// Given
+	opts := InstrumentationOptions{
+		Classes:           []string{"com.example.LoginTest", "com.example.SettingsTest#darkMode"},
+		NotPackages:       []string{"com.example.flaky"},
+		Annotation:        "androidx.test.filters.SmallTest",
+		NotAnnotations:    []string{"androidx.test.filters.FlakyTest", "org.junit.Ignore"},
+		Size:              TestSizeSmall,
+		NumShards:         4,
+		ShardIndex:        2,
+		Coverage:          true,
+		CoverageFile:      "/sdcard/coverage.ec",
+		Debug:             true,
+		Listeners:         []string{"com.example.Listener"},
+		UserID:            "10",
+		NoWindowAnimation: true,
+		RawOutput:         true,
+		Extras:            []InstrumentationExtra{{Key: "greeting", Value: "hello world"}, {Key: "clearPackageData", Value: "true"}},
+	}
+
+	// When
+	testCommand, err := mockModel().RunInstrumentationCmd("emulator-5554", "com.example.test", "androidx.test.runner.AndroidJUnitRunner", opts, nil)
+
+	// Then
+	require.NoError(t, err)
+	expectedArgs := `adb "-s" "emulator-5554" "shell" "am" "instrument" "-w" "-r" "--user" "10" "--no-window-animation" ` +
+		`"-e" "class" "com.example.LoginTest,com.example.SettingsTest#darkMode" ` +
+		`"-e" "notPackage" "com.example.flaky" ` +
+		`"-e" "annotation" "androidx.test.filters.SmallTest" ` +
+		`"-e" "notAnnotation" "androidx.test.filters.FlakyTest,org.junit.Ignore" ` +
+		`"-e" "size" "small" ` +
+		`"-e" "numShards" "4" "-e" "shardIndex" "2" ` +
+		`"-e" "coverage" "true" "-e" "coverageFile" "/sdcard/coverage.ec" ` +
+		`"-e" "debug" "true" ` +
+		`"-e" "listener" "com.example.Listener" ` +
+		`"-e" "greeting" "'hello world'" "-e" "clearPackageData" "true" ` +
+		`"com.example.test/androidx.test.runner.AndroidJUnitRunner"`
+	require.Equal(t, expectedArgs, testCommand.PrintableCommandArgs())
+}
+
+func Test_GivenNoOptions_WhenRunInstrumentationCmd_ThenCreatesExpectedCommand(t *testing.T) {
+	// When
+	testCommand, err := mockModel().RunInstrumentationCmd("", "com.example.test", "androidx.test.runner.AndroidJUnitRunner", InstrumentationOptions{}, nil)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, `adb "shell" "am" "instrument" "-w" "com.example.test/androidx.test.runner.AndroidJUnitRunner"`, testCommand.PrintableCommandArgs())
+}
+
+func Test_GivenUserIDWithShellMetacharacters_WhenArgs_ThenQuotesUserID(t *testing.T) {
+	// When
+	args, err := InstrumentationOptions{UserID: "10; reboot"}.Args()
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []string{"--user", `'10; reboot'`}, args)
+}
+
+func TestInstrumentationOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    InstrumentationOptions
+		wantErr string
+	}{
+		{
+			name: "Valid sharding",
+			opts: InstrumentationOptions{NumShards: 2, ShardIndex: 1},
+		},
+		{
+			name:    "Invalid size",
+			opts:    InstrumentationOptions{Size: "huge"},
+			wantErr: "invalid test size: huge",
+		},
+		{
+			name:    "Shard index without number of shards",
+			opts:    InstrumentationOptions{ShardIndex: 1},
+			wantErr: "shard index is set without the number of shards",
+		},
+		{
+			name:    "Shard index out of range",
+			opts:    InstrumentationOptions{NumShards: 2, ShardIndex: 2},
+			wantErr: "shard index (2) should be less than the number of shards (2)",
+		},
+		{
+			name:    "Coverage file without coverage",
+			opts:    InstrumentationOptions{CoverageFile: "/sdcard/coverage.ec"},
+			wantErr: "coverage file is set without enabling coverage",
+		},
+		{
+			name: "Invalid extras",
+			opts: InstrumentationOptions{Extras: []InstrumentationExtra{
+				{Key: "", Value: "value"},
+				{Key: "my key", Value: "value"},
+				{Key: "key;reboot", Value: "value"},
+				{Key: "class", Value: "com.example.LoginTest"},
+			}},
+			wantErr: "extra with empty key\ninvalid extra key: \"my key\"\ninvalid extra key: \"key;reboot\"\nextra key (class) should be set with the dedicated option",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			if tt.wantErr == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_quoteShellArg(t *testing.T) {
+	require.Equal(t, "com.example.FooTest#testBar", quoteShellArg("com.example.FooTest#testBar"))
+	require.Equal(t, "''", quoteShellArg(""))
+	require.Equal(t, "'#comment'", quoteShellArg("#comment"))
+	require.Equal(t, "'a b'", quoteShellArg("a b"))
+	require.Equal(t, `'it'\''s; rm -rf /'`, quoteShellArg("it's; rm -rf /"))
+}
@@ -0,0 +1,573 @@
+package adbmanager
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+// LogcatBuffer is a logcat ring buffer, see: https://developer.android.com/tools/logcat#alternativeBuffers
+type LogcatBuffer string
+
+// Logcat buffers ...
+const (
+	LogcatBufferMain   LogcatBuffer = "main"
+	LogcatBufferSystem LogcatBuffer = "system"
+	LogcatBufferCrash  LogcatBuffer = "crash"
+	LogcatBufferEvents LogcatBuffer = "events"
+	LogcatBufferRadio  LogcatBuffer = "radio"
+	LogcatBufferAll    LogcatBuffer = "all"
+)
+
+// LogcatFormat is the output format of logcat, only the formats supported by ParseLogcatLine are listed.
+type LogcatFormat string
+
+// Logcat formats ...
+const (
+	// LogcatFormatThreadtime is `10-18 12:34:56.789  1234  1256 I Tag: message`, the year is not part of the output.
+	LogcatFormatThreadtime LogcatFormat = "threadtime"
+	// LogcatFormatEpoch is threadtime with the seconds since the Unix epoch: `1760790896.789  1234  1256 I Tag: message`
+	LogcatFormatEpoch LogcatFormat = "epoch"
+)
+
+// LogPriority is the priority (level) of a log entry.
+type LogPriority string
+
+// Log priorities, LogPrioritySilent is only meaningful in filters.
+const (
+	LogPriorityVerbose LogPriority = "V"
+	LogPriorityDebug   LogPriority = "D"
+	LogPriorityInfo    LogPriority = "I"
+	LogPriorityWarning LogPriority = "W"
+	LogPriorityError   LogPriority = "E"
+	LogPriorityFatal   LogPriority = "F"
+	LogPrioritySilent  LogPriority = "S"
+)
+
+// LogcatFilter is a `<tag>:<priority>` filter spec, use `*` as the tag to set the default priority.
+type LogcatFilter struct {
+	Tag      string
+	Priority LogPriority
+}
+
+func (filter LogcatFilter) String() string {
+	return filter.Tag + ":" + string(filter.Priority)
+}
+
+// LogcatOptions ...
+type LogcatOptions struct {
+	// Buffers to read, logcat's default (main, system and crash) is used if empty.
+	Buffers []LogcatBuffer
+	// Format defaults to LogcatFormatThreadtime.
+	Format  LogcatFormat
+	Filters []LogcatFilter
+	// Clear clears the buffers before starting the capture, so that only the new entries are collected.
+	Clear bool
+	// OutputPath is the file the log is streamed to, the log is only parsed (for crashes) if empty.
+	OutputPath string
+	// MaxFileSize rotates the output file once it reaches the given size in bytes, 0 disables the rotation.
+	MaxFileSize int64
+	// MaxFiles is the number of files kept by the rotation (including the current one): <output>, <output>.1, ...
+	// It defaults to (and is at least) 2 if MaxFileSize is set, so the rotated log is never discarded.
+	MaxFiles int
+}
+
+func (opts LogcatOptions) args() ([]string, error) {
+	var args []string
+	for _, buffer := range opts.Buffers {
+		args = append(args, "-b", string(buffer))
+	}
+
+	switch opts.Format {
+	case "", LogcatFormatThreadtime:
+		args = append(args, "-v", "threadtime")
+	case LogcatFormatEpoch:
+		args = append(args, "-v", "threadtime", "-v", "epoch")
+	default:
+		return nil, fmt.Errorf("unsupported logcat format: %s", opts.Format)
+	}
+
+	for _, filter := range opts.Filters {
+		if filter.Tag == "" {
+			return nil, errors.New("logcat filter with empty tag")
+		}
+		switch filter.Priority {
+		case LogPriorityVerbose, LogPriorityDebug, LogPriorityInfo, LogPriorityWarning, LogPriorityError, LogPriorityFatal, LogPrioritySilent:
+		default:
+			return nil, fmt.Errorf("invalid logcat filter priority: %s", filter)
+		}
+		args = append(args, filter.String())
+	}
+
+	return args, nil
+}
+
+// LogcatCmd builds and returns a `Command` printing the device log until it is stopped.
+func (model Model) LogcatCmd(serial string, opts LogcatOptions, commandOptions *command.Opts) (command.Command, error) {
+	logcatArgs, err := opts.args()
+	if err != nil {
+		return nil, err
+	}
+
+	args := append([]string{"-s", serial, "logcat"}, logcatArgs...)
+	return model.adbCmd(args, commandOptions), nil
+}
+
+// logcatPollInterval is the interval of checking whether the pid of the logcat process has been printed.
+const logcatPollInterval = 100 * time.Millisecond
+
+// LogcatCollector captures the log of a device in the background, see StartLogcat.
+type LogcatCollector struct {
+	model  Model
+	serial string
+	done   chan struct{}
+	err    error
+
+	mu       sync.Mutex
+	output   *rotatingFile
+	buf      []byte
+	pid      string
+	crashes  crashDetector
+	finished bool
+}
+
+// StartLogcat starts capturing the log of the device. The log is streamed to opts.OutputPath and scanned for crashes,
+// call Stop to finish the capture.
+//
+// `command.Command` can't be killed, so logcat is started through the device shell, which prints its pid first:
+// Stop interrupts the logcat process on the device, which makes the adb process exit.
+func (model Model) StartLogcat(serial string, opts LogcatOptions) (*LogcatCollector, error) {
+	logcatArgs, err := opts.args()
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Clear {
+		clearArgs := []string{"-s", serial, "logcat"}
+		for _, buffer := range opts.Buffers {
+			clearArgs = append(clearArgs, "-b", string(buffer))
+		}
+		clearArgs = append(clearArgs, "-c")
+		if out, err := model.adbCmd(clearArgs, nil).RunAndReturnTrimmedCombinedOutput(); err != nil {
+			return nil, fmt.Errorf("clear logcat: %s: %w", out, err)
+		}
+	}
+
+	collector := &LogcatCollector{
+		model:  model,
+		serial: serial,
+		done:   make(chan struct{}),
+	}
+	if opts.OutputPath != "" {
+		collector.output, err = newRotatingFile(opts.OutputPath, opts.MaxFileSize, opts.MaxFiles)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	args := interruptibleShellArgs(serial, append([]string{"logcat"}, logcatArgs...)...)
+	cmd := model.adbCmd(args, &command.Opts{Stdout: collector})
+
+	model.logger.Printf("$ %s", cmd.PrintableCommandArgs())
+	if err := cmd.Start(); err != nil {
+		collector.finish()
+		return nil, fmt.Errorf("start logcat: %w", err)
+	}
+
+	go func() {
+		collector.err = cmd.Wait()
+		close(collector.done)
+	}()
+
+	return collector, nil
+}
+
+// Done is closed once the logcat process exits, for example when the device disconnects.
+func (collector *LogcatCollector) Done() <-chan struct{} {
+	return collector.done
+}
+
+// Err returns the error of the logcat process, once Done is closed.
+func (collector *LogcatCollector) Err() error {
+	select {
+	case <-collector.done:
+		return collector.err
+	default:
+		return nil
+	}
+}
+
+// Stop interrupts logcat and waits for it to exit, or for ctx to be done. The output file is closed in both cases.
+func (collector *LogcatCollector) Stop(ctx context.Context) error {
+	var stopErr error
+	select {
+	case <-collector.done:
+	default:
+		stopErr = collector.interrupt(ctx)
+	}
+
+	if stopErr == nil {
+		select {
+		case <-collector.done:
+		case <-ctx.Done():
+			stopErr = fmt.Errorf("wait for logcat to exit: %w", ctx.Err())
+		}
+	}
+
+	if err := collector.finish(); err != nil && stopErr == nil {
+		stopErr = err
+	}
+	return stopErr
+}
+
+// interrupt waits for the pid of the logcat process if it has not been printed yet, and interrupts the process.
+func (collector *LogcatCollector) interrupt(ctx context.Context) error {
+	for {
+		select {
+		case <-collector.done:
+			return nil
+		default:
+		}
+
+		collector.mu.Lock()
+		pid := collector.pid
+		collector.mu.Unlock()
+		if pid != "" {
+			if err := collector.model.interruptDeviceProcess(ctx, collector.serial, pid); err != nil {
+				return fmt.Errorf("stop logcat: %w", err)
+			}
+			return nil
+		}
+
+		if err := sleepWithContext(ctx, logcatPollInterval); err != nil {
+			return fmt.Errorf("stop logcat: wait for the pid of the logcat process: %w", err)
+		}
+	}
+}
+
+// Crashes returns the crashes found in the log so far.
+func (collector *LogcatCollector) Crashes() []CrashBlock {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+
+	return collector.crashes.blocks()
+}
+
+// Write processes the output of the logcat process line by line.
+func (collector *LogcatCollector) Write(p []byte) (int, error) {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+
+	if collector.finished {
+		return len(p), nil
+	}
+
+	collector.buf = append(collector.buf, p...)
+	for {
+		i := bytes.IndexByte(collector.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimSuffix(string(collector.buf[:i]), "\r")
+		collector.buf = collector.buf[i+1:]
+		if err := collector.processLine(line); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+func (collector *LogcatCollector) processLine(line string) error {
+	if collector.pid == "" {
+		if pid, ok := parsePIDLine(line); ok {
+			collector.pid = pid
+			return nil
+		}
+	}
+
+	if entry, ok := ParseLogcatLine(line); ok {
+		collector.crashes.add(entry)
+	}
+	if collector.output != nil {
+		return collector.output.writeLine(line)
+	}
+	return nil
+}
+
+func (collector *LogcatCollector) finish() error {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+
+	if collector.finished {
+		return nil
+	}
+	collector.finished = true
+
+	var err error
+	if len(collector.buf) > 0 {
+		err = collector.processLine(string(collector.buf))
+		collector.buf = nil
+	}
+	if collector.output != nil {
+		if closeErr := collector.output.close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// LogEntry is a parsed logcat line.
+type LogEntry struct {
+	// Time is in the local timezone of the host, its year is 0 for the threadtime format.
+	Time    time.Time
+	PID     int
+	TID     int
+	Level   LogPriority
+	Tag     string
+	Message string
+}
+
+var logcatLinePattern = regexp.MustCompile(`^(\d\d-\d\d \d\d:\d\d:\d\d\.\d+|\d+\.\d+)\s+(\d+)\s+(\d+)\s+([VDIWEFS])\s+(.*?)\s*:(?: (.*))?$`)
+
+// ParseLogcatLine parses a line of the threadtime or epoch format, lines like `--------- beginning of main` are not entries.
+func ParseLogcatLine(line string) (LogEntry, bool) {
+	match := logcatLinePattern.FindStringSubmatch(line)
+	if match == nil {
+		return LogEntry{}, false
+	}
+
+	var entry LogEntry
+	if strings.Contains(match[1], "-") {
+		t, err := time.ParseInLocation("01-02 15:04:05.000", match[1], time.Local)
+		if err != nil {
+			return LogEntry{}, false
+		}
+		entry.Time = t
+	} else {
+		seconds, err := strconv.ParseFloat(match[1], 64)
+		if err != nil {
+			return LogEntry{}, false
+		}
+		entry.Time = time.UnixMilli(int64(seconds * 1000))
+	}
+
+	entry.PID, _ = strconv.Atoi(match[2])
+	entry.TID, _ = strconv.Atoi(match[3])
+	entry.Level = LogPriority(match[4])
+	entry.Tag = match[5]
+	entry.Message = match[6]
+
+	return entry, true
+}
+
+// CrashKind ...
+type CrashKind string
+
+// Crash kinds ...
+const (
+	CrashKindJava   CrashKind = "java"
+	CrashKindANR    CrashKind = "anr"
+	CrashKindNative CrashKind = "native"
+)
+
+// CrashBlock is a crash report found in the log: the consecutive entries of the reporting tag and process.
+type CrashBlock struct {
+	Kind CrashKind
+	Time time.Time
+	// Process is the name of the crashed process, if the report contains it.
+	Process string
+	Lines   []string
+}
+
+// FormatCrashSummary returns a human readable summary of the crashes.
+func FormatCrashSummary(crashes []CrashBlock) string {
+	if len(crashes) == 0 {
+		return "No crashes found"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d crash(es) found:", len(crashes))
+	for _, crash := range crashes {
+		process := crash.Process
+		if process == "" {
+			process = "unknown process"
+		}
+		fmt.Fprintf(&b, "\n\n[%s] %s", crash.Kind, process)
+		for _, line := range crash.Lines {
+			b.WriteString("\n    " + line)
+		}
+	}
+	return b.String()
+}
+
+// ParseCrashes returns the crashes of a captured log (threadtime or epoch format).
+func ParseCrashes(log string) []CrashBlock {
+	var detector crashDetector
+	for _, line := range strings.Split(log, "\n") {
+		if entry, ok := ParseLogcatLine(strings.TrimSuffix(line, "\r")); ok {
+			detector.add(entry)
+		}
+	}
+	return detector.blocks()
+}
+
+var (
+	// Process: com.example, PID: 1234
+	javaCrashProcessPattern = regexp.MustCompile(`^Process: ([^,\s]+)`)
+	// ANR in com.example (com.example/.MainActivity)
+	anrProcessPattern = regexp.MustCompile(`^ANR in (\S+)`)
+	// pid: 1234, tid: 1234, name: RenderThread  >>> com.example <<<
+	nativeCrashProcessPattern = regexp.MustCompile(`>>> (\S+) <<<`)
+)
+
+type crashDetector struct {
+	crashes []CrashBlock
+	current *CrashBlock
+	tag     string
+	pid     int
+}
+
+func (detector *crashDetector) add(entry LogEntry) {
+	if detector.current != nil {
+		if entry.Tag == detector.tag && entry.PID == detector.pid && !isCrashStart(entry) {
+			detector.current.Lines = append(detector.current.Lines, entry.Message)
+			detector.setProcess(entry.Message)
+			return
+		}
+		detector.closeCurrent()
+	}
+
+	if !isCrashStart(entry) {
+		return
+	}
+	detector.current = &CrashBlock{Kind: crashKind(entry), Time: entry.Time, Lines: []string{entry.Message}}
+	detector.tag = entry.Tag
+	detector.pid = entry.PID
+	detector.setProcess(entry.Message)
+}
+
+func (detector *crashDetector) setProcess(message string) {
+	if detector.current.Process != "" {
+		return
+	}
+	for _, pattern := range []*regexp.Regexp{javaCrashProcessPattern, anrProcessPattern, nativeCrashProcessPattern} {
+		if match := pattern.FindStringSubmatch(message); match != nil {
+			detector.current.Process = match[1]
+			return
+		}
+	}
+}
+
+func (detector *crashDetector) closeCurrent() {
+	if detector.current != nil {
+		detector.crashes = append(detector.crashes, *detector.current)
+		detector.current = nil
+	}
+}
+
+func (detector *crashDetector) blocks() []CrashBlock {
+	blocks := append([]CrashBlock{}, detector.crashes...)
+	if detector.current != nil {
+		blocks = append(blocks, *detector.current)
+	}
+	if len(blocks) == 0 {
+		return nil
+	}
+	return blocks
+}
+
+func isCrashStart(entry LogEntry) bool {
+	return crashKind(entry) != ""
+}
+
+func crashKind(entry LogEntry) CrashKind {
+	switch {
+	case entry.Tag == "AndroidRuntime" && strings.HasPrefix(entry.Message, "FATAL EXCEPTION"):
+		return CrashKindJava
+	case entry.Tag == "ActivityManager" && strings.HasPrefix(entry.Message, "ANR in "):
+		return CrashKindANR
+	case entry.Tag == "DEBUG" && strings.HasPrefix(entry.Message, "*** *** ***"):
+		return CrashKindNative
+	}
+	return ""
+}
+
+// minLogcatFiles keeps at least one backup, otherwise the rotation would truncate the only file.
+const minLogcatFiles = 2
+
+// rotatingFile writes lines to a file, which is rotated once it reaches maxSize: <path> is renamed to <path>.1,
+// <path>.1 to <path>.2 and so on, keeping at most maxFiles files.
+type rotatingFile struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	file *os.File
+	size int64
+}
+
+func newRotatingFile(path string, maxSize int64, maxFiles int) (*rotatingFile, error) {
+	if maxFiles < minLogcatFiles {
+		maxFiles = minLogcatFiles
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create logcat output file: %w", err)
+	}
+	return &rotatingFile{path: path, maxSize: maxSize, maxFiles: maxFiles, file: file}, nil
+}
+
+func (f *rotatingFile) writeLine(line string) error {
+	data := line + "\n"
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(data)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := f.file.WriteString(data)
+	f.size += int64(n)
+	return err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+
+	// the oldest backup is overwritten by the rename
+	for i := f.maxFiles - 2; i >= 1; i-- {
+		if err := os.Rename(f.backupPath(i), f.backupPath(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("rotate logcat output: %w", err)
+		}
+	}
+	if err := os.Rename(f.path, f.backupPath(1)); err != nil {
+		return fmt.Errorf("rotate logcat output: %w", err)
+	}
+
+	file, err := os.Create(f.path)
+	if err != nil {
+		return fmt.Errorf("rotate logcat output: %w", err)
+	}
+	f.file = file
+	f.size = 0
+	return nil
+}
+
+func (f *rotatingFile) backupPath(i int) string {
+	return f.path + "." + strconv.Itoa(i)
+}
+
+func (f *rotatingFile) close() error {
+	return f.file.Close()
+}
@@ -0,0 +1,252 @@
+package adbmanager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GivenOptions_WhenLogcatCmd_ThenCreatesExpectedCommand(t *testing.T) {
+	// Given
+	opts := LogcatOptions{
+		Buffers: []LogcatBuffer{LogcatBufferMain, LogcatBufferCrash},
+		Format:  LogcatFormatEpoch,
+		Filters: []LogcatFilter{{Tag: "AndroidRuntime", Priority: LogPriorityError}, {Tag: "*", Priority: LogPrioritySilent}},
+	}
+
+	// When
+	testCommand, err := mockModel().LogcatCmd("emulator-5554", opts, nil)
+
+	// Then
+	require.NoError(t, err)
+	expectedArgs := `adb "-s" "emulator-5554" "logcat" "-b" "main" "-b" "crash" "-v" "threadtime" "-v" "epoch" "AndroidRuntime:E" "*:S"`
+	require.Equal(t, expectedArgs, testCommand.PrintableCommandArgs())
+}
+
+func Test_GivenInvalidFilter_WhenLogcatCmd_ThenFails(t *testing.T) {
+	// When
+	_, err := mockModel().LogcatCmd("emulator-5554", LogcatOptions{Filters: []LogcatFilter{{Tag: "MyApp", Priority: "X"}}}, nil)
+
+	// Then
+	require.EqualError(t, err, "invalid logcat filter priority: MyApp:X")
+}
+
+func Test_GivenRunningLogcat_WhenStop_ThenInterruptsLogcatAndCollectsCrashes(t *testing.T) {
+	// Given
+	outputPath := filepath.Join(t.TempDir(), "logcat.txt")
+	factory := newInterruptibleCommandFactory("4242\n" + crashLog)
+	model := mockModelWithFactory(factory)
+
+	collector, err := model.StartLogcat("emulator-5554", LogcatOptions{
+		Clear:      true,
+		Filters:    []LogcatFilter{{Tag: "*", Priority: LogPriorityInfo}},
+		OutputPath: outputPath,
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return len(collector.Crashes()) == 3 }, time.Second, time.Millisecond)
+
+	// When
+	err = collector.Stop(context.Background())
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, [][]string{
+		{"-s", "emulator-5554", "logcat", "-c"},
+		{"-s", "emulator-5554", "shell", "echo", "$$;", "exec", "logcat", "-v", "threadtime", "'*:I'"},
+		{"-s", "emulator-5554", "shell", "kill", "-INT", "4242"},
+	}, factory.Calls())
+
+	content, err := os.ReadFile(outputPath)
+	require.NoError(t, err)
+	require.Equal(t, crashLog, string(content))
+}
+
+func Test_GivenHangingLogcat_WhenStopTimesOut_ThenReturnsError(t *testing.T) {
+	// Given
+	factory := newInterruptibleCommandFactory("4242\n")
+	factory.ignoreKill = true
+	collector, err := mockModelWithFactory(factory).StartLogcat("emulator-5554", LogcatOptions{})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		collector.mu.Lock()
+		defer collector.mu.Unlock()
+		return collector.pid == "4242"
+	}, time.Second, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// When
+	err = collector.Stop(ctx)
+
+	// Then
+	require.EqualError(t, err, "wait for logcat to exit: context deadline exceeded")
+	factory.release()
+}
+
+func Test_GivenPIDNotPrintedYet_WhenStop_ThenWaitsForPIDAndInterruptsLogcat(t *testing.T) {
+	// Given
+	factory := newInterruptibleCommandFactory("4242\n")
+	factory.outputDelay = 50 * time.Millisecond
+	collector, err := mockModelWithFactory(factory).StartLogcat("emulator-5554", LogcatOptions{})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// When
+	err = collector.Stop(ctx)
+
+	// Then
+	require.NoError(t, err)
+	require.Contains(t, factory.Calls(), []string{"-s", "emulator-5554", "shell", "kill", "-INT", "4242"})
+}
+
+func Test_ParseLogcatLine(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		want   LogEntry
+		wantOK bool
+	}{
+		{
+			name: "threadtime",
+			line: "10-18 12:34:56.789  1234  1256 I ActivityManager: Start proc 1234:com.example/u0a154",
+			want: LogEntry{
+				Time:    time.Date(0, 10, 18, 12, 34, 56, 789000000, time.Local),
+				PID:     1234,
+				TID:     1256,
+				Level:   LogPriorityInfo,
+				Tag:     "ActivityManager",
+				Message: "Start proc 1234:com.example/u0a154",
+			},
+			wantOK: true,
+		},
+		{
+			name: "epoch with padded tag",
+			line: "1760790896.789  1300  1300 F DEBUG   : *** *** *** *** *** ***",
+			want: LogEntry{
+				Time:    time.UnixMilli(1760790896789),
+				PID:     1300,
+				TID:     1300,
+				Level:   LogPriorityFatal,
+				Tag:     "DEBUG",
+				Message: "*** *** *** *** *** ***",
+			},
+			wantOK: true,
+		},
+		{
+			name: "empty message",
+			line: "10-18 12:34:56.789  1234  1256 D MyApp:",
+			want: LogEntry{
+				Time:  time.Date(0, 10, 18, 12, 34, 56, 789000000, time.Local),
+				PID:   1234,
+				TID:   1256,
+				Level: LogPriorityDebug,
+				Tag:   "MyApp",
+			},
+			wantOK: true,
+		},
+		{
+			name: "buffer separator",
+			line: "--------- beginning of crash",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseLogcatLine(tt.line)
+			require.Equal(t, tt.wantOK, ok)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_ParseCrashes(t *testing.T) {
+	crashes := ParseCrashes(crashLog)
+
+	require.Len(t, crashes, 3)
+
+	require.Equal(t, CrashKindJava, crashes[0].Kind)
+	require.Equal(t, "com.example", crashes[0].Process)
+	require.Equal(t, []string{
+		"FATAL EXCEPTION: main",
+		"Process: com.example, PID: 1234",
+		"java.lang.IllegalStateException: boom",
+		"\tat com.example.MainActivity.onCreate(MainActivity.kt:12)",
+	}, crashes[0].Lines)
+
+	require.Equal(t, CrashKindANR, crashes[1].Kind)
+	require.Equal(t, "com.example", crashes[1].Process)
+	require.Len(t, crashes[1].Lines, 3)
+
+	require.Equal(t, CrashKindNative, crashes[2].Kind)
+	require.Equal(t, "com.example", crashes[2].Process)
+	require.Len(t, crashes[2].Lines, 4)
+
+	require.True(t, strings.HasPrefix(FormatCrashSummary(crashes), "3 crash(es) found:\n\n[java] com.example\n    FATAL EXCEPTION: main"))
+}
+
+func Test_GivenMaxFileSize_WhenWritingLines_ThenRotatesFiles(t *testing.T) {
+	// Given
+	path := filepath.Join(t.TempDir(), "logcat.txt")
+	file, err := newRotatingFile(path, 10, 3)
+	require.NoError(t, err)
+
+	// When
+	for _, line := range []string{"line 1", "line 2", "line 3", "line 4"} {
+		require.NoError(t, file.writeLine(line))
+	}
+	require.NoError(t, file.close())
+
+	// Then
+	for suffix, want := range map[string]string{"": "line 4\n", ".1": "line 3\n", ".2": "line 2\n"} {
+		content, err := os.ReadFile(path + suffix)
+		require.NoError(t, err)
+		require.Equal(t, want, string(content))
+	}
+	_, err = os.Stat(path + ".3")
+	require.True(t, os.IsNotExist(err))
+}
+
+func Test_GivenMaxFileSizeWithoutMaxFiles_WhenWritingLines_ThenKeepsOneBackup(t *testing.T) {
+	// Given
+	path := filepath.Join(t.TempDir(), "logcat.txt")
+	file, err := newRotatingFile(path, 10, 0)
+	require.NoError(t, err)
+
+	// When
+	for _, line := range []string{"line 1", "line 2", "line 3"} {
+		require.NoError(t, file.writeLine(line))
+	}
+	require.NoError(t, file.close())
+
+	// Then
+	for suffix, want := range map[string]string{"": "line 3\n", ".1": "line 2\n"} {
+		content, err := os.ReadFile(path + suffix)
+		require.NoError(t, err)
+		require.Equal(t, want, string(content))
+	}
+	_, err = os.Stat(path + ".2")
+	require.True(t, os.IsNotExist(err))
+}
+
+const crashLog = `--------- beginning of crash
+10-18 12:00:00.000  1234  1234 E AndroidRuntime: FATAL EXCEPTION: main
+10-18 12:00:00.000  1234  1234 E AndroidRuntime: Process: com.example, PID: 1234
+10-18 12:00:00.000  1234  1234 E AndroidRuntime: java.lang.IllegalStateException: boom
+10-18 12:00:00.000  1234  1234 E AndroidRuntime: 	at com.example.MainActivity.onCreate(MainActivity.kt:12)
+10-18 12:00:00.010   560   590 I ActivityManager: Force stopping com.example
+10-18 12:01:00.000   560   602 E ActivityManager: ANR in com.example (com.example/.MainActivity)
+10-18 12:01:00.000   560   602 E ActivityManager: PID: 1300
+10-18 12:01:00.000   560   602 E ActivityManager: Reason: Input dispatching timed out
+10-18 12:01:00.100  1300  1300 I MyApp: still here
+10-18 12:02:00.000  1400  1400 F DEBUG   : *** *** *** *** *** *** *** *** *** *** *** *** *** *** *** ***
+10-18 12:02:00.000  1400  1400 F DEBUG   : Build fingerprint: 'google/sdk_gphone64_x86_64/emu64xa:14/UE1A.230829.036/10747950:userdebug/dev-keys'
+10-18 12:02:00.000  1400  1400 F DEBUG   : pid: 1300, tid: 1320, name: RenderThread  >>> com.example <<<
+10-18 12:02:00.000  1400  1400 F DEBUG   : signal 11 (SIGSEGV), code 1 (SEGV_MAPERR), fault addr 0x0
+`
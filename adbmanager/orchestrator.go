@@ -0,0 +1,129 @@
+package adbmanager
+
+import (
+	"fmt"
+
+	"github.com/bitrise-io/go-android/v2/testresult/instrumentation"
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+// Android Test Orchestrator components, see: https://developer.android.com/training/testing/instrumented-tests/androidx-test-libraries/runner#use-android
+const (
+	OrchestratorComponent = "androidx.test.orchestrator/androidx.test.orchestrator.AndroidTestOrchestrator"
+	TestServicesPackage   = "androidx.test.services"
+	shellMainClass        = "androidx.test.services.shellexecutor.ShellMain"
+)
+
+// OrchestratorAPKs are the local paths of the orchestrator and the test services APKs.
+type OrchestratorAPKs struct {
+	Orchestrator string
+	TestServices string
+	// ForceQueryable makes the packages visible to apps targeting API 30+, the flag is only supported from API 30.
+	ForceQueryable bool
+}
+
+// OrchestratorOptions ...
+type OrchestratorOptions struct {
+	// Instrumentation options are forwarded to the target instrumentation by the orchestrator.
+	Instrumentation InstrumentationOptions
+	// ClearPackageData removes all shared state of the app under test after every test.
+	ClearPackageData bool
+}
+
+// InstallOrchestratorCmds returns the commands installing the orchestrator and the test services APKs on the device.
+func (model Model) InstallOrchestratorCmds(serial string, apks OrchestratorAPKs, commandOptions *command.Opts) []command.Command {
+	var cmds []command.Command
+	for _, apk := range []string{apks.Orchestrator, apks.TestServices} {
+		args := []string{"-s", serial, "install", "-r", "-t"}
+		if apks.ForceQueryable {
+			args = append(args, "--force-queryable")
+		}
+		args = append(args, apk)
+
+		cmds = append(cmds, model.adbCmd(args, commandOptions))
+	}
+	return cmds
+}
+
+// InstallOrchestrator installs the orchestrator and the test services APKs on the device.
+func (model Model) InstallOrchestrator(serial string, apks OrchestratorAPKs) error {
+	for _, cmd := range model.InstallOrchestratorCmds(serial, apks, nil) {
+		model.logger.Printf("$ %s", cmd.PrintableCommandArgs())
+		if out, err := cmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
+			return fmt.Errorf("install test orchestrator: %s: %w", out, err)
+		}
+	}
+	return nil
+}
+
+// RunOrchestratedTestsCmd builds and returns a `Command` running the instrumented tests through the orchestrator:
+//
+// adb shell CLASSPATH=$(pm path androidx.test.services) app_process / androidx.test.services.shellexecutor.ShellMain \
+// am instrument -w -e targetInstrumentation <package>/<runner> [...] androidx.test.orchestrator/.AndroidTestOrchestrator
+func (model Model) RunOrchestratedTestsCmd(
+	serial string,
+	packageName string,
+	testRunnerClass string,
+	opts OrchestratorOptions,
+	commandOptions *command.Opts,
+) (command.Command, error) {
+	for _, extra := range opts.Instrumentation.Extras {
+		if extra.Key == "targetInstrumentation" || extra.Key == "clearPackageData" {
+			return nil, fmt.Errorf("invalid orchestrator options: extra key (%s) is set by the orchestrator command", extra.Key)
+		}
+	}
+
+	instrumentationArgs, err := opts.Instrumentation.Args()
+	if err != nil {
+		return nil, err
+	}
+
+	var args []string
+	if serial != "" {
+		args = append(args, "-s", serial)
+	}
+	args = append(args,
+		"shell",
+		"CLASSPATH=$(pm path "+TestServicesPackage+")",
+		"app_process", "/", shellMainClass,
+		"am", "instrument", "-w",
+	)
+	args = append(args, instrumentationArgs...)
+	args = append(args, "-e", "targetInstrumentation", packageName+"/"+testRunnerClass)
+	if opts.ClearPackageData {
+		args = append(args, "-e", "clearPackageData", "true")
+	}
+	args = append(args, OrchestratorComponent)
+
+	return model.adbCmd(args, commandOptions), nil
+}
+
+// RunOrchestratedTests runs the instrumented tests through the orchestrator and returns the per-test results.
+// onEvent (if not nil) is called while the tests are running.
+func (model Model) RunOrchestratedTests(
+	serial string,
+	packageName string,
+	testRunnerClass string,
+	opts OrchestratorOptions,
+	onEvent func(instrumentation.Event),
+) (instrumentation.Result, error) {
+	opts.Instrumentation.RawOutput = true
+	parser := instrumentation.NewParser(onEvent)
+
+	cmd, err := model.RunOrchestratedTestsCmd(serial, packageName, testRunnerClass, opts, &command.Opts{Stdout: parser})
+	if err != nil {
+		return instrumentation.Result{}, err
+	}
+
+	model.logger.Printf("$ %s", cmd.PrintableCommandArgs())
+	runErr := cmd.Run()
+	result, err := parser.Close()
+	if err != nil {
+		return result, err
+	}
+	if runErr != nil {
+		return result, fmt.Errorf("run orchestrated tests: %w", runErr)
+	}
+
+	return result, nil
+}
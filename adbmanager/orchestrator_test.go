@@ -0,0 +1,122 @@
+package adbmanager
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/go-android/v2/testresult/instrumentation"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GivenOrchestratorAPKs_WhenInstallOrchestratorCmds_ThenCreatesExpectedCommands(t *testing.T) {
+	// Given
+	apks := OrchestratorAPKs{
+		Orchestrator:   "/tmp/orchestrator-1.4.2.apk",
+		TestServices:   "/tmp/test-services-1.4.2.apk",
+		ForceQueryable: true,
+	}
+
+	// When
+	cmds := mockModel().InstallOrchestratorCmds("emulator-5554", apks, nil)
+
+	// Then
+	require.Len(t, cmds, 2)
+	require.Equal(t, `adb "-s" "emulator-5554" "install" "-r" "-t" "--force-queryable" "/tmp/orchestrator-1.4.2.apk"`, cmds[0].PrintableCommandArgs())
+	require.Equal(t, `adb "-s" "emulator-5554" "install" "-r" "-t" "--force-queryable" "/tmp/test-services-1.4.2.apk"`, cmds[1].PrintableCommandArgs())
+}
+
+func Test_GivenClearPackageData_WhenRunOrchestratedTestsCmd_ThenCreatesExpectedCommand(t *testing.T) {
+	// Given
+	opts := OrchestratorOptions{
+		Instrumentation:  InstrumentationOptions{RawOutput: true, Classes: []string{"com.example.LoginTest"}},
+		ClearPackageData: true,
+	}
+
+	// When
+	testCommand, err := mockModel().RunOrchestratedTestsCmd("emulator-5554", "com.example.test", "androidx.test.runner.AndroidJUnitRunner", opts, nil)
+
+	// Then
+	require.NoError(t, err)
+	expectedArgs := `adb "-s" "emulator-5554" "shell" "CLASSPATH=$(pm path androidx.test.services)" "app_process" "/" "androidx.test.services.shellexecutor.ShellMain" ` +
+		`"am" "instrument" "-w" "-r" "-e" "class" "com.example.LoginTest" ` +
+		`"-e" "targetInstrumentation" "com.example.test/androidx.test.runner.AndroidJUnitRunner" "-e" "clearPackageData" "true" ` +
+		`"androidx.test.orchestrator/androidx.test.orchestrator.AndroidTestOrchestrator"`
+	require.Equal(t, expectedArgs, testCommand.PrintableCommandArgs())
+}
+
+func Test_GivenReservedExtra_WhenRunOrchestratedTestsCmd_ThenFails(t *testing.T) {
+	// Given
+	opts := OrchestratorOptions{
+		Instrumentation: InstrumentationOptions{Extras: []InstrumentationExtra{{Key: "clearPackageData", Value: "true"}}},
+	}
+
+	// When
+	_, err := mockModel().RunOrchestratedTestsCmd("emulator-5554", "com.example.test", "androidx.test.runner.AndroidJUnitRunner", opts, nil)
+
+	// Then
+	require.EqualError(t, err, "invalid orchestrator options: extra key (clearPackageData) is set by the orchestrator command")
+}
+
+func Test_GivenOrchestratorOutput_WhenRunOrchestratedTests_ThenReturnsPerTestResults(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: orchestratorOutput}
+	})
+
+	// When
+	result, err := mockModelWithFactory(factory).RunOrchestratedTests("emulator-5554", "com.example.test", "androidx.test.runner.AndroidJUnitRunner", OrchestratorOptions{ClearPackageData: true}, nil)
+
+	// Then
+	require.NoError(t, err)
+	require.Len(t, result.Tests, 2)
+	require.Equal(t, instrumentation.TestStatusPassed, result.Tests[0].Status)
+	require.Equal(t, instrumentation.TestStatusFailed, result.Tests[1].Status)
+	require.Equal(t, "Test instrumentation process crashed.", result.Tests[1].StackTrace)
+	require.Contains(t, factory.Calls()[0], "-r")
+}
+
+// Each test runs in its own instrumentation, a crashing test is reported as a failure by the orchestrator.
+const orchestratorOutput = `INSTRUMENTATION_STATUS: class=com.example.LoginTest
+INSTRUMENTATION_STATUS: current=1
+INSTRUMENTATION_STATUS: id=AndroidJUnitRunner
+INSTRUMENTATION_STATUS: numtests=2
+INSTRUMENTATION_STATUS: stream=
+com.example.LoginTest:
+INSTRUMENTATION_STATUS: test=validLogin
+INSTRUMENTATION_STATUS_CODE: 1
+INSTRUMENTATION_STATUS: class=com.example.LoginTest
+INSTRUMENTATION_STATUS: current=1
+INSTRUMENTATION_STATUS: id=AndroidJUnitRunner
+INSTRUMENTATION_STATUS: numtests=2
+INSTRUMENTATION_STATUS: stream=.
+INSTRUMENTATION_STATUS: test=validLogin
+INSTRUMENTATION_STATUS_CODE: 0
+INSTRUMENTATION_STATUS: class=com.example.LoginTest
+INSTRUMENTATION_STATUS: current=2
+INSTRUMENTATION_STATUS: id=AndroidJUnitRunner
+INSTRUMENTATION_STATUS: numtests=2
+INSTRUMENTATION_STATUS: stream=
+INSTRUMENTATION_STATUS: test=crashingLogin
+INSTRUMENTATION_STATUS_CODE: 1
+INSTRUMENTATION_STATUS: class=com.example.LoginTest
+INSTRUMENTATION_STATUS: current=2
+INSTRUMENTATION_STATUS: id=AndroidJUnitRunner
+INSTRUMENTATION_STATUS: numtests=2
+INSTRUMENTATION_STATUS: stack=Test instrumentation process crashed.
+INSTRUMENTATION_STATUS: stream=
+Error in crashingLogin(com.example.LoginTest):
+Test instrumentation process crashed.
+INSTRUMENTATION_STATUS: test=crashingLogin
+INSTRUMENTATION_STATUS_CODE: -2
+INSTRUMENTATION_RESULT: stream=
+
+Time: 3.21
+There was 1 failure:
+1) crashingLogin(com.example.LoginTest)
+Test instrumentation process crashed.
+
+FAILURES!!!
+Tests run: 2,  Failures: 1
+
+
+INSTRUMENTATION_CODE: -1
+`
@@ -0,0 +1,257 @@
+package adbmanager
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/sliceutil"
+)
+
+// ErrPackageNotInstalled is returned when the package is not installed on the device.
+var ErrPackageNotInstalled = errors.New("package is not installed")
+
+// notInstalledForUserAPILevel is the API level from which uninstalling an unknown package fails with
+// `not installed for <user>`, older versions report DELETE_FAILED_INTERNAL_ERROR.
+const notInstalledForUserAPILevel = 30
+
+// Package is an entry of `pm list packages`.
+type Package struct {
+	Name        string
+	VersionCode int64
+	// Path is the path of the package's base APK, only set if ListPackagesOptions.IncludePath is set.
+	Path string
+}
+
+// ListPackagesOptions ...
+type ListPackagesOptions struct {
+	// ThirdPartyOnly lists only third party packages (-3), SystemOnly lists only system packages (-s).
+	ThirdPartyOnly bool
+	SystemOnly     bool
+	IncludePath    bool
+	// UserID lists the packages of the given user, the packages of all users are listed if empty.
+	UserID string
+	// Filter lists only the packages whose name contains the given text.
+	Filter string
+}
+
+// PackageInfo is the package information parsed from `dumpsys package <package>`.
+type PackageInfo struct {
+	Name        string
+	VersionCode int64
+	VersionName string
+	MinSDK      int
+	TargetSDK   int
+	// GrantedPermissions are the granted install time and runtime permissions.
+	GrantedPermissions []string
+}
+
+// Uninstall removes the package from the device, keepData keeps the app's data and cache directories (-k).
+func (model Model) Uninstall(serial, packageName string, keepData bool) error {
+	args := []string{"-s", serial, "uninstall"}
+	if keepData {
+		args = append(args, "-k")
+	}
+	args = append(args, packageName)
+
+	cmd := model.adbCmd(args, nil)
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if strings.Contains(out, "not installed for") {
+		return fmt.Errorf("uninstall %s: %w", packageName, ErrPackageNotInstalled)
+	}
+	if strings.Contains(out, "DELETE_FAILED_INTERNAL_ERROR") {
+		// Failure [DELETE_FAILED_INTERNAL_ERROR] is reported for an unknown package by older versions,
+		// newer versions report it only for real failures.
+		if apiLevel, apiErr := model.apiLevel(serial); apiErr == nil && apiLevel < notInstalledForUserAPILevel {
+			return fmt.Errorf("uninstall %s: %w", packageName, ErrPackageNotInstalled)
+		}
+	}
+	if err != nil || !strings.Contains(out, "Success") {
+		return fmt.Errorf("uninstall %s: %s: %w", packageName, out, errOrUnexpectedOutput(err))
+	}
+	return nil
+}
+
+// ClearPackageData deletes all data of the package (`pm clear`).
+func (model Model) ClearPackageData(serial, packageName string) error {
+	out, err := model.shellOutput(serial, "pm", "clear", quoteShellArg(packageName))
+	if err != nil || lastLine(out) != "Success" {
+		return fmt.Errorf("clear package data of %s: %s: %w", packageName, out, errOrUnexpectedOutput(err))
+	}
+	return nil
+}
+
+// ListPackages lists the installed packages with their version code (`pm list packages --show-versioncode`).
+func (model Model) ListPackages(serial string, opts ListPackagesOptions) ([]Package, error) {
+	if opts.ThirdPartyOnly && opts.SystemOnly {
+		return nil, errors.New("list packages: third party only and system only packages can't be listed together")
+	}
+
+	args := []string{"pm", "list", "packages", "--show-versioncode"}
+	if opts.IncludePath {
+		args = append(args, "-f")
+	}
+	if opts.ThirdPartyOnly {
+		args = append(args, "-3")
+	}
+	if opts.SystemOnly {
+		args = append(args, "-s")
+	}
+	if opts.UserID != "" {
+		args = append(args, "--user", quoteShellArg(opts.UserID))
+	}
+	if opts.Filter != "" {
+		args = append(args, quoteShellArg(opts.Filter))
+	}
+
+	out, err := model.shellOutput(serial, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list packages: %s: %w", out, err)
+	}
+	return parsePackageList(out), nil
+}
+
+// GrantPermission grants a runtime permission to the package (`pm grant`).
+func (model Model) GrantPermission(serial, packageName, permission string) error {
+	return model.changePermission(serial, "grant", packageName, permission)
+}
+
+// RevokePermission revokes a runtime permission from the package (`pm revoke`).
+func (model Model) RevokePermission(serial, packageName, permission string) error {
+	return model.changePermission(serial, "revoke", packageName, permission)
+}
+
+func (model Model) changePermission(serial, action, packageName, permission string) error {
+	out, err := model.shellOutput(serial, "pm", action, quoteShellArg(packageName), quoteShellArg(permission))
+	// pm prints nothing on success, older versions exit with 0 on failure.
+	if err != nil || out != "" {
+		return fmt.Errorf("%s %s to %s: %s: %w", action, permission, packageName, out, errOrUnexpectedOutput(err))
+	}
+	return nil
+}
+
+// PackageInfo returns the version and the granted permissions of the package (`dumpsys package <package>`).
+func (model Model) PackageInfo(serial, packageName string) (PackageInfo, error) {
+	out, err := model.shellOutput(serial, "dumpsys", "package", quoteShellArg(packageName))
+	if err != nil {
+		return PackageInfo{}, fmt.Errorf("dumpsys package %s: %s: %w", packageName, out, err)
+	}
+
+	info, ok := parsePackageInfo(out, packageName)
+	if !ok {
+		return PackageInfo{}, fmt.Errorf("dumpsys package %s: %w", packageName, ErrPackageNotInstalled)
+	}
+	return info, nil
+}
+
+func (model Model) shellOutput(serial string, args ...string) (string, error) {
+	cmd := model.adbCmd(append([]string{"-s", serial, "shell"}, args...), nil)
+	return cmd.RunAndReturnTrimmedCombinedOutput()
+}
+
+// apiLevel returns the API level of the device (ro.build.version.sdk).
+func (model Model) apiLevel(serial string) (int, error) {
+	out, err := model.shellOutput(serial, "getprop", "ro.build.version.sdk")
+	if err != nil {
+		return 0, fmt.Errorf("getprop ro.build.version.sdk: %s: %w", out, err)
+	}
+	apiLevel, err := strconv.Atoi(out)
+	if err != nil {
+		return 0, fmt.Errorf("invalid API level: %s", out)
+	}
+	return apiLevel, nil
+}
+
+func errOrUnexpectedOutput(err error) error {
+	if err != nil {
+		return err
+	}
+	return errors.New("unexpected output")
+}
+
+// package:/data/app/~~abc==/com.example-xyz==/base.apk=com.example versionCode:42
+func parsePackageList(out string) []Package {
+	var packages []Package
+	for _, line := range strings.Split(out, "\n") {
+		line, ok := strings.CutPrefix(strings.TrimSpace(line), "package:")
+		if !ok {
+			continue
+		}
+
+		var pkg Package
+		if i := strings.LastIndex(line, " versionCode:"); i >= 0 {
+			pkg.VersionCode, _ = strconv.ParseInt(line[i+len(" versionCode:"):], 10, 64)
+			line = line[:i]
+		}
+		// the path itself can contain `=`, the package name can't
+		if i := strings.LastIndex(line, "="); i >= 0 {
+			pkg.Path = line[:i]
+			line = line[i+1:]
+		}
+		pkg.Name = line
+
+		packages = append(packages, pkg)
+	}
+	return packages
+}
+
+// parsePackageInfo parses the `Package [<package>] (<hash>):` block of the dumpsys output, a hidden system package
+// (the preinstalled version of an updated system app) has its own block later in the output, it is ignored.
+func parsePackageInfo(out, packageName string) (PackageInfo, bool) {
+	header := "Package [" + packageName + "]"
+	info := PackageInfo{Name: packageName}
+
+	found := false
+	blockIndent := 0
+	permissionSection := false
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimSpace(line)
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+
+		if !found {
+			if strings.HasPrefix(trimmed, header) {
+				found = true
+				blockIndent = indent
+			}
+			continue
+		}
+		if trimmed == "" {
+			continue
+		}
+		if indent <= blockIndent {
+			break
+		}
+
+		switch {
+		case strings.HasPrefix(trimmed, "versionCode="):
+			// versionCode=42 minSdk=21 targetSdk=34
+			for _, field := range strings.Fields(trimmed) {
+				key, value, _ := strings.Cut(field, "=")
+				switch key {
+				case "versionCode":
+					info.VersionCode, _ = strconv.ParseInt(value, 10, 64)
+				case "minSdk":
+					info.MinSDK, _ = strconv.Atoi(value)
+				case "targetSdk":
+					info.TargetSDK, _ = strconv.Atoi(value)
+				}
+			}
+		case strings.HasPrefix(trimmed, "versionName="):
+			info.VersionName = strings.TrimPrefix(trimmed, "versionName=")
+		case trimmed == "install permissions:" || trimmed == "runtime permissions:":
+			permissionSection = true
+		case permissionSection && strings.Contains(trimmed, ": granted="):
+			// android.permission.CAMERA: granted=true, flags=[ USER_SET ]
+			permission, state, _ := strings.Cut(trimmed, ": granted=")
+			if strings.HasPrefix(state, "true") && !sliceutil.IsStringInSlice(permission, info.GrantedPermissions) {
+				info.GrantedPermissions = append(info.GrantedPermissions, permission)
+			}
+		default:
+			permissionSection = false
+		}
+	}
+
+	return info, found
+}
@@ -0,0 +1,263 @@
+package adbmanager
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GivenInstalledPackage_WhenUninstall_ThenSucceeds(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: "Success"}
+	})
+
+	// When
+	err := mockModelWithFactory(factory).Uninstall("emulator-5554", "com.example", true)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"-s", "emulator-5554", "uninstall", "-k", "com.example"}}, factory.Calls())
+}
+
+func Test_GivenUnknownPackage_WhenUninstall_ThenReturnsNotInstalled(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: "Failure [not installed for 0]", exitCode: 1}
+	})
+
+	// When
+	err := mockModelWithFactory(factory).Uninstall("emulator-5554", "com.example", false)
+
+	// Then
+	require.True(t, errors.Is(err, ErrPackageNotInstalled))
+}
+
+func Test_GivenInternalErrorOnOlderDevice_WhenUninstall_ThenReturnsNotInstalled(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		if args[2] == "shell" {
+			return fakeResult{stdout: "28"}
+		}
+		return fakeResult{stdout: "Failure [DELETE_FAILED_INTERNAL_ERROR]", exitCode: 1}
+	})
+
+	// When
+	err := mockModelWithFactory(factory).Uninstall("emulator-5554", "com.example", false)
+
+	// Then
+	require.True(t, errors.Is(err, ErrPackageNotInstalled))
+	require.Equal(t, []string{"-s", "emulator-5554", "shell", "getprop", "ro.build.version.sdk"}, factory.Calls()[1])
+}
+
+func Test_GivenInternalErrorOnNewerDevice_WhenUninstall_ThenFails(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		if args[2] == "shell" {
+			return fakeResult{stdout: "34"}
+		}
+		return fakeResult{stdout: "Failure [DELETE_FAILED_INTERNAL_ERROR]", exitCode: 1}
+	})
+
+	// When
+	err := mockModelWithFactory(factory).Uninstall("emulator-5554", "com.example", false)
+
+	// Then
+	require.Error(t, err)
+	require.False(t, errors.Is(err, ErrPackageNotInstalled))
+}
+
+func Test_GivenFailure_WhenClearPackageData_ThenFails(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: "Failed"}
+	})
+
+	// When
+	err := mockModelWithFactory(factory).ClearPackageData("emulator-5554", "com.example")
+
+	// Then
+	require.EqualError(t, err, "clear package data of com.example: Failed: unexpected output")
+	require.Equal(t, [][]string{{"-s", "emulator-5554", "shell", "pm", "clear", "com.example"}}, factory.Calls())
+}
+
+func Test_GivenUnsafePackageName_WhenClearPackageData_ThenQuotesIt(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: "Success"}
+	})
+
+	// When
+	err := mockModelWithFactory(factory).ClearPackageData("emulator-5554", "com.example; reboot")
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"-s", "emulator-5554", "shell", "pm", "clear", "'com.example; reboot'"}}, factory.Calls())
+}
+
+func Test_GivenOptions_WhenListPackages_ThenReturnsParsedPackages(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: "package:/data/app/~~Xy1==/com.example-Ab2==/base.apk=com.example versionCode:42\n" +
+			"package:/data/app/com.example.test-1/base.apk=com.example.test versionCode:1\n"}
+	})
+
+	// When
+	packages, err := mockModelWithFactory(factory).ListPackages("emulator-5554", ListPackagesOptions{ThirdPartyOnly: true, IncludePath: true, UserID: "0", Filter: "example"})
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []Package{
+		{Name: "com.example", VersionCode: 42, Path: "/data/app/~~Xy1==/com.example-Ab2==/base.apk"},
+		{Name: "com.example.test", VersionCode: 1, Path: "/data/app/com.example.test-1/base.apk"},
+	}, packages)
+	require.Equal(t, [][]string{{"-s", "emulator-5554", "shell", "pm", "list", "packages", "--show-versioncode", "-f", "-3", "--user", "0", "example"}}, factory.Calls())
+}
+
+func Test_GivenThirdPartyAndSystemOnly_WhenListPackages_ThenFails(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{}
+	})
+
+	// When
+	_, err := mockModelWithFactory(factory).ListPackages("emulator-5554", ListPackagesOptions{ThirdPartyOnly: true, SystemOnly: true})
+
+	// Then
+	require.Error(t, err)
+	require.Empty(t, factory.Calls())
+}
+
+func Test_GivenUnknownPermission_WhenGrantPermission_ThenFails(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: "Exception occurred while executing 'grant':\njava.lang.IllegalArgumentException: Unknown permission: android.permission.FOO", exitCode: 255}
+	})
+
+	// When
+	err := mockModelWithFactory(factory).GrantPermission("emulator-5554", "com.example", "android.permission.FOO")
+
+	// Then
+	require.Error(t, err)
+	require.Equal(t, [][]string{{"-s", "emulator-5554", "shell", "pm", "grant", "com.example", "android.permission.FOO"}}, factory.Calls())
+}
+
+func Test_GivenNoOutput_WhenRevokePermission_ThenSucceeds(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{}
+	})
+
+	// When
+	err := mockModelWithFactory(factory).RevokePermission("emulator-5554", "com.example", "android.permission.CAMERA")
+
+	// Then
+	require.NoError(t, err)
+}
+
+func Test_parsePackageList(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want []Package
+	}{
+		{
+			name: "without path",
+			out:  "package:com.android.settings versionCode:34\npackage:com.example versionCode:1200300",
+			want: []Package{{Name: "com.android.settings", VersionCode: 34}, {Name: "com.example", VersionCode: 1200300}},
+		},
+		{
+			name: "without version code",
+			out:  "package:com.example",
+			want: []Package{{Name: "com.example"}},
+		},
+		{
+			name: "no packages",
+			out:  "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, parsePackageList(tt.out))
+		})
+	}
+}
+
+func Test_parsePackageInfo(t *testing.T) {
+	tests := []struct {
+		name      string
+		out       string
+		want      PackageInfo
+		wantFound bool
+	}{
+		{
+			name: "updated app with runtime permissions",
+			out:  dumpsysPackageOutput,
+			want: PackageInfo{
+				Name:        "com.example",
+				VersionCode: 42,
+				VersionName: "1.4.2",
+				MinSDK:      21,
+				TargetSDK:   34,
+				GrantedPermissions: []string{
+					"android.permission.INTERNET",
+					"android.permission.CAMERA",
+				},
+			},
+			wantFound: true,
+		},
+		{
+			name: "not installed",
+			out:  "Activity Resolver Table:\n  Non-Data Actions:\n\nPermissions:\n",
+			want: PackageInfo{Name: "com.example"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := parsePackageInfo(tt.out, "com.example")
+			require.Equal(t, tt.wantFound, found)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+const dumpsysPackageOutput = `Activity Resolver Table:
+  Non-Data Actions:
+      android.intent.action.MAIN:
+        a1b2c3 com.example/.MainActivity filter d4e5f6
+
+Key Set Manager:
+  [com.example]
+      Signing KeySets: 42
+
+Packages:
+  Package [com.example] (7c1d2e3):
+    userId=10154
+    pkg=Package{4f5e6d7 com.example}
+    codePath=/data/app/~~Xy1==/com.example-Ab2==
+    versionCode=42 minSdk=21 targetSdk=34
+    versionName=1.4.2
+    flags=[ DEBUGGABLE HAS_CODE ALLOW_CLEAR_USER_DATA ALLOW_BACKUP ]
+    requested permissions:
+      android.permission.INTERNET
+      android.permission.CAMERA
+      android.permission.ACCESS_FINE_LOCATION
+    install permissions:
+      android.permission.INTERNET: granted=true
+    User 0: ceDataInode=131074 installed=true hidden=false suspended=false
+      gids=[3003]
+      runtime permissions:
+        android.permission.ACCESS_FINE_LOCATION: granted=false, flags=[ USER_SENSITIVE_WHEN_GRANTED|USER_SENSITIVE_WHEN_DENIED]
+        android.permission.CAMERA: granted=true, flags=[ USER_SET|USER_SENSITIVE_WHEN_GRANTED|USER_SENSITIVE_WHEN_DENIED]
+      enabledComponents:
+
+Hidden system packages:
+  Package [com.example] (1a2b3c4):
+    versionCode=1 minSdk=21 targetSdk=34
+    versionName=1.0.0
+    install permissions:
+      android.permission.READ_CONTACTS: granted=true
+
+Queries:
+`
@@ -0,0 +1,404 @@
+package adbmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPoolHealthCheckInterval = 30 * time.Second
+	defaultPoolMaxFailures         = 3
+)
+
+// ErrDeviceRemoved is the cause of a lease's context cancellation when its device is removed from the pool.
+var ErrDeviceRemoved = errors.New("device removed from the pool")
+
+// errLeaseReleased is the cause of a lease's context cancellation after Release.
+var errLeaseReleased = errors.New("lease released")
+
+// DeviceHealth is the state of a device in a DevicePool.
+type DeviceHealth string
+
+// Device health states ...
+const (
+	// DeviceHealthUnknown means the device has not been checked yet.
+	DeviceHealthUnknown   DeviceHealth = "unknown"
+	DeviceHealthHealthy   DeviceHealth = "healthy"
+	DeviceHealthUnhealthy DeviceHealth = "unhealthy"
+	// DeviceHealthRecycling means DevicePoolOptions.Recycle is running for the device.
+	DeviceHealthRecycling DeviceHealth = "recycling"
+	// DeviceHealthFailed means the device could not be recycled, it is not leased again until it is re-added.
+	DeviceHealthFailed DeviceHealth = "failed"
+)
+
+// DevicePoolOptions ...
+type DevicePoolOptions struct {
+	// HealthCheckInterval is the interval of the health checks run by DevicePool.Run, defaults to 30s.
+	HealthCheckInterval time.Duration
+	// MinFreeStorage is the minimum free space of /data in bytes, it is not checked if 0.
+	MinFreeStorage int64
+	// MaxFailures is the number of consecutive failures (failed health checks or leases released with an error)
+	// after which the device's lease is cancelled and the device is recycled, defaults to 3.
+	MaxFailures int
+	// Recycle is called by the health check to bring back a failing device, for example by restarting the emulator.
+	// The device is checked again if it succeeds, otherwise (or if Recycle is nil) the device is marked as failed.
+	Recycle func(ctx context.Context, serial string) error
+}
+
+// PooledDevice is the state of a device in a DevicePool.
+type PooledDevice struct {
+	Serial string
+	Health DeviceHealth
+	Leased bool
+	// Failures is the number of consecutive failures, LastErr is the last one.
+	Failures  int
+	LastErr   error
+	LastCheck time.Time
+}
+
+// DevicePool hands out healthy devices to parallel jobs, see Lease.
+type DevicePool struct {
+	model Model
+	opts  DevicePoolOptions
+
+	mu      sync.Mutex
+	serials []string
+	devices map[string]*poolDevice
+	// changed is closed (and replaced) whenever a device might have become available.
+	changed chan struct{}
+}
+
+type poolDevice struct {
+	PooledDevice
+	lease *DeviceLease
+}
+
+// DeviceLease is the exclusive use of a pooled device, until Release is called or the context of Lease is done.
+type DeviceLease struct {
+	Serial string
+
+	pool   *DevicePool
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	once   sync.Once
+}
+
+// NewDevicePool returns a pool of the given devices, they can be leased once a health check found them healthy.
+func (model Model) NewDevicePool(serials []string, opts DevicePoolOptions) *DevicePool {
+	if opts.HealthCheckInterval == 0 {
+		opts.HealthCheckInterval = defaultPoolHealthCheckInterval
+	}
+	if opts.MaxFailures == 0 {
+		opts.MaxFailures = defaultPoolMaxFailures
+	}
+
+	pool := &DevicePool{
+		model:   model,
+		opts:    opts,
+		devices: map[string]*poolDevice{},
+		changed: make(chan struct{}),
+	}
+	for _, serial := range serials {
+		pool.Add(serial)
+	}
+	return pool
+}
+
+// Add adds a device to the pool, a failed device is reset to be checked again.
+func (pool *DevicePool) Add(serial string) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	if device, ok := pool.devices[serial]; ok {
+		if device.Health == DeviceHealthFailed {
+			device.PooledDevice = PooledDevice{Serial: serial, Health: DeviceHealthUnknown}
+		}
+		return
+	}
+	pool.serials = append(pool.serials, serial)
+	pool.devices[serial] = &poolDevice{PooledDevice: PooledDevice{Serial: serial, Health: DeviceHealthUnknown}}
+}
+
+// Remove removes a device from the pool, its lease (if any) is cancelled with ErrDeviceRemoved.
+func (pool *DevicePool) Remove(serial string) {
+	pool.mu.Lock()
+	device, ok := pool.devices[serial]
+	if ok {
+		delete(pool.devices, serial)
+		for i, s := range pool.serials {
+			if s == serial {
+				pool.serials = append(pool.serials[:i], pool.serials[i+1:]...)
+				break
+			}
+		}
+	}
+	pool.mu.Unlock()
+
+	if ok && device.lease != nil {
+		device.lease.cancel(ErrDeviceRemoved)
+	}
+}
+
+// Devices returns the state of the pooled devices.
+func (pool *DevicePool) Devices() []PooledDevice {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	var devices []PooledDevice
+	for _, serial := range pool.serials {
+		devices = append(devices, pool.devices[serial].PooledDevice)
+	}
+	return devices
+}
+
+// Run checks the health of the devices every DevicePoolOptions.HealthCheckInterval, until ctx is done.
+func (pool *DevicePool) Run(ctx context.Context) {
+	ticker := time.NewTicker(pool.opts.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		pool.CheckHealth(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckHealth checks every device concurrently: the device has to be online, booted and (if set) have
+// DevicePoolOptions.MinFreeStorage free space. Devices which reached DevicePoolOptions.MaxFailures are recycled
+// instead, unless they are leased. The lease of a device which reached DevicePoolOptions.MaxFailures is cancelled.
+func (pool *DevicePool) CheckHealth(ctx context.Context) {
+	pool.mu.Lock()
+	var wg sync.WaitGroup
+	for _, serial := range pool.serials {
+		device := pool.devices[serial]
+		if device.Health == DeviceHealthFailed || device.Health == DeviceHealthRecycling {
+			continue
+		}
+
+		recycle := device.Failures >= pool.opts.MaxFailures && device.lease == nil
+		if recycle {
+			device.Health = DeviceHealthRecycling
+		}
+		wg.Add(1)
+		go func(serial string, recycle bool) {
+			defer wg.Done()
+			if recycle && !pool.recycle(ctx, serial) {
+				return
+			}
+			err := pool.checkDevice(ctx, serial)
+			if ctx.Err() != nil {
+				// the check was interrupted, it says nothing about the device
+				return
+			}
+			pool.recordCheck(serial, err)
+		}(serial, recycle)
+	}
+	pool.mu.Unlock()
+
+	wg.Wait()
+}
+
+// Lease waits for a healthy device and leases it to the caller. The lease's context is cancelled once ctx is done,
+// the lease is released or the device reached DevicePoolOptions.MaxFailures. The lease is released automatically
+// once ctx is done.
+func (pool *DevicePool) Lease(ctx context.Context) (*DeviceLease, error) {
+	for {
+		pool.mu.Lock()
+		for _, serial := range pool.serials {
+			device := pool.devices[serial]
+			if device.Health != DeviceHealthHealthy || device.lease != nil {
+				continue
+			}
+
+			leaseCtx, cancel := context.WithCancelCause(ctx)
+			lease := &DeviceLease{Serial: serial, pool: pool, ctx: leaseCtx, cancel: cancel}
+			device.lease = lease
+			device.Leased = true
+			pool.mu.Unlock()
+
+			context.AfterFunc(leaseCtx, func() { lease.Release(nil) })
+			return lease, nil
+		}
+		changed := pool.changed
+		pool.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("lease device: %w", ctx.Err())
+		case <-changed:
+		}
+	}
+}
+
+// Context returns the context of the lease, it is done once the device should not be used anymore,
+// context.Cause tells why.
+func (lease *DeviceLease) Context() context.Context {
+	return lease.ctx
+}
+
+// Release returns the device to the pool, a non-nil err reports a failure of the device (not of the job using it),
+// which counts towards DevicePoolOptions.MaxFailures and keeps the device from being leased until the next
+// successful health check. Only the first call has an effect.
+func (lease *DeviceLease) Release(err error) {
+	lease.once.Do(func() {
+		pool := lease.pool
+		pool.mu.Lock()
+		if device, ok := pool.devices[lease.Serial]; ok && device.lease == lease {
+			device.lease = nil
+			device.Leased = false
+			if err != nil {
+				pool.recordFailure(device, err)
+			}
+			pool.notify()
+		}
+		pool.mu.Unlock()
+
+		lease.cancel(errLeaseReleased)
+	})
+}
+
+func (pool *DevicePool) checkDevice(ctx context.Context, serial string) error {
+	if online, _ := pool.model.checkBootStage(ctx, serial, BootStageDevice); !online {
+		return errors.New("device is offline")
+	}
+	if booted, err := pool.model.checkBootStage(ctx, serial, BootStageBootCompleted); err != nil || !booted {
+		return fmt.Errorf("boot is not completed: %w", errOrUnexpectedOutput(err))
+	}
+
+	if pool.opts.MinFreeStorage > 0 {
+		out, err := pool.model.bootShell(ctx, serial, "df", "/data")
+		if err != nil {
+			return fmt.Errorf("df /data: %s: %w", out, err)
+		}
+		free, err := parseDFAvailable(out)
+		if err != nil {
+			return fmt.Errorf("df /data: %w", err)
+		}
+		if free < pool.opts.MinFreeStorage {
+			return fmt.Errorf("free storage (%d bytes) is below the minimum (%d bytes)", free, pool.opts.MinFreeStorage)
+		}
+	}
+	return nil
+}
+
+// recycle runs DevicePoolOptions.Recycle and returns true if the device can be checked again.
+func (pool *DevicePool) recycle(ctx context.Context, serial string) bool {
+	pool.model.logger.Warnf("Recycling device %s after %d failures", serial, pool.opts.MaxFailures)
+	err := errors.New("no recycle function is set")
+	if pool.opts.Recycle != nil {
+		err = pool.opts.Recycle(ctx, serial)
+	}
+
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	device, ok := pool.devices[serial]
+	if !ok {
+		return false
+	}
+	if err != nil && ctx.Err() != nil {
+		// the recycle was interrupted, it is retried by the next health check
+		device.Health = DeviceHealthUnhealthy
+		return false
+	}
+	if err != nil {
+		pool.model.logger.Warnf("Failed to recycle device %s: %s", serial, err)
+		device.Health = DeviceHealthFailed
+		device.LastErr = fmt.Errorf("recycle: %w", err)
+		return false
+	}
+	device.Health = DeviceHealthUnknown
+	device.Failures = 0
+	return true
+}
+
+func (pool *DevicePool) recordCheck(serial string, err error) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	device, ok := pool.devices[serial]
+	if !ok {
+		return
+	}
+	device.LastCheck = time.Now()
+	if err != nil {
+		pool.recordFailure(device, fmt.Errorf("health check: %w", err))
+		return
+	}
+
+	device.Health = DeviceHealthHealthy
+	device.Failures = 0
+	device.LastErr = nil
+	pool.notify()
+}
+
+func (pool *DevicePool) recordFailure(device *poolDevice, err error) {
+	device.Health = DeviceHealthUnhealthy
+	device.Failures++
+	device.LastErr = err
+	if device.lease != nil && device.Failures >= pool.opts.MaxFailures {
+		device.lease.cancel(fmt.Errorf("device %s is unhealthy: %w", device.Serial, err))
+	}
+}
+
+func (pool *DevicePool) notify() {
+	close(pool.changed)
+	pool.changed = make(chan struct{})
+}
+
+// parseDFAvailable returns the available space in bytes from the `df <path>` output:
+//
+// Filesystem      1K-blocks    Used Available Use% Mounted on (toybox, API 23+)
+// /dev/block/dm-5   6082144 2135224   3930536  36% /data
+//
+// Filesystem             Size   Used   Free   Blksize (toolbox)
+// /data                  1.9G   1.1G   815.2M 4096
+func parseDFAvailable(out string) (int64, error) {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) < 2 {
+		return 0, fmt.Errorf("unexpected output: %s", out)
+	}
+	header := strings.Fields(lines[0])
+	// long filesystem names wrap the values into the next line
+	values := strings.Fields(strings.Join(lines[1:], " "))
+
+	for i, column := range header {
+		if i >= len(values) {
+			break
+		}
+		switch column {
+		case "Available":
+			kilobytes, err := strconv.ParseInt(values[i], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid available space: %s", values[i])
+			}
+			return kilobytes * 1024, nil
+		case "Free":
+			return parseHumanReadableSize(values[i])
+		}
+	}
+	return 0, fmt.Errorf("unexpected output: %s", out)
+}
+
+// parseHumanReadableSize parses sizes like 815.2M.
+func parseHumanReadableSize(size string) (int64, error) {
+	multiplier := float64(1)
+	if i := strings.IndexAny(size, "KMGT"); i >= 0 {
+		multiplier = float64(int64(1) << (10 * (strings.IndexByte("KMGT", size[i]) + 1)))
+		size = size[:i]
+	}
+
+	value, err := strconv.ParseFloat(size, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size: %s", size)
+	}
+	return int64(value * multiplier), nil
+}
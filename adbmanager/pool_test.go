@@ -0,0 +1,286 @@
+package adbmanager
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakePoolDevices answers the health check commands of the pool, devices are online and booted unless set otherwise.
+type fakePoolDevices struct {
+	mu      sync.Mutex
+	offline map[string]bool
+	df      string
+}
+
+func (devices *fakePoolDevices) setOffline(serial string, offline bool) {
+	devices.mu.Lock()
+	defer devices.mu.Unlock()
+	devices.offline[serial] = offline
+}
+
+func (devices *fakePoolDevices) handle(args []string) fakeResult {
+	devices.mu.Lock()
+	defer devices.mu.Unlock()
+
+	if devices.offline[args[1]] {
+		return fakeResult{stderr: "error: device offline", exitCode: 1}
+	}
+	switch strings.Join(args[2:], " ") {
+	case "get-state":
+		return fakeResult{stdout: "device"}
+	case "shell getprop sys.boot_completed":
+		return fakeResult{stdout: "1"}
+	case "shell df /data":
+		return fakeResult{stdout: devices.df}
+	}
+	return fakeResult{}
+}
+
+func newFakePool(opts DevicePoolOptions, serials ...string) (*DevicePool, *fakePoolDevices) {
+	devices := &fakePoolDevices{offline: map[string]bool{}}
+	factory := newFakeCommandFactory(devices.handle)
+	return mockModelWithFactory(factory).NewDevicePool(serials, opts), devices
+}
+
+func Test_GivenHealthyDevices_WhenLease_ThenEachDeviceIsLeasedOnce(t *testing.T) {
+	// Given
+	pool, _ := newFakePool(DevicePoolOptions{}, "emulator-5554", "emulator-5556")
+	pool.CheckHealth(context.Background())
+
+	// When
+	first, err := pool.Lease(context.Background())
+	require.NoError(t, err)
+	second, err := pool.Lease(context.Background())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, thirdErr := pool.Lease(ctx)
+
+	// Then
+	require.Equal(t, "emulator-5554", first.Serial)
+	require.Equal(t, "emulator-5556", second.Serial)
+	require.ErrorIs(t, thirdErr, context.DeadlineExceeded)
+
+	// When released, the device can be leased again
+	leased := make(chan *DeviceLease)
+	go func() {
+		lease, _ := pool.Lease(context.Background())
+		leased <- lease
+	}()
+	first.Release(nil)
+
+	require.Equal(t, "emulator-5554", (<-leased).Serial)
+	require.ErrorIs(t, context.Cause(first.Context()), errLeaseReleased)
+}
+
+func Test_GivenLeaseContextDone_WhenLeasing_ThenDeviceIsReleased(t *testing.T) {
+	// Given
+	pool, _ := newFakePool(DevicePoolOptions{}, "emulator-5554")
+	pool.CheckHealth(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := pool.Lease(ctx)
+	require.NoError(t, err)
+
+	// When
+	cancel()
+	lease, err := pool.Lease(context.Background())
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, "emulator-5554", lease.Serial)
+}
+
+func Test_GivenLeasedDeviceGoesOffline_WhenCheckHealth_ThenLeaseIsCancelledAtMaxFailures(t *testing.T) {
+	// Given
+	pool, devices := newFakePool(DevicePoolOptions{MaxFailures: 2}, "emulator-5554")
+	pool.CheckHealth(context.Background())
+	lease, err := pool.Lease(context.Background())
+	require.NoError(t, err)
+
+	// When
+	devices.setOffline("emulator-5554", true)
+	pool.CheckHealth(context.Background())
+
+	// Then a single failure keeps the lease
+	require.NoError(t, lease.Context().Err())
+	require.True(t, pool.Devices()[0].Leased)
+
+	// When
+	pool.CheckHealth(context.Background())
+
+	// Then
+	<-lease.Context().Done()
+	require.EqualError(t, context.Cause(lease.Context()), "device emulator-5554 is unhealthy: health check: device is offline")
+	require.Eventually(t, func() bool { return !pool.Devices()[0].Leased }, time.Second, time.Millisecond)
+	device := pool.Devices()[0]
+	require.Equal(t, DeviceHealthUnhealthy, device.Health)
+	require.Equal(t, 2, device.Failures)
+}
+
+func Test_GivenRepeatedFailures_WhenCheckHealth_ThenDeviceIsRecycled(t *testing.T) {
+	// Given
+	var recycled []string
+	var pool *DevicePool
+	var devices *fakePoolDevices
+	pool, devices = newFakePool(DevicePoolOptions{
+		MaxFailures: 2,
+		Recycle: func(ctx context.Context, serial string) error {
+			recycled = append(recycled, serial)
+			devices.setOffline(serial, false)
+			return nil
+		},
+	}, "emulator-5554")
+	devices.setOffline("emulator-5554", true)
+
+	// When
+	pool.CheckHealth(context.Background())
+	pool.CheckHealth(context.Background())
+	require.Equal(t, DeviceHealthUnhealthy, pool.Devices()[0].Health)
+	require.Equal(t, 2, pool.Devices()[0].Failures)
+	pool.CheckHealth(context.Background())
+
+	// Then
+	require.Equal(t, []string{"emulator-5554"}, recycled)
+	require.Equal(t, DeviceHealthHealthy, pool.Devices()[0].Health)
+	require.Equal(t, 0, pool.Devices()[0].Failures)
+}
+
+func Test_GivenRecycleFails_WhenCheckHealth_ThenDeviceIsFailedUntilReadded(t *testing.T) {
+	// Given
+	pool, devices := newFakePool(DevicePoolOptions{
+		MaxFailures: 1,
+		Recycle: func(ctx context.Context, serial string) error {
+			return errors.New("emulator did not start")
+		},
+	}, "emulator-5554")
+	pool.CheckHealth(context.Background())
+	lease, err := pool.Lease(context.Background())
+	require.NoError(t, err)
+	lease.Release(errors.New("install timed out"))
+
+	// When
+	pool.CheckHealth(context.Background())
+
+	// Then
+	device := pool.Devices()[0]
+	require.Equal(t, DeviceHealthFailed, device.Health)
+	require.EqualError(t, device.LastErr, "recycle: emulator did not start")
+
+	// When re-added, the device is checked again
+	devices.setOffline("emulator-5554", false)
+	pool.Add("emulator-5554")
+	pool.CheckHealth(context.Background())
+	require.Equal(t, DeviceHealthHealthy, pool.Devices()[0].Health)
+}
+
+func Test_GivenCheckHealthCancelled_WhenRecycling_ThenDeviceIsRecycledAgain(t *testing.T) {
+	// Given
+	var recycles atomic.Int32
+	pool, devices := newFakePool(DevicePoolOptions{
+		MaxFailures: 1,
+		Recycle: func(ctx context.Context, serial string) error {
+			if recycles.Add(1) == 1 {
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			return nil
+		},
+	}, "emulator-5554")
+	devices.setOffline("emulator-5554", true)
+	pool.CheckHealth(context.Background())
+	devices.setOffline("emulator-5554", false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// When
+	pool.CheckHealth(ctx)
+
+	// Then
+	require.Equal(t, DeviceHealthUnhealthy, pool.Devices()[0].Health)
+
+	// When checked again, the device is recycled
+	pool.CheckHealth(context.Background())
+	require.Equal(t, int32(2), recycles.Load())
+	require.Equal(t, DeviceHealthHealthy, pool.Devices()[0].Health)
+}
+
+func Test_GivenLowStorage_WhenCheckHealth_ThenDeviceIsUnhealthy(t *testing.T) {
+	// Given
+	pool, devices := newFakePool(DevicePoolOptions{MinFreeStorage: 1 << 30}, "emulator-5554")
+	devices.df = `Filesystem      1K-blocks    Used Available Use% Mounted on
+/dev/block/dm-5   6082144 5559904    522240  92% /data`
+
+	// When
+	pool.CheckHealth(context.Background())
+
+	// Then
+	device := pool.Devices()[0]
+	require.Equal(t, DeviceHealthUnhealthy, device.Health)
+	require.EqualError(t, device.LastErr, "health check: free storage (534773760 bytes) is below the minimum (1073741824 bytes)")
+}
+
+func Test_GivenRemovedLeasedDevice_WhenRemove_ThenLeaseIsCancelled(t *testing.T) {
+	// Given
+	pool, _ := newFakePool(DevicePoolOptions{}, "emulator-5554")
+	pool.CheckHealth(context.Background())
+	lease, err := pool.Lease(context.Background())
+	require.NoError(t, err)
+
+	// When
+	pool.Remove("emulator-5554")
+
+	// Then
+	require.ErrorIs(t, context.Cause(lease.Context()), ErrDeviceRemoved)
+	require.Empty(t, pool.Devices())
+}
+
+func Test_parseDFAvailable(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		want    int64
+		wantErr bool
+	}{
+		{
+			name: "toybox",
+			out: `Filesystem      1K-blocks    Used Available Use% Mounted on
+/dev/block/dm-5   6082144 2135224   3930536  36% /data`,
+			want: 3930536 * 1024,
+		},
+		{
+			name: "wrapped filesystem name",
+			out: `Filesystem           1K-blocks      Used Available Use% Mounted on
+/dev/block/bootdevice/by-name/userdata
+                      6082144   2135224   3930536  36% /data`,
+			want: 3930536 * 1024,
+		},
+		{
+			name: "toolbox",
+			out: `Filesystem               Size     Used     Free   Blksize
+/data                    1.9G     1.1G   815.5M   4096`,
+			want: 815.5 * (1 << 20),
+		},
+		{
+			name:    "error",
+			out:     "df: /data: Permission denied",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDFAvailable(tt.out)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("parseDFAvailable() = %v, %v, want %v, wantErr %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
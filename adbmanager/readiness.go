@@ -0,0 +1,181 @@
+package adbmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	defaultKeyguardTimeout = 5 * time.Second
+	keyguardPollInterval   = 500 * time.Millisecond
+)
+
+// ReadinessStep names a step of PrepareDevice.
+type ReadinessStep string
+
+// Readiness steps ...
+const (
+	ReadinessStepWakeScreen        ReadinessStep = "wake screen"
+	ReadinessStepDismissKeyguard   ReadinessStep = "dismiss keyguard"
+	ReadinessStepDisableAnimations ReadinessStep = "disable animations"
+	ReadinessStepStayAwake         ReadinessStep = "stay awake"
+	ReadinessStepVerifyKeyguard    ReadinessStep = "verify keyguard"
+)
+
+// animationScaleSettings are the global settings disabled by ReadinessStepDisableAnimations.
+var animationScaleSettings = []string{"window_animation_scale", "transition_animation_scale", "animator_duration_scale"}
+
+// ReadinessOptions selects the steps of PrepareDevice.
+type ReadinessOptions struct {
+	WakeScreen        bool
+	DismissKeyguard   bool
+	DisableAnimations bool
+	StayAwake         bool
+	// VerifyKeyguard waits until `dumpsys window` reports that the keyguard is not showing.
+	VerifyKeyguard bool
+	// KeyguardTimeout is the time to wait for the keyguard to go away, defaults to 5s.
+	KeyguardTimeout time.Duration
+}
+
+// DefaultReadinessOptions enables every step.
+var DefaultReadinessOptions = ReadinessOptions{
+	WakeScreen:        true,
+	DismissKeyguard:   true,
+	DisableAnimations: true,
+	StayAwake:         true,
+	VerifyKeyguard:    true,
+}
+
+// ReadinessStepResult ...
+type ReadinessStepResult struct {
+	Step ReadinessStep
+	Err  error
+}
+
+// ReadinessReport lists the steps run by PrepareDevice in order, with their errors.
+type ReadinessReport struct {
+	Steps []ReadinessStepResult
+}
+
+// Err returns the errors of the failed steps, or nil if every step succeeded.
+func (report ReadinessReport) Err() error {
+	var errs []error
+	for _, step := range report.Steps {
+		if step.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", step.Step, step.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PrepareDevice makes the device ready for UI tests: it runs every selected step, even if a previous one failed,
+// and reports the result of each.
+func (model Model) PrepareDevice(serial string, opts ReadinessOptions) ReadinessReport {
+	var report ReadinessReport
+	run := func(enabled bool, step ReadinessStep, fn func() error) {
+		if !enabled {
+			return
+		}
+		err := fn()
+		if err != nil {
+			model.logger.Warnf("Failed to %s: %s", step, err)
+		}
+		report.Steps = append(report.Steps, ReadinessStepResult{Step: step, Err: err})
+	}
+
+	run(opts.WakeScreen, ReadinessStepWakeScreen, func() error {
+		return model.silentShell(serial, "input", "keyevent", "KEYCODE_WAKEUP")
+	})
+	run(opts.DismissKeyguard, ReadinessStepDismissKeyguard, func() error {
+		return model.dismissKeyguard(serial)
+	})
+	run(opts.DisableAnimations, ReadinessStepDisableAnimations, func() error {
+		for _, setting := range animationScaleSettings {
+			if err := model.silentShell(serial, "settings", "put", "global", setting, "0"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	run(opts.StayAwake, ReadinessStepStayAwake, func() error {
+		return model.silentShell(serial, "svc", "power", "stayon", "true")
+	})
+	run(opts.VerifyKeyguard, ReadinessStepVerifyKeyguard, func() error {
+		timeout := opts.KeyguardTimeout
+		if timeout == 0 {
+			timeout = defaultKeyguardTimeout
+		}
+		return model.waitForKeyguardDismissed(serial, timeout)
+	})
+
+	return report
+}
+
+// UnlockDevice wakes the screen and dismisses the keyguard.
+func (model Model) UnlockDevice(serial string) error {
+	return model.PrepareDevice(serial, ReadinessOptions{WakeScreen: true, DismissKeyguard: true}).Err()
+}
+
+// IsKeyguardShowing returns true if the lock screen is showing, based on `dumpsys window`.
+func (model Model) IsKeyguardShowing(serial string) (bool, error) {
+	out, err := model.shellOutput(serial, "dumpsys", "window")
+	if err != nil {
+		return false, fmt.Errorf("dumpsys window: %s: %w", out, err)
+	}
+	return isKeyguardShowing(out), nil
+}
+
+func (model Model) dismissKeyguard(serial string) error {
+	err := model.silentShell(serial, "wm", "dismiss-keyguard")
+	if err == nil {
+		return nil
+	}
+
+	// `wm dismiss-keyguard` is only available from API 26, the menu key dismisses a keyguard without security on older images
+	model.logger.Debugf("wm dismiss-keyguard failed (%s), falling back to the menu key", err)
+	return model.silentShell(serial, "input", "keyevent", "KEYCODE_MENU")
+}
+
+func (model Model) waitForKeyguardDismissed(serial string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for {
+		showing, err := model.IsKeyguardShowing(serial)
+		if err == nil && !showing {
+			return nil
+		}
+		if err == nil {
+			err = errors.New("keyguard is still showing")
+		}
+
+		if sleepErr := sleepWithContext(ctx, min(keyguardPollInterval, timeout)); sleepErr != nil {
+			return err
+		}
+	}
+}
+
+// silentShell runs a shell command, which prints nothing on success: the device shell of older images
+// doesn't return the exit code of the command, so the output is treated as an error.
+func (model Model) silentShell(serial string, args ...string) error {
+	out, err := model.shellOutput(serial, args...)
+	if err != nil || out != "" {
+		return fmt.Errorf("%s: %s: %w", strings.Join(args, " "), out, errOrUnexpectedOutput(err))
+	}
+	return nil
+}
+
+// isKeyguardShowing looks for the keyguard state of the window manager, the field name depends on the API level:
+// mShowingLockscreen=true (API < 26), isKeyguardShowing=true, mKeyguardShowing=true (KeyguardController, API 26+).
+func isKeyguardShowing(dumpsysWindowOut string) bool {
+	for _, field := range strings.Fields(dumpsysWindowOut) {
+		switch field {
+		case "mShowingLockscreen=true", "isKeyguardShowing=true", "mKeyguardShowing=true", "mDreamingLockscreen=true":
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package adbmanager
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GivenAllSteps_WhenPrepareDevice_ThenRunsEveryStep(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		if strings.Join(args[3:], " ") == "dumpsys window" {
+			return fakeResult{stdout: "KeyguardController:\n  mKeyguardShowing=false mAodShowing=false"}
+		}
+		return fakeResult{}
+	})
+
+	// When
+	report := mockModelWithFactory(factory).PrepareDevice("emulator-5554", DefaultReadinessOptions)
+
+	// Then
+	require.NoError(t, report.Err())
+	require.Equal(t, []ReadinessStepResult{
+		{Step: ReadinessStepWakeScreen},
+		{Step: ReadinessStepDismissKeyguard},
+		{Step: ReadinessStepDisableAnimations},
+		{Step: ReadinessStepStayAwake},
+		{Step: ReadinessStepVerifyKeyguard},
+	}, report.Steps)
+
+	var shellCommands []string
+	for _, call := range factory.Calls() {
+		require.Equal(t, []string{"-s", "emulator-5554", "shell"}, call[:3])
+		shellCommands = append(shellCommands, strings.Join(call[3:], " "))
+	}
+	require.Equal(t, []string{
+		"input keyevent KEYCODE_WAKEUP",
+		"wm dismiss-keyguard",
+		"settings put global window_animation_scale 0",
+		"settings put global transition_animation_scale 0",
+		"settings put global animator_duration_scale 0",
+		"svc power stayon true",
+		"dumpsys window",
+	}, shellCommands)
+}
+
+func Test_GivenLegacyImage_WhenUnlockDevice_ThenFallsBackToMenuKey(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		if args[3] == "wm" {
+			return fakeResult{stdout: "Unknown command: dismiss-keyguard"}
+		}
+		return fakeResult{}
+	})
+
+	// When
+	err := mockModelWithFactory(factory).UnlockDevice("emulator-5554")
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []string{"-s", "emulator-5554", "shell", "input", "keyevent", "KEYCODE_MENU"}, factory.Calls()[2])
+}
+
+func Test_GivenKeyguardStaysShowing_WhenPrepareDevice_ThenReportsFailedStep(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		if args[3] == "dumpsys" {
+			return fakeResult{stdout: "  mShowingLockscreen=true mShowingDream=false mDreamingLockscreen=false"}
+		}
+		return fakeResult{}
+	})
+
+	// When
+	report := mockModelWithFactory(factory).PrepareDevice("emulator-5554", ReadinessOptions{
+		StayAwake:       true,
+		VerifyKeyguard:  true,
+		KeyguardTimeout: 10 * time.Millisecond,
+	})
+
+	// Then
+	require.Len(t, report.Steps, 2)
+	require.NoError(t, report.Steps[0].Err)
+	require.EqualError(t, report.Steps[1].Err, "keyguard is still showing")
+	require.EqualError(t, report.Err(), "verify keyguard: keyguard is still showing")
+}
+
+func Test_isKeyguardShowing(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want bool
+	}{
+		{name: "API 23", out: "    mShowingLockscreen=true mShowingDream=false mDreamingLockscreen=true", want: true},
+		{name: "API 34 showing", out: "  KeyguardController:\n    mKeyguardShowing=true\n    mAodShowing=false", want: true},
+		{name: "API 34 dismissed", out: "  KeyguardController:\n    mKeyguardShowing=false\n    mAodShowing=false", want: false},
+		{name: "isKeyguardShowing", out: "  isKeyguardShowing=false", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, isKeyguardShowing(tt.out))
+		})
+	}
+}
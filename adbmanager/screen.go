@@ -0,0 +1,278 @@
+package adbmanager
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+// MaxScreenRecordTimeLimit is the longest recording screenrecord supports, longer recordings are split into segments.
+const MaxScreenRecordTimeLimit = 3 * time.Minute
+
+const screenPollInterval = 100 * time.Millisecond
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// ScreenshotCmd builds and returns a `Command` printing a PNG screenshot of the device to its stdout,
+// without creating a temporary file on the device.
+func (model Model) ScreenshotCmd(serial string, commandOptions *command.Opts) command.Command {
+	return model.adbCmd([]string{"-s", serial, "exec-out", "screencap", "-p"}, commandOptions)
+}
+
+// Screenshot saves a PNG screenshot of the device to localPath.
+func (model Model) Screenshot(serial, localPath string) error {
+	file, err := os.Create(localPath)
+	if err != nil {
+		return fmt.Errorf("create screenshot file: %w", err)
+	}
+
+	var stderr bytes.Buffer
+	runErr := model.ScreenshotCmd(serial, &command.Opts{Stdout: file, Stderr: &stderr}).Run()
+	if err := file.Close(); err != nil && runErr == nil {
+		runErr = err
+	}
+
+	if runErr != nil {
+		err = fmt.Errorf("take screenshot: %s: %w", strings.TrimSpace(stderr.String()), runErr)
+	} else if header, readErr := readFileHeader(localPath, 256); readErr != nil {
+		err = fmt.Errorf("take screenshot: %w", readErr)
+	} else if !bytes.HasPrefix(header, pngSignature) {
+		// screencap reports some of its errors on stdout, with a successful exit code
+		err = fmt.Errorf("take screenshot: output is not a PNG image: %s", strings.TrimSpace(stderr.String()+string(header)))
+	}
+	if err != nil {
+		_ = os.Remove(localPath)
+		return err
+	}
+	return nil
+}
+
+func readFileHeader(pth string, size int) ([]byte, error) {
+	file, err := os.Open(pth)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header := make([]byte, size)
+	n, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return header[:n], nil
+}
+
+// ScreenRecordOptions ...
+type ScreenRecordOptions struct {
+	// BitRate is in bits per second, screenrecord's default (20 Mbps) is used if 0.
+	BitRate int
+	// Width and Height set the video size, the native display resolution is used if 0.
+	Width  int
+	Height int
+	// TimeLimit is the length of a segment, it defaults to (and can't exceed) MaxScreenRecordTimeLimit.
+	TimeLimit time.Duration
+	// RemoteDir is the device directory of the segments during the recording, defaults to /sdcard.
+	RemoteDir string
+}
+
+func (opts ScreenRecordOptions) args(remotePath string) ([]string, error) {
+	var args []string
+	if opts.BitRate < 0 {
+		return nil, fmt.Errorf("invalid bit rate: %d", opts.BitRate)
+	}
+	if opts.BitRate > 0 {
+		args = append(args, "--bit-rate", strconv.Itoa(opts.BitRate))
+	}
+
+	switch {
+	case opts.Width < 0 || opts.Height < 0 || (opts.Width == 0) != (opts.Height == 0):
+		return nil, fmt.Errorf("invalid video size: %dx%d", opts.Width, opts.Height)
+	case opts.Width > 0:
+		args = append(args, "--size", fmt.Sprintf("%dx%d", opts.Width, opts.Height))
+	}
+
+	timeLimit := opts.TimeLimit
+	if timeLimit == 0 {
+		timeLimit = MaxScreenRecordTimeLimit
+	}
+	if timeLimit < time.Second || timeLimit > MaxScreenRecordTimeLimit {
+		return nil, fmt.Errorf("invalid time limit: %s, should be between 1s and %s", opts.TimeLimit, MaxScreenRecordTimeLimit)
+	}
+	args = append(args, "--time-limit", strconv.Itoa(int(timeLimit/time.Second)))
+
+	return append(args, remotePath), nil
+}
+
+// ScreenRecording is a running screenrecord session, see StartScreenRecording.
+type ScreenRecording struct {
+	model  Model
+	serial string
+	opts   ScreenRecordOptions
+	done   chan struct{}
+
+	mu       sync.Mutex
+	segments []string
+	pid      string
+	stopped  bool
+	err      error
+}
+
+// StartScreenRecording starts recording the screen of the device. Once a segment reaches the time limit,
+// the next segment is started automatically, until Stop is called.
+func (model Model) StartScreenRecording(serial string, opts ScreenRecordOptions) (*ScreenRecording, error) {
+	if opts.RemoteDir == "" {
+		opts.RemoteDir = "/sdcard"
+	}
+	// fail early on invalid options
+	if _, err := opts.args(""); err != nil {
+		return nil, fmt.Errorf("invalid screen record options: %w", err)
+	}
+
+	recording := &ScreenRecording{
+		model:  model,
+		serial: serial,
+		opts:   opts,
+		done:   make(chan struct{}),
+	}
+	go recording.record(time.Now().Unix())
+
+	return recording, nil
+}
+
+func (recording *ScreenRecording) record(id int64) {
+	defer close(recording.done)
+
+	for i := 0; ; i++ {
+		remotePath := path.Join(recording.opts.RemoteDir, fmt.Sprintf("screenrecord-%d-%03d.mp4", id, i))
+		args, _ := recording.opts.args(remotePath)
+		output := &screenRecordOutput{recording: recording}
+		cmd := recording.model.adbCmd(interruptibleShellArgs(recording.serial, append([]string{"screenrecord"}, args...)...), &command.Opts{Stdout: output, Stderr: output})
+
+		recording.mu.Lock()
+		if recording.stopped {
+			recording.mu.Unlock()
+			return
+		}
+		recording.segments = append(recording.segments, remotePath)
+		recording.pid = ""
+		recording.mu.Unlock()
+
+		recording.model.logger.Printf("$ %s", cmd.PrintableCommandArgs())
+		err := cmd.Run()
+
+		recording.mu.Lock()
+		// the segment's process is gone, Stop must not interrupt its pid, which the device can reuse
+		recording.pid = ""
+		if err != nil && !recording.stopped {
+			recording.err = fmt.Errorf("screenrecord: %s: %w", strings.TrimSpace(output.out.String()), err)
+		}
+		finished := recording.stopped || recording.err != nil
+		recording.mu.Unlock()
+		if finished {
+			return
+		}
+	}
+}
+
+// Stop finishes the recording, pulls the segments into localDir and removes them from the device.
+// It returns the local paths of the segments in recording order.
+func (recording *ScreenRecording) Stop(ctx context.Context, localDir string) ([]string, error) {
+	recording.mu.Lock()
+	recording.stopped = true
+	recording.mu.Unlock()
+
+	if err := recording.interrupt(ctx); err != nil {
+		return nil, err
+	}
+	select {
+	case <-recording.done:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("wait for screenrecord to finish: %w", ctx.Err())
+	}
+
+	recording.mu.Lock()
+	segments := append([]string{}, recording.segments...)
+	recordErr := recording.err
+	recording.mu.Unlock()
+
+	if err := os.MkdirAll(localDir, 0o755); err != nil {
+		return nil, fmt.Errorf("create screen recording directory: %w", err)
+	}
+
+	var localPaths []string
+	var errs []error
+	for _, remotePath := range segments {
+		localPath := filepath.Join(localDir, path.Base(remotePath))
+		if out, err := recording.model.PullCmd(recording.serial, remotePath, localPath, nil).RunAndReturnTrimmedCombinedOutput(); err != nil {
+			errs = append(errs, fmt.Errorf("pull %s: %s: %w", remotePath, out, err))
+			continue
+		}
+		localPaths = append(localPaths, localPath)
+
+		rmCmd := recording.model.adbCmd([]string{"-s", recording.serial, "shell", "rm", "-f", remotePath}, nil)
+		if out, err := rmCmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
+			recording.model.logger.Warnf("Failed to remove %s from the device: %s: %s", remotePath, out, err)
+		}
+	}
+
+	return localPaths, errors.Join(append([]error{recordErr}, errs...)...)
+}
+
+// interrupt stops the running segment, it waits for the segment's pid if the segment has just been started.
+func (recording *ScreenRecording) interrupt(ctx context.Context) error {
+	for {
+		select {
+		case <-recording.done:
+			return nil
+		default:
+		}
+
+		recording.mu.Lock()
+		pid := recording.pid
+		recording.mu.Unlock()
+		if pid != "" {
+			// screenrecord finalizes the video on SIGINT
+			return recording.model.interruptDeviceProcess(ctx, recording.serial, pid)
+		}
+
+		if err := sleepWithContext(ctx, screenPollInterval); err != nil {
+			return fmt.Errorf("stop screenrecord: %w", err)
+		}
+	}
+}
+
+// screenRecordOutput captures the pid of a segment, the rest of the output is kept for error messages.
+type screenRecordOutput struct {
+	recording *ScreenRecording
+	out       bytes.Buffer
+	gotPID    bool
+}
+
+func (output *screenRecordOutput) Write(p []byte) (int, error) {
+	output.recording.mu.Lock()
+	defer output.recording.mu.Unlock()
+
+	output.out.Write(p)
+	if !output.gotPID {
+		if first, rest, ok := strings.Cut(output.out.String(), "\n"); ok {
+			output.gotPID = true
+			if pid, ok := parsePIDLine(first); ok {
+				output.recording.pid = pid
+				output.out.Reset()
+				output.out.WriteString(rest)
+			}
+		}
+	}
+	return len(p), nil
+}
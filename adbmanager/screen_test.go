@@ -0,0 +1,87 @@
+package adbmanager
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GivenPNGOutput_WhenScreenshot_ThenWritesFile(t *testing.T) {
+	// Given
+	localPath := filepath.Join(t.TempDir(), "screenshot.png")
+	png := string(pngSignature) + "IHDR..."
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: png}
+	})
+
+	// When
+	err := mockModelWithFactory(factory).Screenshot("emulator-5554", localPath)
+
+	// Then
+	require.NoError(t, err)
+	content, err := os.ReadFile(localPath)
+	require.NoError(t, err)
+	require.Equal(t, png, string(content))
+	require.Equal(t, [][]string{{"-s", "emulator-5554", "exec-out", "screencap", "-p"}}, factory.Calls())
+}
+
+func Test_GivenErrorOnStdout_WhenScreenshot_ThenFailsAndRemovesFile(t *testing.T) {
+	// Given
+	localPath := filepath.Join(t.TempDir(), "screenshot.png")
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: "Capturing failed."}
+	})
+
+	// When
+	err := mockModelWithFactory(factory).Screenshot("emulator-5554", localPath)
+
+	// Then
+	require.EqualError(t, err, "take screenshot: output is not a PNG image: Capturing failed.")
+	require.NoFileExists(t, localPath)
+}
+
+func Test_GivenInvalidSize_WhenStartScreenRecording_ThenFails(t *testing.T) {
+	// When
+	_, err := mockModel().StartScreenRecording("emulator-5554", ScreenRecordOptions{Width: 1280})
+
+	// Then
+	require.EqualError(t, err, "invalid screen record options: invalid video size: 1280x0")
+}
+
+func Test_GivenSegmentReachedTimeLimit_WhenStop_ThenPullsAllSegments(t *testing.T) {
+	// Given
+	localDir := filepath.Join(t.TempDir(), "videos")
+	factory := newInterruptibleCommandFactory("1111\n", "2222\n")
+	recording, err := mockModelWithFactory(factory).StartScreenRecording("emulator-5554", ScreenRecordOptions{
+		BitRate:   4000000,
+		Width:     720,
+		Height:    1280,
+		TimeLimit: 2 * time.Second,
+	})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		recording.mu.Lock()
+		defer recording.mu.Unlock()
+		return recording.pid == "2222"
+	}, time.Second, time.Millisecond)
+
+	// When
+	localPaths, err := recording.Stop(context.Background(), localDir)
+
+	// Then
+	require.NoError(t, err)
+	require.Len(t, localPaths, 2)
+	require.Equal(t, localDir, filepath.Dir(localPaths[0]))
+	require.Regexp(t, `screenrecord-\d+-000\.mp4$`, localPaths[0])
+	require.Regexp(t, `screenrecord-\d+-001\.mp4$`, localPaths[1])
+
+	calls := factory.Calls()
+	require.Equal(t, []string{"-s", "emulator-5554", "shell", "echo", "$$;", "exec", "screenrecord", "--bit-rate", "4000000", "--size", "720x1280", "--time-limit", "2"}, calls[0][:13])
+	require.Equal(t, []string{"-s", "emulator-5554", "shell", "kill", "-INT", "2222"}, calls[2])
+	require.Equal(t, "pull", calls[3][2])
+	require.Equal(t, []string{"-s", "emulator-5554", "shell", "rm", "-f", calls[3][3]}, calls[4])
+}
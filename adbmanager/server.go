@@ -0,0 +1,118 @@
+package adbmanager
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+// DefaultServerPort is the port of the adb server if neither `-P` nor ANDROID_ADB_SERVER_PORT is set.
+const DefaultServerPort = 5037
+
+// ADBVersion is the version information parsed from `adb version`.
+type ADBVersion struct {
+	// Protocol is the version of the adb protocol (for example 1.0.41), the server is restarted by the client
+	// if its protocol version (the last component) doesn't match.
+	Protocol string
+	// Revision is the platform-tools version (for example 35.0.1-11580240), empty for versions older than 29.0.0.
+	Revision string
+	// Path is the path of the adb binary.
+	Path string
+}
+
+// ServerVersion returns the last component of the protocol version, it is the version reported by the server
+// (`adb host:version`).
+func (version ADBVersion) ServerVersion() (int, error) {
+	i := strings.LastIndex(version.Protocol, ".")
+	return strconv.Atoi(version.Protocol[i+1:])
+}
+
+// WithServerPort returns a copy of the model, which runs every command against the adb server listening on the given port.
+// This isolates the devices of parallel jobs running on the same host. Port 0 selects adb's default, which is
+// ANDROID_ADB_SERVER_PORT if set, otherwise DefaultServerPort.
+func (model Model) WithServerPort(port int) Model {
+	model.serverPort = port
+	return model
+}
+
+// ServerPort returns the port selected by WithServerPort, 0 if adb's default server is used.
+func (model Model) ServerPort() int {
+	return model.serverPort
+}
+
+// StartServerCmd returns a command that starts the adb server on the selected port if it is not running.
+func (model Model) StartServerCmd(commandOptions *command.Opts) command.Command {
+	return model.adbCmd([]string{"start-server"}, commandOptions)
+}
+
+// StartServer starts a dedicated adb server on the selected port.
+func (model Model) StartServer() error {
+	cmd := model.StartServerCmd(nil)
+	model.logger.Printf("$ %s", cmd.PrintableCommandArgs())
+	if out, err := cmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("start adb server: %s: %w", out, err)
+	}
+	return nil
+}
+
+// StopServer kills the adb server on the selected port.
+func (model Model) StopServer() error {
+	cmd := model.KillServerCmd(nil)
+	model.logger.Printf("$ %s", cmd.PrintableCommandArgs())
+	if out, err := cmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("stop adb server: %s: %w", out, err)
+	}
+	return nil
+}
+
+// Version returns the version of the adb client (`adb version`).
+func (model Model) Version() (ADBVersion, error) {
+	cmd := model.adbCmd([]string{"version"}, nil)
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return ADBVersion{}, fmt.Errorf("adb version: %s: %w", out, err)
+	}
+
+	version, ok := parseADBVersion(out)
+	if !ok {
+		return ADBVersion{}, fmt.Errorf("adb version: unexpected output: %s", out)
+	}
+	return version, nil
+}
+
+// adbCmd creates an adb command against the selected server.
+func (model Model) adbCmd(args []string, commandOptions *command.Opts) command.Command {
+	return model.cmdFactory.Create(model.binPth, model.serverArgs(args), commandOptions)
+}
+
+// serverArgs prepends the server port option to the adb arguments if a port is selected.
+func (model Model) serverArgs(args []string) []string {
+	if model.serverPort != 0 {
+		return append([]string{"-P", strconv.Itoa(model.serverPort)}, args...)
+	}
+	return args
+}
+
+// Android Debug Bridge version 1.0.41
+var adbProtocolVersionPattern = regexp.MustCompile(`^Android Debug Bridge version (\d+(?:\.\d+)*)$`)
+
+// Android Debug Bridge version 1.0.41
+// Version 35.0.1-11580240
+// Installed as /opt/android-sdk/platform-tools/adb
+func parseADBVersion(out string) (ADBVersion, bool) {
+	var version ADBVersion
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if match := adbProtocolVersionPattern.FindStringSubmatch(line); match != nil {
+			version.Protocol = match[1]
+		} else if revision, ok := strings.CutPrefix(line, "Version "); ok {
+			version.Revision = revision
+		} else if pth, ok := strings.CutPrefix(line, "Installed as "); ok {
+			version.Path = pth
+		}
+	}
+	return version, version.Protocol != ""
+}
@@ -0,0 +1,88 @@
+package adbmanager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GivenServerPort_WhenRunningCommands_ThenEveryCommandUsesTheServer(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: "[ro.build.version.sdk]: [34]"}
+	})
+	model := mockModelWithFactory(factory).WithServerPort(5041)
+
+	// When
+	_, err := model.DeviceInfo("emulator-5554")
+	require.NoError(t, err)
+	require.NoError(t, model.StartServer())
+	require.NoError(t, model.StopServer())
+
+	// Then
+	require.Equal(t, 5041, model.ServerPort())
+	require.Equal(t, [][]string{
+		{"-P", "5041", "-s", "emulator-5554", "shell", "getprop"},
+		{"-P", "5041", "start-server"},
+		{"-P", "5041", "kill-server"},
+	}, factory.Calls())
+	require.Equal(t, `adb "-P" "5041" "devices"`, (*model.DevicesCmd()).PrintableCommandArgs())
+}
+
+func Test_GivenDefaultServer_WhenCreateStartServerCmd_ThenPortIsNotSet(t *testing.T) {
+	// When
+	cmd := mockModel().StartServerCmd(nil)
+
+	// Then
+	require.Equal(t, `adb "start-server"`, cmd.PrintableCommandArgs())
+}
+
+func Test_GivenAdbVersionOutput_WhenVersion_ThenReturnsParsedVersion(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: `Android Debug Bridge version 1.0.41
+Version 35.0.1-11580240
+Installed as /opt/android-sdk/platform-tools/adb
+Running on Linux 6.5.0-1025-azure (x86_64)
+`}
+	})
+
+	// When
+	version, err := mockModelWithFactory(factory).WithServerPort(5041).Version()
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"-P", "5041", "version"}}, factory.Calls())
+	require.Equal(t, ADBVersion{Protocol: "1.0.41", Revision: "35.0.1-11580240", Path: "/opt/android-sdk/platform-tools/adb"}, version)
+	serverVersion, err := version.ServerVersion()
+	require.NoError(t, err)
+	require.Equal(t, 41, serverVersion)
+}
+
+func Test_parseADBVersion(t *testing.T) {
+	tests := []struct {
+		name   string
+		out    string
+		want   ADBVersion
+		wantOK bool
+	}{
+		{
+			name:   "before platform-tools 29",
+			out:    "Android Debug Bridge version 1.0.39\nRevision 3db08f2c6889-android\n",
+			want:   ADBVersion{Protocol: "1.0.39"},
+			wantOK: true,
+		},
+		{
+			name:   "unexpected output",
+			out:    "adb: command not found",
+			wantOK: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseADBVersion(tt.out)
+			require.Equal(t, tt.wantOK, ok)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,381 @@
+package adbmanager
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-android/v2/testresult/instrumentation"
+	"github.com/bitrise-io/go-steputils/v2/testreport"
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+const (
+	defaultShardHealthCheckInterval = 10 * time.Second
+	defaultShardHealthCheckFailures = 3
+	shardHealthCheckTimeout         = 10 * time.Second
+	shardInterruptTimeout           = 10 * time.Second
+)
+
+// ShardedTestOptions configures RunShardedInstrumentedTests.
+type ShardedTestOptions struct {
+	PackageName     string
+	TestRunnerClass string
+	// Options are applied to every shard, NumShards, ShardIndex and RawOutput are set by the sharded run.
+	Options InstrumentationOptions
+	// HealthCheckInterval is the interval of checking whether the shard's device is still online, defaults to 10s.
+	HealthCheckInterval time.Duration
+	// HealthCheckFailures is the number of consecutive failed health checks after which the shard is errored,
+	// defaults to 3.
+	HealthCheckFailures int
+	// OnEvent is called for the test events of every shard, calls from different shards can happen concurrently.
+	OnEvent func(shard ShardInfo, event instrumentation.Event)
+	// Coverage enables the coverage collection, the coverage file of every completed shard is pulled (see PullCoverage).
+	Coverage *CoverageOptions
+}
+
+// ShardInfo identifies a shard of a sharded test run.
+type ShardInfo struct {
+	Index  int
+	Serial string
+}
+
+func (shard ShardInfo) String() string {
+	return fmt.Sprintf("shard %d (%s)", shard.Index, shard.Serial)
+}
+
+// ShardResult ...
+type ShardResult struct {
+	ShardInfo
+	// Output is the raw instrumentation output of the shard.
+	Output string
+	Result instrumentation.Result
+	// Err is set if the shard could not complete, for example because its device went offline.
+	Err error
+	// CoverageFile is the local path of the shard's coverage file, CoverageErr is set if it could not be collected.
+	CoverageFile string
+	CoverageErr  error
+}
+
+// ShardedTestResult ...
+type ShardedTestResult struct {
+	Shards []ShardResult
+}
+
+// Failed returns true if any of the shards errored or had a failing test.
+func (result ShardedTestResult) Failed() bool {
+	for _, shard := range result.Shards {
+		if shard.Err != nil || shard.Result.Failed() {
+			return true
+		}
+	}
+	return false
+}
+
+// TestReport merges the results of all shards into a single report. A shard that failed without an errored test
+// is reported as an errored test case of a suite named after the shard, just like a shard that errored (for example
+// because the device went offline).
+func (result ShardedTestResult) TestReport() testreport.TestReport {
+	var merged instrumentation.Result
+	for _, shard := range result.Shards {
+		merged.Tests = append(merged.Tests, shard.Result.Tests...)
+	}
+	report := merged.TestReport("")
+
+	for _, shard := range result.Shards {
+		failure := shard.Result.RunFailure
+		if shard.Result.HasErroredTest() {
+			// the run failure is already reported by the errored test
+			failure = ""
+		}
+		if shard.Err != nil {
+			failure = shard.Err.Error()
+		}
+		if failure == "" {
+			continue
+		}
+
+		shardReport := instrumentation.Result{RunFailure: failure}.TestReport(shard.String())
+		report.TestSuites = append(report.TestSuites, shardReport.TestSuites...)
+	}
+
+	return report
+}
+
+// RunShardedInstrumentedTestsCmd returns the `am instrument` command of a single shard, targeting the given device.
+func (model Model) RunShardedInstrumentedTestsCmd(shard ShardInfo, numShards int, opts ShardedTestOptions, commandOptions *command.Opts) (command.Command, error) {
+	shardOpts, err := shardInstrumentationOptions(shard, numShards, opts)
+	if err != nil {
+		return nil, err
+	}
+	return model.RunInstrumentationCmd(shard.Serial, opts.PackageName, opts.TestRunnerClass, shardOpts, commandOptions)
+}
+
+func shardInstrumentationOptions(shard ShardInfo, numShards int, opts ShardedTestOptions) (InstrumentationOptions, error) {
+	shardOpts := opts.Options
+	shardOpts.NumShards = numShards
+	shardOpts.ShardIndex = shard.Index
+	shardOpts.RawOutput = true
+	if opts.Coverage != nil {
+		if opts.Options.CoverageFile != "" {
+			return InstrumentationOptions{}, errors.New("coverage file is set by the coverage options of the sharded test run")
+		}
+		shardOpts.Coverage = true
+		shardOpts.CoverageFile = opts.Coverage.deviceFile()
+	}
+	return shardOpts, nil
+}
+
+// RunShardedInstrumentedTests splits the instrumented tests into one shard per device (using the runner's
+// numShards/shardIndex arguments) and runs the shards concurrently. It returns once every shard has finished,
+// errored, or ctx is done. A shard whose device goes offline is reported as errored instead of waiting for it.
+func (model Model) RunShardedInstrumentedTests(ctx context.Context, serials []string, opts ShardedTestOptions) (ShardedTestResult, error) {
+	if len(serials) == 0 {
+		return ShardedTestResult{}, errors.New("no devices provided for the sharded test run")
+	}
+	if opts.Options.NumShards != 0 || opts.Options.ShardIndex != 0 {
+		return ShardedTestResult{}, errors.New("number of shards and shard index are set by the sharded test run")
+	}
+	// fail early, instead of reporting the same error for every shard
+	if _, err := model.RunShardedInstrumentedTestsCmd(ShardInfo{Serial: serials[0]}, len(serials), opts, nil); err != nil {
+		return ShardedTestResult{}, err
+	}
+
+	results := make([]ShardResult, len(serials))
+	var wg sync.WaitGroup
+	for i, serial := range serials {
+		wg.Add(1)
+		go func(shard ShardInfo) {
+			defer wg.Done()
+			results[shard.Index] = model.runShard(ctx, shard, len(serials), opts)
+		}(ShardInfo{Index: i, Serial: serial})
+	}
+	wg.Wait()
+
+	return ShardedTestResult{Shards: results}, nil
+}
+
+func (model Model) runShard(ctx context.Context, shard ShardInfo, numShards int, opts ShardedTestOptions) ShardResult {
+	var onEvent func(instrumentation.Event)
+	if opts.OnEvent != nil {
+		onEvent = func(event instrumentation.Event) {
+			opts.OnEvent(shard, event)
+		}
+	}
+	output := newShardOutput(instrumentation.NewParser(onEvent))
+
+	args, err := model.shardArgs(shard, numShards, opts)
+	if err != nil {
+		return output.result(shard, err)
+	}
+
+	var coverageErr error
+	if opts.Coverage != nil {
+		if coverageErr = model.RemoveCoverageFile(shard.Serial, *opts.Coverage); coverageErr != nil {
+			coverageErr = fmt.Errorf("%s: %w", shard, coverageErr)
+			model.logger.Warnf("Coverage of %s won't be collected: %s", shard, coverageErr)
+		}
+	}
+
+	runCtx, cancelRun := context.WithCancel(ctx)
+	defer cancelRun()
+	cmd := model.adbCmdContext(runCtx, args, &command.Opts{Stdout: output, Stderr: output.stderr()})
+	model.logger.Printf("Running %s: $ %s", shard, cmd.PrintableCommandArgs())
+
+	doneChan := make(chan error, 1)
+	go func() {
+		doneChan <- cmd.Run()
+	}()
+
+	interval := opts.HealthCheckInterval
+	if interval == 0 {
+		interval = defaultShardHealthCheckInterval
+	}
+	maxFailures := opts.HealthCheckFailures
+	if maxFailures == 0 {
+		maxFailures = defaultShardHealthCheckFailures
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var shardErr error
+	failures := 0
+	for shardErr == nil {
+		select {
+		case err := <-doneChan:
+			if err != nil {
+				shardErr = fmt.Errorf("%s: %w", shard, err)
+			}
+			result := output.result(shard, shardErr)
+			if shardErr == nil && opts.Coverage != nil {
+				if coverageErr != nil {
+					result.CoverageErr = coverageErr
+				} else {
+					result.CoverageFile, result.CoverageErr = model.PullCoverage(shard, *opts.Coverage)
+				}
+			}
+			return result
+		case <-ctx.Done():
+			shardErr = fmt.Errorf("%s: %w", shard, ctx.Err())
+		case <-ticker.C:
+			state, err := model.checkShardDevice(ctx, shard.Serial)
+			if err == nil && state == DeviceStateDevice {
+				failures = 0
+				continue
+			}
+
+			failures++
+			if err != nil {
+				model.logger.Warnf("Health check %d/%d of %s failed: %s", failures, maxFailures, shard, err)
+			} else {
+				model.logger.Warnf("Health check %d/%d of %s failed (state: %s)", failures, maxFailures, shard, state)
+			}
+			if failures >= maxFailures {
+				shardErr = fmt.Errorf("%s: device went offline during the test run (state: %s)", shard, state)
+			}
+		}
+	}
+
+	// Stop the instrumentation on the device (if it is still reachable) and kill the local adb process,
+	// its further output is discarded.
+	if pid := output.pid(); pid != "" {
+		interruptCtx, cancelInterrupt := context.WithTimeout(context.Background(), shardInterruptTimeout)
+		if err := model.interruptDeviceProcess(interruptCtx, shard.Serial, pid); err != nil {
+			model.logger.Warnf("Failed to stop the instrumentation of %s: %s", shard, err)
+		}
+		cancelInterrupt()
+	}
+	cancelRun()
+	<-doneChan
+
+	return output.result(shard, shardErr)
+}
+
+// shardArgs returns the adb arguments of the shard's `am instrument` command, run so that its device process can be
+// interrupted (see interruptibleShellArgs).
+func (model Model) shardArgs(shard ShardInfo, numShards int, opts ShardedTestOptions) ([]string, error) {
+	shardOpts, err := shardInstrumentationOptions(shard, numShards, opts)
+	if err != nil {
+		return nil, err
+	}
+	shellArgs, err := instrumentShellArgs(opts.PackageName, opts.TestRunnerClass, shardOpts)
+	if err != nil {
+		return nil, err
+	}
+	return interruptibleQuotedShellArgs(shard.Serial, shellArgs...), nil
+}
+
+// checkShardDevice returns the state of the shard's device, a check that doesn't complete in time is failed.
+func (model Model) checkShardDevice(ctx context.Context, serial string) (DeviceState, error) {
+	checkCtx, cancel := context.WithTimeout(ctx, shardHealthCheckTimeout)
+	defer cancel()
+	return model.deviceState(checkCtx, serial)
+}
+
+func (model Model) deviceState(ctx context.Context, serial string) (DeviceState, error) {
+	cmd := model.adbCmdContext(ctx, deviceArgs(serial, "get-state"), nil)
+	out, err := runWithContext(ctx, cmd)
+	if err != nil {
+		return DeviceStateOffline, err
+	}
+	return parseDeviceState(lastLine(out)), nil
+}
+
+// shardOutput collects the output of a shard and feeds it to the instrumentation parser, the first line is the pid
+// of the device process (see interruptibleShellArgs).
+// Writes after the shard has been finished are discarded, so a stopped adb process can't race with the result.
+type shardOutput struct {
+	mu       sync.Mutex
+	parser   *instrumentation.Parser
+	buf      bytes.Buffer
+	finished bool
+
+	pidLine    bytes.Buffer
+	pidRead    bool
+	processPID string
+}
+
+func newShardOutput(parser *instrumentation.Parser) *shardOutput {
+	return &shardOutput{parser: parser}
+}
+
+func (output *shardOutput) Write(p []byte) (int, error) {
+	output.mu.Lock()
+	defer output.mu.Unlock()
+
+	if output.finished {
+		return len(p), nil
+	}
+
+	n := len(p)
+	if !output.pidRead {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			output.pidLine.Write(p)
+			return n, nil
+		}
+		output.pidLine.Write(p[:i+1])
+		p = p[i+1:]
+		output.pidRead = true
+
+		if pid, ok := parsePIDLine(output.pidLine.String()); ok {
+			output.processPID = pid
+		} else {
+			// not started by an interruptible shell command, the line is part of the output
+			p = append(output.pidLine.Bytes(), p...)
+		}
+	}
+
+	if _, err := io.MultiWriter(&output.buf, output.parser).Write(p); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (output *shardOutput) pid() string {
+	output.mu.Lock()
+	defer output.mu.Unlock()
+
+	return output.processPID
+}
+
+// stderr returns a writer which only collects the output, without parsing it.
+func (output *shardOutput) stderr() io.Writer {
+	return shardStderr{output: output}
+}
+
+type shardStderr struct {
+	output *shardOutput
+}
+
+func (stderr shardStderr) Write(p []byte) (int, error) {
+	stderr.output.mu.Lock()
+	defer stderr.output.mu.Unlock()
+
+	if stderr.output.finished {
+		return len(p), nil
+	}
+	return stderr.output.buf.Write(p)
+}
+
+func (output *shardOutput) result(shard ShardInfo, err error) ShardResult {
+	output.mu.Lock()
+	defer output.mu.Unlock()
+
+	output.finished = true
+	if !output.pidRead {
+		_, _ = io.MultiWriter(&output.buf, output.parser).Write(output.pidLine.Bytes())
+	}
+	result, _ := output.parser.Close()
+
+	return ShardResult{
+		ShardInfo: shard,
+		Output:    output.buf.String(),
+		Result:    result,
+		Err:       err,
+	}
+}
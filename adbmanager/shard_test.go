@@ -0,0 +1,208 @@
+package adbmanager
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-android/v2/testresult/instrumentation"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GivenShard_WhenRunShardedInstrumentedTestsCmd_ThenCreatesExpectedCommand(t *testing.T) {
+	// Given
+	opts := ShardedTestOptions{
+		PackageName:     "com.package.name.test",
+		TestRunnerClass: "androidx.test.runner.AndroidJUnitRunner",
+		Options: InstrumentationOptions{
+			Classes: []string{"com.package.name.LoginTest"},
+			Extras:  []InstrumentationExtra{{Key: "clearPackageData", Value: "true"}},
+		},
+	}
+
+	// When
+	testCommand, err := mockModel().RunShardedInstrumentedTestsCmd(ShardInfo{Index: 1, Serial: "emulator-5556"}, 2, opts, nil)
+
+	// Then
+	require.NoError(t, err)
+	actualArgs := testCommand.PrintableCommandArgs()
+	expectedArgs := `adb "-s" "emulator-5556" "shell" "am" "instrument" "-w" "-r" "-e" "class" "com.package.name.LoginTest" "-e" "numShards" "2" "-e" "shardIndex" "1" "-e" "clearPackageData" "true" "com.package.name.test/androidx.test.runner.AndroidJUnitRunner"`
+	require.Equal(t, expectedArgs, actualArgs)
+}
+
+func Test_GivenTwoDevices_WhenRunShardedInstrumentedTests_ThenMergesShardResults(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		switch strings.Join(args, " ") {
+		case "-s emulator-5554 get-state", "-s emulator-5556 get-state":
+			return fakeResult{stdout: "device"}
+		}
+		if args[1] == "emulator-5554" {
+			return fakeResult{stdout: "4321\n" + instrumentationOutput(instrumentationTest("com.example.LoginTest", "validLogin", 0))}
+		}
+		return fakeResult{stdout: "4322\n" + instrumentationOutput(
+			instrumentationTest("com.example.LoginTest", "invalidPassword", -2),
+			instrumentationTest("com.example.SettingsTest", "darkMode", 0),
+		)}
+	})
+
+	var mu sync.Mutex
+	finished := map[string]int{}
+	opts := ShardedTestOptions{
+		PackageName:         "com.example.test",
+		TestRunnerClass:     "androidx.test.runner.AndroidJUnitRunner",
+		HealthCheckInterval: time.Millisecond,
+		OnEvent: func(shard ShardInfo, event instrumentation.Event) {
+			if event.Type == instrumentation.EventTestStarted {
+				return
+			}
+			mu.Lock()
+			finished[shard.Serial]++
+			mu.Unlock()
+		},
+	}
+
+	// When
+	result, err := mockModelWithFactory(factory).RunShardedInstrumentedTests(context.Background(), []string{"emulator-5554", "emulator-5556"}, opts)
+
+	// Then
+	require.NoError(t, err)
+	require.Len(t, result.Shards, 2)
+	require.NoError(t, result.Shards[0].Err)
+	require.NoError(t, result.Shards[1].Err)
+	require.Contains(t, result.Shards[1].Output, "INSTRUMENTATION_CODE: -1")
+	require.NotContains(t, result.Shards[1].Output, "4322")
+	require.Equal(t, map[string]int{"emulator-5554": 1, "emulator-5556": 2}, finished)
+	require.True(t, result.Failed())
+
+	report := result.TestReport()
+	require.Len(t, report.TestSuites, 2)
+	require.Equal(t, "com.example.LoginTest", report.TestSuites[0].Name)
+	require.Equal(t, 2, report.TestSuites[0].Tests)
+	require.Equal(t, 1, report.TestSuites[0].Failures)
+	require.Equal(t, "com.example.SettingsTest", report.TestSuites[1].Name)
+}
+
+func Test_GivenDeviceGoesOffline_WhenRunShardedInstrumentedTests_ThenShardIsErrored(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		switch strings.Join(args, " ") {
+		case "-s emulator-5554 get-state":
+			return fakeResult{stdout: "device"}
+		case "-s emulator-5556 get-state":
+			return fakeResult{stderr: "error: device offline", exitCode: 1}
+		}
+		if args[1] == "emulator-5554" {
+			return fakeResult{stdout: instrumentationOutput(instrumentationTest("com.example.LoginTest", "validLogin", 0))}
+		}
+		// the instrumentation on the offline device never finishes
+		return fakeResult{delay: time.Minute}
+	})
+	opts := ShardedTestOptions{
+		PackageName:         "com.example.test",
+		TestRunnerClass:     "androidx.test.runner.AndroidJUnitRunner",
+		HealthCheckInterval: time.Millisecond,
+	}
+
+	// When
+	startTime := time.Now()
+	result, err := mockModelWithFactory(factory).RunShardedInstrumentedTests(context.Background(), []string{"emulator-5554", "emulator-5556"}, opts)
+
+	// Then
+	require.NoError(t, err)
+	require.Less(t, time.Since(startTime), time.Minute)
+	require.NoError(t, result.Shards[0].Err)
+	require.EqualError(t, result.Shards[1].Err, "shard 1 (emulator-5556): device went offline during the test run (state: offline)")
+
+	report := result.TestReport()
+	require.Len(t, report.TestSuites, 2)
+	require.Equal(t, "shard 1 (emulator-5556)", report.TestSuites[1].Name)
+	require.Equal(t, 1, report.TestSuites[1].Errors)
+}
+
+func Test_GivenTransientHealthCheckFailure_WhenRunShardedInstrumentedTests_ThenShardCompletes(t *testing.T) {
+	// Given
+	var checks atomic.Int32
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		if strings.Join(args, " ") == "-s emulator-5554 get-state" {
+			if checks.Add(1) == 1 {
+				return fakeResult{stderr: "error: closed", exitCode: 1}
+			}
+			return fakeResult{stdout: "device"}
+		}
+		return fakeResult{
+			stdout: "4321\n" + instrumentationOutput(instrumentationTest("com.example.LoginTest", "validLogin", 0)),
+			delay:  100 * time.Millisecond,
+		}
+	})
+	opts := ShardedTestOptions{
+		PackageName:         "com.example.test",
+		TestRunnerClass:     "androidx.test.runner.AndroidJUnitRunner",
+		HealthCheckInterval: time.Millisecond,
+	}
+
+	// When
+	result, err := mockModelWithFactory(factory).RunShardedInstrumentedTests(context.Background(), []string{"emulator-5554"}, opts)
+
+	// Then
+	require.NoError(t, err)
+	require.Greater(t, checks.Load(), int32(1))
+	require.NoError(t, result.Shards[0].Err)
+	require.False(t, result.Failed())
+}
+
+func Test_GivenDeviceGoesOffline_WhenRunShardedInstrumentedTests_ThenInterruptsInstrumentation(t *testing.T) {
+	// Given
+	factory := newInterruptibleCommandFactory("4321\n" + instrumentationTest("com.example.LoginTest", "validLogin", 0))
+	factory.handler = func(args []string) fakeResult {
+		if args[2] == "get-state" {
+			return fakeResult{stdout: "offline"}
+		}
+		return fakeResult{}
+	}
+	opts := ShardedTestOptions{
+		PackageName:         "com.example.test",
+		TestRunnerClass:     "androidx.test.runner.AndroidJUnitRunner",
+		HealthCheckInterval: time.Millisecond,
+		HealthCheckFailures: 2,
+	}
+
+	// When
+	result, err := mockModelWithFactory(factory).RunShardedInstrumentedTests(context.Background(), []string{"emulator-5554"}, opts)
+
+	// Then
+	require.NoError(t, err)
+	require.EqualError(t, result.Shards[0].Err, "shard 0 (emulator-5554): device went offline during the test run (state: offline)")
+	require.NotContains(t, result.Shards[0].Output, "4321")
+	require.Contains(t, factory.Calls(), []string{"-s", "emulator-5554", "shell", "kill", "-INT", "4321"})
+	require.Contains(t, factory.Calls(), []string{
+		"-s", "emulator-5554", "shell", "echo", "$$;", "exec",
+		"am", "instrument", "-w", "-r", "-e", "numShards", "1", "-e", "shardIndex", "0",
+		"com.example.test/androidx.test.runner.AndroidJUnitRunner",
+	})
+}
+
+func Test_GivenShardingOptions_WhenRunShardedInstrumentedTests_ThenFails(t *testing.T) {
+	// When
+	_, err := mockModel().RunShardedInstrumentedTests(context.Background(), []string{"emulator-5554"}, ShardedTestOptions{
+		Options: InstrumentationOptions{NumShards: 2},
+	})
+
+	// Then
+	require.EqualError(t, err, "number of shards and shard index are set by the sharded test run")
+}
+
+// Helpers
+
+func instrumentationTest(className, testName string, statusCode int) string {
+	status := "INSTRUMENTATION_STATUS: class=" + className + "\nINSTRUMENTATION_STATUS: test=" + testName + "\n"
+	return status + "INSTRUMENTATION_STATUS_CODE: 1\n" + status + "INSTRUMENTATION_STATUS_CODE: " + strconv.Itoa(statusCode) + "\n"
+}
+
+func instrumentationOutput(tests ...string) string {
+	return strings.Join(tests, "") + "INSTRUMENTATION_RESULT: stream=\n\nOK\n\nINSTRUMENTATION_CODE: -1\n"
+}
@@ -0,0 +1,134 @@
+package adbmanager
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/sliceutil"
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+// ShellV2Feature is the device feature (API 24+) of the shell protocol which keeps stdout and stderr separate and
+// reports the exit status of the command.
+const ShellV2Feature = "shell_v2"
+
+// exitCodeSentinel is printed with the exit status after the command on devices without ShellV2Feature.
+const exitCodeSentinel = "__ADB_EXIT_CODE__:"
+
+// ShellResult is the outcome of a device shell command.
+type ShellResult struct {
+	Stdout string
+	// Stderr is empty on devices without ShellV2Feature, the shell merges it into Stdout.
+	Stderr string
+	// ExitCode is the exit status of the command on the device.
+	ExitCode int
+}
+
+// Features returns the features supported by both the device and the adb server (`adb features`).
+func (model Model) Features(ctx context.Context, serial string) ([]string, error) {
+	cmd := model.adbCmdContext(ctx, []string{"-s", serial, "features"}, nil)
+	out, err := runWithContext(ctx, cmd)
+	if err != nil {
+		return nil, fmt.Errorf("features: %s: %w", out, err)
+	}
+	return parseFeatures(out), nil
+}
+
+// Shell runs the command on the device and returns its output and exit status, the arguments are quoted for the
+// device shell. A non-zero exit status is not an error, only failing to run the command is.
+func (model Model) Shell(ctx context.Context, serial string, args ...string) (ShellResult, error) {
+	features, err := model.Features(ctx, serial)
+	if err != nil {
+		return ShellResult{}, err
+	}
+	shellV2 := sliceutil.IsStringInSlice(ShellV2Feature, features)
+
+	shellArgs := []string{"-s", serial, "shell"}
+	for _, arg := range args {
+		shellArgs = append(shellArgs, quoteShellArg(arg))
+	}
+	if !shellV2 {
+		// adb doesn't report the exit status of the device command without shell v2, it is printed by the device shell
+		shellArgs = append(shellArgs, exitCodeSentinelSuffix)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := model.adbCmdContext(ctx, shellArgs, &command.Opts{Stdout: &stdout, Stderr: &stderr})
+	exitCode, err := runAndReturnExitCodeWithContext(ctx, cmd)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ShellResult{}, fmt.Errorf("shell %s: %w", strings.Join(args, " "), ctxErr)
+	}
+
+	if shellV2 {
+		if exitCode < 0 || (err != nil && isAdbClientError(stderr.String())) {
+			return ShellResult{}, fmt.Errorf("shell %s: %s: %w", strings.Join(args, " "), strings.TrimSpace(stderr.String()), err)
+		}
+		return ShellResult{Stdout: stdout.String(), Stderr: stderr.String(), ExitCode: exitCode}, nil
+	}
+
+	result, ok := parseExitCodeSentinel(ptyOutput(stdout.String()))
+	if !ok {
+		// the device shell didn't finish the command, or the command ended the shell
+		if err != nil {
+			return ShellResult{}, fmt.Errorf("shell %s: %s: %w", strings.Join(args, " "), strings.TrimSpace(stderr.String()), err)
+		}
+		return ShellResult{}, fmt.Errorf("shell %s: exit status not found in output: %s", strings.Join(args, " "), stdout.String())
+	}
+	result.Stderr = stderr.String()
+	return result, nil
+}
+
+// isAdbClientError returns true if the last line of stderr is an error of the adb client, like `error: closed` or
+// `error: device offline`. adb exits with 1 on these errors, which is indistinguishable from the exit status of
+// the device command.
+func isAdbClientError(stderr string) bool {
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	return strings.HasPrefix(strings.TrimSpace(lines[len(lines)-1]), "error: ")
+}
+
+// exitCodeSentinelSuffix prints the sentinel and the exit status of the command, the device shell runs it
+// after the command. It is never printed if the command ends the shell (`exit`, `exec`).
+const exitCodeSentinelSuffix = "; echo " + exitCodeSentinel + "$?"
+
+func quoteShellCommand(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quoteShellArg(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// ptyOutput converts the line endings of output produced on a pty, the shell of devices without shell v2 runs on
+// a pty which translates them to `\r\n`.
+func ptyOutput(out string) string {
+	return strings.ReplaceAll(out, "\r\n", "\n")
+}
+
+// parseExitCodeSentinel splits the output of a command run with exitCodeSentinelSuffix.
+func parseExitCodeSentinel(out string) (ShellResult, bool) {
+	i := strings.LastIndex(out, exitCodeSentinel)
+	if i < 0 {
+		return ShellResult{}, false
+	}
+
+	exitCode, err := strconv.Atoi(strings.TrimSpace(out[i+len(exitCodeSentinel):]))
+	if err != nil {
+		return ShellResult{}, false
+	}
+	return ShellResult{Stdout: out[:i], ExitCode: exitCode}, true
+}
+
+// parseFeatures parses the feature list, which is printed line by line by `adb features` and separated by commas
+// in the `host-serial:<serial>:features` response.
+func parseFeatures(out string) []string {
+	var features []string
+	for _, feature := range strings.FieldsFunc(out, func(r rune) bool { return r == ',' || r == '\n' || r == '\r' }) {
+		if feature = strings.TrimSpace(feature); feature != "" {
+			features = append(features, feature)
+		}
+	}
+	return features
+}
@@ -0,0 +1,146 @@
+package adbmanager
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GivenShellV2Device_WhenShell_ThenReturnsSeparateOutputsAndExitCode(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		if args[2] == "features" {
+			return fakeResult{stdout: "shell_v2\ncmd\nstat_v2\n"}
+		}
+		return fakeResult{stdout: "out\n", stderr: "ls: /missing: No such file or directory\n", exitCode: 2}
+	})
+
+	// When
+	result, err := mockModelWithFactory(factory).Shell(context.Background(), "emulator-5554", "ls", "/sdcard/My Files", "/missing")
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, ShellResult{Stdout: "out\n", Stderr: "ls: /missing: No such file or directory\n", ExitCode: 2}, result)
+	require.Equal(t, [][]string{
+		{"-s", "emulator-5554", "features"},
+		{"-s", "emulator-5554", "shell", "ls", "'/sdcard/My Files'", "/missing"},
+	}, factory.Calls())
+}
+
+func Test_GivenShellV2Device_WhenCommandExitsTheShell_ThenReturnsItsExitCode(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		if args[2] == "features" {
+			return fakeResult{stdout: "shell_v2\ncmd\n"}
+		}
+		return fakeResult{exitCode: 3}
+	})
+
+	// When
+	result, err := mockModelWithFactory(factory).Shell(context.Background(), "emulator-5554", "exit", "3")
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, ShellResult{ExitCode: 3}, result)
+	require.Equal(t, []string{"-s", "emulator-5554", "shell", "exit", "3"}, factory.Calls()[1])
+}
+
+func Test_GivenLegacyDevice_WhenShell_ThenExitCodeIsParsedFromSentinel(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		if args[2] == "features" {
+			return fakeResult{stdout: "cmd\n"}
+		}
+		return fakeResult{stdout: "Error: unknown package\r\n" + exitCodeSentinel + "1\r\n"}
+	})
+
+	// When
+	result, err := mockModelWithFactory(factory).Shell(context.Background(), "emulator-5554", "pm", "clear", "com.example")
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, ShellResult{Stdout: "Error: unknown package\n", ExitCode: 1}, result)
+	require.Equal(t, []string{"-s", "emulator-5554", "shell", "pm", "clear", "com.example", "; echo " + exitCodeSentinel + "$?"}, factory.Calls()[1])
+}
+
+func Test_GivenOfflineDevice_WhenShell_ThenReturnsError(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stderr: "adb: device offline", exitCode: 1}
+	})
+
+	// When
+	_, err := mockModelWithFactory(factory).Shell(context.Background(), "emulator-5554", "true")
+
+	// Then
+	require.Error(t, err)
+	require.Len(t, factory.Calls(), 1)
+}
+
+func Test_GivenShellV2DeviceGoesOffline_WhenShell_ThenReturnsError(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		if args[2] == "features" {
+			return fakeResult{stdout: "shell_v2\ncmd\n"}
+		}
+		return fakeResult{stderr: "error: closed\n", exitCode: 1}
+	})
+
+	// When
+	_, err := mockModelWithFactory(factory).Shell(context.Background(), "emulator-5554", "pm", "list", "packages")
+
+	// Then
+	require.ErrorContains(t, err, "shell pm list packages: error: closed")
+	require.Len(t, factory.Calls(), 2)
+}
+
+func Test_GivenCancelledContext_WhenShell_ThenReturnsContextError(t *testing.T) {
+	// Given
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// When
+	_, err := mockModelWithFactory(newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{}
+	})).Shell(ctx, "emulator-5554", "true")
+
+	// Then
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func Test_parseExitCodeSentinel(t *testing.T) {
+	tests := []struct {
+		name   string
+		out    string
+		want   ShellResult
+		wantOK bool
+	}{
+		{
+			name:   "output without trailing newline",
+			out:    "done" + exitCodeSentinel + "0\n",
+			want:   ShellResult{Stdout: "done", ExitCode: 0},
+			wantOK: true,
+		},
+		{
+			name:   "sentinel printed by the command itself",
+			out:    exitCodeSentinel + "7\n" + exitCodeSentinel + "0\n",
+			want:   ShellResult{Stdout: exitCodeSentinel + "7\n", ExitCode: 0},
+			wantOK: true,
+		},
+		{
+			name:   "missing sentinel",
+			out:    "Killed\n",
+			wantOK: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseExitCodeSentinel(tt.out)
+			if ok != tt.wantOK || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseExitCodeSentinel() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
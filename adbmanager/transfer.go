@@ -0,0 +1,295 @@
+package adbmanager
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+// HashAlgorithm is the algorithm used to verify transfers, the device needs the matching `<algorithm>sum` tool.
+type HashAlgorithm string
+
+// Hash algorithms ...
+const (
+	HashSHA256 HashAlgorithm = "sha256"
+	HashMD5    HashAlgorithm = "md5"
+)
+
+// TransferProgress is the progress of a file transferred with WireClient.
+type TransferProgress struct {
+	Path    string
+	Percent int
+}
+
+// TransferOptions ...
+type TransferOptions struct {
+	// OnProgress is called with the progress of the transferred files. It is only supported by WireClient,
+	// the transfers of Model fail if it is set: adb prints the progress lines only if its output is a terminal.
+	OnProgress func(TransferProgress)
+	// Verify compares the hashes of the transferred files on the device and on the host, verification is skipped if empty.
+	// The destination path has to name the transferred file or directory itself (not its parent directory).
+	Verify HashAlgorithm
+}
+
+// TransferResult is the summary printed by adb once the transfer completed.
+type TransferResult struct {
+	Files   int
+	Skipped int
+	Bytes   int64
+	// Duration is the transfer time measured by adb.
+	Duration time.Duration
+}
+
+// PushCmd builds and returns a `Command` copying a local file or directory to the device.
+func (model Model) PushCmd(serial, localPath, remotePath string, commandOptions *command.Opts) command.Command {
+	return model.adbCmd([]string{"-s", serial, "push", localPath, remotePath}, commandOptions)
+}
+
+// PullCmd builds and returns a `Command` copying a file or directory from the device.
+func (model Model) PullCmd(serial, remotePath, localPath string, commandOptions *command.Opts) command.Command {
+	return model.adbCmd([]string{"-s", serial, "pull", remotePath, localPath}, commandOptions)
+}
+
+// SyncCmd builds and returns a `Command` pushing only the files of the local directory, which are newer than
+// their device copy (`adb push --sync`).
+func (model Model) SyncCmd(serial, localDir, remoteDir string, commandOptions *command.Opts) command.Command {
+	return model.adbCmd([]string{"-s", serial, "push", "--sync", localDir, remoteDir}, commandOptions)
+}
+
+// Push copies a local file or directory to the device.
+func (model Model) Push(serial, localPath, remotePath string, opts TransferOptions) (TransferResult, error) {
+	return model.transfer(serial, localPath, remotePath, opts, func(cmdOpts *command.Opts) command.Command {
+		return model.PushCmd(serial, localPath, remotePath, cmdOpts)
+	})
+}
+
+// Pull copies a file or directory from the device.
+func (model Model) Pull(serial, remotePath, localPath string, opts TransferOptions) (TransferResult, error) {
+	return model.transfer(serial, localPath, remotePath, opts, func(cmdOpts *command.Opts) command.Command {
+		return model.PullCmd(serial, remotePath, localPath, cmdOpts)
+	})
+}
+
+// Sync pushes the files of the local directory which changed since the last sync.
+func (model Model) Sync(serial, localDir, remoteDir string, opts TransferOptions) (TransferResult, error) {
+	return model.transfer(serial, localDir, remoteDir, opts, func(cmdOpts *command.Opts) command.Command {
+		return model.SyncCmd(serial, localDir, remoteDir, cmdOpts)
+	})
+}
+
+func (model Model) transfer(serial, localPath, remotePath string, opts TransferOptions, createCmd func(*command.Opts) command.Command) (TransferResult, error) {
+	if opts.OnProgress != nil {
+		return TransferResult{}, errors.New("transfer progress is only reported by WireClient")
+	}
+
+	output := newTransferOutput()
+	cmd := createCmd(&command.Opts{Stdout: output, Stderr: output})
+
+	model.logger.Printf("$ %s", cmd.PrintableCommandArgs())
+	runErr := cmd.Run()
+	out := output.finish()
+	if runErr != nil {
+		return TransferResult{}, fmt.Errorf("transfer failed: %s: %w", strings.TrimSpace(out), runErr)
+	}
+	result := parseTransferResult(out)
+
+	if opts.Verify != "" {
+		if err := verifyTransfer(localPath, remotePath, opts.Verify, func(args ...string) (string, error) {
+			cmd := model.adbCmd(append([]string{"-s", serial, "shell"}, args...), nil)
+			return cmd.RunAndReturnTrimmedOutput()
+		}); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// verifyTransfer compares the local hashes with the ones computed by the device shell.
+func verifyTransfer(localPath, remotePath string, algorithm HashAlgorithm, shell func(args ...string) (string, error)) error {
+	newHash, err := hashConstructor(algorithm)
+	if err != nil {
+		return err
+	}
+
+	localHashes, err := localFileHashes(localPath, newHash)
+	if err != nil {
+		return fmt.Errorf("verify transfer: %w", err)
+	}
+
+	remotePath = path.Clean(remotePath)
+	out, err := shell("find", quoteShellArg(remotePath), "-type", "f", "-exec", string(algorithm)+"sum", "{}", "+")
+	if err != nil {
+		return fmt.Errorf("verify transfer: hash device files: %s: %w", out, err)
+	}
+	remoteHashes := parseHashSums(out, remotePath)
+
+	var mismatches []string
+	for name, localHash := range localHashes {
+		remoteHash, ok := remoteHashes[name]
+		switch {
+		case !ok:
+			mismatches = append(mismatches, fmt.Sprintf("%s: missing on the device", displayName(name)))
+		case remoteHash != localHash:
+			mismatches = append(mismatches, fmt.Sprintf("%s: %s hash mismatch (host: %s, device: %s)", displayName(name), algorithm, localHash, remoteHash))
+		}
+	}
+	for name := range remoteHashes {
+		if _, ok := localHashes[name]; !ok {
+			mismatches = append(mismatches, fmt.Sprintf("%s: missing on the host", displayName(name)))
+		}
+	}
+	if len(mismatches) > 0 {
+		sort.Strings(mismatches)
+		return fmt.Errorf("verify transfer: %s", strings.Join(mismatches, ", "))
+	}
+	return nil
+}
+
+func hashConstructor(algorithm HashAlgorithm) (func() hash.Hash, error) {
+	switch algorithm {
+	case HashSHA256:
+		return sha256.New, nil
+	case HashMD5:
+		return md5.New, nil
+	}
+	return nil, fmt.Errorf("unsupported hash algorithm: %s", algorithm)
+}
+
+// localFileHashes returns the hashes of the files by their slash separated path relative to root,
+// a single file is stored with an empty path.
+func localFileHashes(root string, newHash func() hash.Hash) (map[string]string, error) {
+	hashes := map[string]string{}
+	err := filepath.Walk(root, func(pth string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, pth)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			rel = ""
+		}
+
+		file, err := os.Open(pth)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		h := newHash()
+		if _, err := io.Copy(h, file); err != nil {
+			return err
+		}
+		hashes[filepath.ToSlash(rel)] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	return hashes, err
+}
+
+// parseHashSums parses `<hash>  <path>` lines by the path relative to root.
+func parseHashSums(out, root string) map[string]string {
+	hashes := map[string]string{}
+	for _, line := range strings.Split(out, "\n") {
+		sum, pth, ok := strings.Cut(strings.TrimSpace(line), "  ")
+		if !ok {
+			continue
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(pth, root), "/")
+		hashes[rel] = sum
+	}
+	return hashes
+}
+
+func displayName(name string) string {
+	if name == "" {
+		return "file"
+	}
+	return name
+}
+
+var (
+	// [ 45%] /sdcard/fixtures/data.json
+	transferProgressPattern = regexp.MustCompile(`^\[\s*\d+%\]\s`)
+	// fixtures/: 3 files pushed, 1 skipped. 42.1 MB/s (1234567 bytes in 0.028s)
+	transferResultPattern = regexp.MustCompile(`(\d+) files? (?:pushed|pulled)(?:, (\d+) skipped)?\.(?:.*\((\d+) bytes in ([\d.]+)s\))?`)
+)
+
+func parseTransferResult(out string) TransferResult {
+	var result TransferResult
+	match := transferResultPattern.FindStringSubmatch(out)
+	if match == nil {
+		return result
+	}
+
+	result.Files, _ = strconv.Atoi(match[1])
+	result.Skipped, _ = strconv.Atoi(match[2])
+	result.Bytes, _ = strconv.ParseInt(match[3], 10, 64)
+	if seconds, err := strconv.ParseFloat(match[4], 64); err == nil {
+		result.Duration = time.Duration(seconds * float64(time.Second))
+	}
+	return result
+}
+
+// transferOutput collects the adb output without the progress lines, which are separated by `\r` on terminals.
+type transferOutput struct {
+	mu   sync.Mutex
+	out  bytes.Buffer
+	line []byte
+}
+
+func newTransferOutput() *transferOutput {
+	return &transferOutput{}
+}
+
+func (output *transferOutput) Write(p []byte) (int, error) {
+	output.mu.Lock()
+	defer output.mu.Unlock()
+
+	for _, b := range p {
+		if b == '\r' || b == '\n' {
+			output.flushLine()
+			continue
+		}
+		output.line = append(output.line, b)
+	}
+	return len(p), nil
+}
+
+func (output *transferOutput) flushLine() {
+	line := strings.TrimSpace(string(output.line))
+	output.line = output.line[:0]
+	if line == "" {
+		return
+	}
+
+	if transferProgressPattern.MatchString(line) {
+		return
+	}
+	output.out.WriteString(line + "\n")
+}
+
+func (output *transferOutput) finish() string {
+	output.mu.Lock()
+	defer output.mu.Unlock()
+
+	output.flushLine()
+	return output.out.String()
+}
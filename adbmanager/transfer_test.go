@@ -0,0 +1,102 @@
+package adbmanager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GivenProgressOutput_WhenPush_ThenReturnsResult(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: "[  0%] /sdcard/fixtures/a.json\r[ 50%] /sdcard/fixtures/a.json\r[100%] /sdcard/fixtures/b.json\r" +
+			"fixtures/: 2 files pushed, 0 skipped. 12.3 MB/s (2048 bytes in 0.250s)\n"}
+	})
+
+	// When
+	result, err := mockModelWithFactory(factory).Push("emulator-5554", "fixtures", "/sdcard/fixtures", TransferOptions{})
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, TransferResult{Files: 2, Bytes: 2048, Duration: 250 * time.Millisecond}, result)
+	require.Equal(t, [][]string{{"-s", "emulator-5554", "push", "fixtures", "/sdcard/fixtures"}}, factory.Calls())
+}
+
+func Test_GivenOnProgress_WhenPush_ThenFailsWithoutTransfer(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{}
+	})
+
+	// When
+	_, err := mockModelWithFactory(factory).Push("emulator-5554", "fixtures", "/sdcard/fixtures", TransferOptions{
+		OnProgress: func(TransferProgress) {},
+	})
+
+	// Then
+	require.EqualError(t, err, "transfer progress is only reported by WireClient")
+	require.Empty(t, factory.Calls())
+}
+
+func Test_GivenMissingRemoteDir_WhenPull_ThenFails(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stderr: "adb: error: failed to stat remote object '/sdcard/coverage': No such file or directory", exitCode: 1}
+	})
+
+	// When
+	_, err := mockModelWithFactory(factory).Pull("emulator-5554", "/sdcard/coverage", "coverage", TransferOptions{})
+
+	// Then
+	require.ErrorContains(t, err, "transfer failed: adb: error: failed to stat remote object '/sdcard/coverage': No such file or directory")
+}
+
+func Test_GivenVerify_WhenSync_ThenComparesHashes(t *testing.T) {
+	// Given
+	localDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(localDir, "nested"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(localDir, "a.txt"), []byte("a"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(localDir, "nested", "b.txt"), []byte("b"), 0o644))
+
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		if args[2] == "push" {
+			return fakeResult{stdout: localDir + "/: 1 file pushed, 1 skipped. 0.1 MB/s (1 bytes in 0.001s)"}
+		}
+		return fakeResult{stdout: "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb  /sdcard/fixtures/a.txt\n" +
+			"0000000000000000000000000000000000000000000000000000000000000000  /sdcard/fixtures/nested/b.txt\n" +
+			"2e7d2c03a9507ae265ecf5b5356885a53393a2029d241394997265a1a25aefc6  /sdcard/fixtures/c.txt"}
+	})
+
+	// When
+	result, err := mockModelWithFactory(factory).Sync("emulator-5554", localDir, "/sdcard/fixtures/", TransferOptions{Verify: HashSHA256})
+
+	// Then
+	require.Equal(t, TransferResult{Files: 1, Skipped: 1, Bytes: 1, Duration: time.Millisecond}, result)
+	require.EqualError(t, err, "verify transfer: c.txt: missing on the host, "+
+		"nested/b.txt: sha256 hash mismatch (host: 3e23e8160039594a33894f6564e1b1348bbd7a0088d42c4acb73eeaed59c009d, device: 0000000000000000000000000000000000000000000000000000000000000000)")
+	require.Equal(t, []string{"-s", "emulator-5554", "push", "--sync", localDir, "/sdcard/fixtures/"}, factory.Calls()[0])
+	require.Equal(t, []string{"-s", "emulator-5554", "shell", "find", "/sdcard/fixtures", "-type", "f", "-exec", "sha256sum", "{}", "+"}, factory.Calls()[1])
+}
+
+func Test_GivenSingleFile_WhenPullWithMD5Verify_ThenSucceeds(t *testing.T) {
+	// Given
+	localPath := filepath.Join(t.TempDir(), "coverage.ec")
+	require.NoError(t, os.WriteFile(localPath, []byte("a"), 0o644))
+
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		if args[2] == "pull" {
+			return fakeResult{stdout: "/sdcard/coverage.ec: 1 file pulled, 0 skipped."}
+		}
+		return fakeResult{stdout: "0cc175b9c0f1b6a831c399e269772661  /sdcard/coverage.ec"}
+	})
+
+	// When
+	result, err := mockModelWithFactory(factory).Pull("emulator-5554", "/sdcard/coverage.ec", localPath, TransferOptions{Verify: HashMD5})
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, TransferResult{Files: 1}, result)
+}
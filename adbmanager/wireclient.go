@@ -0,0 +1,600 @@
+package adbmanager
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/sliceutil"
+)
+
+const (
+	wireDialTimeout = 5 * time.Second
+	// syncMaxChunkSize is the maximum payload of a sync DATA packet.
+	syncMaxChunkSize = 64 * 1024
+
+	// file type bits of the sync STAT and DENT modes
+	syncModeTypeMask = 0o170000
+	syncModeDir      = 0o040000
+	syncModeRegular  = 0o100000
+
+	// shell v2 packet ids
+	shellV2Stdout     = 1
+	shellV2Stderr     = 2
+	shellV2Exit       = 3
+	shellV2CloseStdin = 4
+)
+
+// WireClient talks to the adb server over its TCP host protocol instead of running the adb binary, which saves
+// a process start and the output parsing of frequently called operations.
+// The server is not started on demand, use Model.StartServer if needed.
+// See: https://android.googlesource.com/platform/packages/modules/adb/+/refs/heads/main/docs/dev/services.md
+type WireClient struct {
+	address string
+}
+
+// NewWireClient returns a client of the adb server listening on the given local port, port 0 selects adb's default:
+// ANDROID_ADB_SERVER_PORT if set, otherwise DefaultServerPort.
+func NewWireClient(serverPort int) WireClient {
+	if serverPort == 0 {
+		serverPort = DefaultServerPort
+		if port, err := strconv.Atoi(os.Getenv("ANDROID_ADB_SERVER_PORT")); err == nil {
+			serverPort = port
+		}
+	}
+
+	return WireClient{address: net.JoinHostPort("127.0.0.1", strconv.Itoa(serverPort))}
+}
+
+// ListDevices returns the devices and emulators attached to the server (`host:devices-l`).
+func (client WireClient) ListDevices() ([]Device, error) {
+	conn, err := client.dial(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("list devices: %w", err)
+	}
+	defer conn.Close()
+
+	if err := sendHostRequest(conn, "host:devices-l"); err != nil {
+		return nil, fmt.Errorf("list devices: %w", err)
+	}
+	out, err := readHostString(conn)
+	if err != nil {
+		return nil, fmt.Errorf("list devices: %w", err)
+	}
+	return parseDevices(out), nil
+}
+
+// DeviceInfo returns the system properties of the device.
+func (client WireClient) DeviceInfo(serial string) (DeviceInfo, error) {
+	out, err := client.shellOutput(serial, "getprop")
+	if err != nil {
+		return DeviceInfo{}, fmt.Errorf("getprop: %w", err)
+	}
+
+	info := parseDeviceInfo(parseGetprop(out))
+	info.Serial = serial
+	return info, nil
+}
+
+// Push copies a local file or directory to the device through the sync service. Like `adb push`, the source is
+// copied into remotePath if it is an existing directory.
+func (client WireClient) Push(serial, localPath, remotePath string, opts TransferOptions) (TransferResult, error) {
+	startTime := time.Now()
+	var result TransferResult
+	err := client.withSync(serial, func(sync syncConn) error {
+		localInfo, err := os.Stat(localPath)
+		if err != nil {
+			return err
+		}
+		remoteMode, _, err := sync.stat(remotePath)
+		if err != nil {
+			return err
+		}
+		if remoteMode&syncModeTypeMask == syncModeDir {
+			remotePath = path.Join(remotePath, filepath.Base(localPath))
+		}
+
+		if !localInfo.IsDir() {
+			return sync.push(localPath, remotePath, localInfo, opts.OnProgress, &result)
+		}
+		return filepath.Walk(localPath, func(pth string, info os.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() {
+				return err
+			}
+			rel, err := filepath.Rel(localPath, pth)
+			if err != nil {
+				return err
+			}
+			return sync.push(pth, path.Join(remotePath, filepath.ToSlash(rel)), info, opts.OnProgress, &result)
+		})
+	})
+	if err != nil {
+		return TransferResult{}, fmt.Errorf("transfer failed: %w", err)
+	}
+	result.Duration = time.Since(startTime)
+
+	if opts.Verify != "" {
+		if err := verifyTransfer(localPath, remotePath, opts.Verify, client.shellFunc(serial)); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// Pull copies a file or directory from the device through the sync service. Like `adb pull`, the source is
+// copied into localPath if it is an existing directory.
+func (client WireClient) Pull(serial, remotePath, localPath string, opts TransferOptions) (TransferResult, error) {
+	startTime := time.Now()
+	var result TransferResult
+	err := client.withSync(serial, func(sync syncConn) error {
+		mode, size, err := sync.stat(remotePath)
+		if err != nil {
+			return err
+		}
+		if mode == 0 {
+			return fmt.Errorf("remote object '%s' does not exist", remotePath)
+		}
+		if info, err := os.Stat(localPath); err == nil && info.IsDir() {
+			localPath = filepath.Join(localPath, path.Base(remotePath))
+		}
+
+		if mode&syncModeTypeMask != syncModeDir {
+			return sync.pull(remotePath, localPath, size, opts.OnProgress, &result)
+		}
+		return sync.pullDir(remotePath, localPath, opts.OnProgress, &result)
+	})
+	if err != nil {
+		return TransferResult{}, fmt.Errorf("transfer failed: %w", err)
+	}
+	result.Duration = time.Since(startTime)
+
+	if opts.Verify != "" {
+		if err := verifyTransfer(localPath, remotePath, opts.Verify, client.shellFunc(serial)); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// Features returns the features supported by both the device and the adb server (`host-serial:<serial>:features`).
+func (client WireClient) Features(ctx context.Context, serial string) ([]string, error) {
+	conn, err := client.dial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("features: %w", err)
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+
+	if err := sendHostRequest(conn, "host-serial:"+serial+":features"); err != nil {
+		return nil, fmt.Errorf("features: %w", contextErrOr(ctx, err))
+	}
+	out, err := readHostString(conn)
+	if err != nil {
+		return nil, fmt.Errorf("features: %w", contextErrOr(ctx, err))
+	}
+	return parseFeatures(out), nil
+}
+
+// Shell runs the command on the device and returns its output and exit status, the arguments are quoted for the
+// device shell. A non-zero exit status is not an error, only failing to run the command is.
+func (client WireClient) Shell(ctx context.Context, serial string, args ...string) (ShellResult, error) {
+	features, err := client.Features(ctx, serial)
+	if err != nil {
+		return ShellResult{}, err
+	}
+
+	var result ShellResult
+	if sliceutil.IsStringInSlice(ShellV2Feature, features) {
+		result, err = client.shellV2(ctx, serial, quoteShellCommand(args))
+	} else {
+		result, err = client.shellWithExitCodeSentinel(ctx, serial, quoteShellCommand(args))
+	}
+	if err != nil {
+		return ShellResult{}, fmt.Errorf("shell %s: %w", strings.Join(args, " "), contextErrOr(ctx, err))
+	}
+	return result, nil
+}
+
+// shellV2 runs the command with the `shell,v2,raw:` service: every packet has a 1 byte id, a little endian uint32
+// length and the payload.
+func (client WireClient) shellV2(ctx context.Context, serial, cmd string) (ShellResult, error) {
+	conn, err := client.deviceConn(ctx, serial, "shell,v2,raw:"+cmd)
+	if err != nil {
+		return ShellResult{}, err
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+
+	// the command doesn't get any input
+	if _, err := conn.Write([]byte{shellV2CloseStdin, 0, 0, 0, 0}); err != nil {
+		return ShellResult{}, err
+	}
+
+	var stdout, stderr strings.Builder
+	header := make([]byte, 5)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return ShellResult{}, fmt.Errorf("read shell packet: %w", err)
+		}
+		data := make([]byte, binary.LittleEndian.Uint32(header[1:]))
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return ShellResult{}, fmt.Errorf("read shell packet: %w", err)
+		}
+
+		switch header[0] {
+		case shellV2Stdout:
+			stdout.Write(data)
+		case shellV2Stderr:
+			stderr.Write(data)
+		case shellV2Exit:
+			if len(data) != 1 {
+				return ShellResult{}, fmt.Errorf("invalid exit packet: %v", data)
+			}
+			return ShellResult{Stdout: stdout.String(), Stderr: stderr.String(), ExitCode: int(data[0])}, nil
+		}
+	}
+}
+
+func (client WireClient) shellWithExitCodeSentinel(ctx context.Context, serial, cmd string) (ShellResult, error) {
+	conn, err := client.deviceConn(ctx, serial, "shell:"+cmd+" "+exitCodeSentinelSuffix)
+	if err != nil {
+		return ShellResult{}, err
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+
+	out, err := io.ReadAll(conn)
+	if err != nil {
+		return ShellResult{}, err
+	}
+	result, ok := parseExitCodeSentinel(ptyOutput(string(out)))
+	if !ok {
+		return ShellResult{}, fmt.Errorf("exit status not found in output: %s", out)
+	}
+	return result, nil
+}
+
+func (client WireClient) dial(ctx context.Context) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: wireDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", client.address)
+	if err != nil {
+		return nil, fmt.Errorf("connect to adb server at %s: %w", client.address, err)
+	}
+	return conn, nil
+}
+
+// deviceConn returns a connection to the given service of the device (`host:transport:<serial>` followed by the service).
+func (client WireClient) deviceConn(ctx context.Context, serial, service string) (net.Conn, error) {
+	conn, err := client.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sendHostRequest(conn, "host:transport:"+serial); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := sendHostRequest(conn, service); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// shellOutput runs the command with the `shell:` service, which returns the interleaved stdout and stderr
+// without the exit code.
+func (client WireClient) shellOutput(serial string, args ...string) (string, error) {
+	conn, err := client.deviceConn(context.Background(), serial, "shell:"+strings.Join(args, " "))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	out, err := io.ReadAll(conn)
+	return strings.TrimSpace(string(out)), err
+}
+
+func (client WireClient) shellFunc(serial string) func(args ...string) (string, error) {
+	return func(args ...string) (string, error) {
+		return client.shellOutput(serial, args...)
+	}
+}
+
+func (client WireClient) withSync(serial string, fn func(syncConn) error) error {
+	conn, err := client.deviceConn(context.Background(), serial, "sync:")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	sync := syncConn{conn: conn}
+	if err := fn(sync); err != nil {
+		return err
+	}
+	return sync.send("QUIT", 0, nil)
+}
+
+// contextErrOr returns ctx's error if it is done, the connection is closed on cancellation, so err is a read or write error.
+func contextErrOr(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// sendHostRequest sends a request prefixed by its hex length and reads the OKAY/FAIL status.
+func sendHostRequest(conn net.Conn, request string) error {
+	if _, err := fmt.Fprintf(conn, "%04x%s", len(request), request); err != nil {
+		return err
+	}
+
+	status := make([]byte, 4)
+	if _, err := io.ReadFull(conn, status); err != nil {
+		return fmt.Errorf("%s: read status: %w", request, err)
+	}
+	switch string(status) {
+	case "OKAY":
+		return nil
+	case "FAIL":
+		message, err := readHostString(conn)
+		if err != nil {
+			return fmt.Errorf("%s: read failure: %w", request, err)
+		}
+		return fmt.Errorf("%s: %s", request, message)
+	}
+	return fmt.Errorf("%s: unexpected status: %q", request, status)
+}
+
+// readHostString reads a string prefixed by its hex length.
+func readHostString(r io.Reader) (string, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", err
+	}
+	length, err := strconv.ParseUint(string(header), 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("invalid length: %q", header)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// syncConn speaks the sync protocol: every packet starts with a 4 byte id and a little endian uint32,
+// which is the length of the payload for most of the packets.
+type syncConn struct {
+	conn net.Conn
+}
+
+type syncEntry struct {
+	name string
+	mode uint32
+	size uint32
+}
+
+func (sync syncConn) send(id string, length uint32, data []byte) error {
+	packet := make([]byte, 8, 8+len(data))
+	copy(packet, id)
+	binary.LittleEndian.PutUint32(packet[4:], length)
+	_, err := sync.conn.Write(append(packet, data...))
+	return err
+}
+
+func (sync syncConn) readHeader() (string, uint32, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(sync.conn, header); err != nil {
+		return "", 0, err
+	}
+	return string(header[:4]), binary.LittleEndian.Uint32(header[4:]), nil
+}
+
+func (sync syncConn) readFailure(length uint32) error {
+	message := make([]byte, length)
+	if _, err := io.ReadFull(sync.conn, message); err != nil {
+		return err
+	}
+	return errors.New(string(message))
+}
+
+// stat returns the mode and the size of the remote path, the mode is 0 if the path doesn't exist.
+func (sync syncConn) stat(remotePath string) (uint32, uint32, error) {
+	if err := sync.send("STAT", uint32(len(remotePath)), []byte(remotePath)); err != nil {
+		return 0, 0, err
+	}
+
+	response := make([]byte, 16)
+	if _, err := io.ReadFull(sync.conn, response); err != nil {
+		return 0, 0, err
+	}
+	if id := string(response[:4]); id != "STAT" {
+		return 0, 0, fmt.Errorf("stat %s: unexpected response: %s", remotePath, id)
+	}
+	return binary.LittleEndian.Uint32(response[4:]), binary.LittleEndian.Uint32(response[8:]), nil
+}
+
+func (sync syncConn) list(remoteDir string) ([]syncEntry, error) {
+	if err := sync.send("LIST", uint32(len(remoteDir)), []byte(remoteDir)); err != nil {
+		return nil, err
+	}
+
+	var entries []syncEntry
+	for {
+		// DENT|DONE, mode, size, mtime, name length
+		response := make([]byte, 20)
+		if _, err := io.ReadFull(sync.conn, response); err != nil {
+			return nil, err
+		}
+		switch id := string(response[:4]); id {
+		case "DONE":
+			return entries, nil
+		case "DENT":
+			name := make([]byte, binary.LittleEndian.Uint32(response[16:]))
+			if _, err := io.ReadFull(sync.conn, name); err != nil {
+				return nil, err
+			}
+			if string(name) == "." || string(name) == ".." {
+				continue
+			}
+			entries = append(entries, syncEntry{
+				name: string(name),
+				mode: binary.LittleEndian.Uint32(response[4:]),
+				size: binary.LittleEndian.Uint32(response[8:]),
+			})
+		default:
+			return nil, fmt.Errorf("list %s: unexpected response: %s", remoteDir, id)
+		}
+	}
+}
+
+// push sends the file with `SEND <path>,<mode>`, the DATA packets and `DONE <mtime>`, missing parent directories
+// are created by the device.
+func (sync syncConn) push(localPath, remotePath string, info os.FileInfo, onProgress func(TransferProgress), result *TransferResult) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	target := remotePath + "," + strconv.Itoa(syncModeRegular|int(info.Mode().Perm()))
+	if err := sync.send("SEND", uint32(len(target)), []byte(target)); err != nil {
+		return err
+	}
+
+	progress := newSyncProgress(remotePath, info.Size(), onProgress)
+	buf := make([]byte, syncMaxChunkSize)
+	for {
+		n, readErr := file.Read(buf)
+		if n > 0 {
+			if err := sync.send("DATA", uint32(n), buf[:n]); err != nil {
+				return err
+			}
+			progress.add(n)
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+	if err := sync.send("DONE", uint32(info.ModTime().Unix()), nil); err != nil {
+		return err
+	}
+
+	id, length, err := sync.readHeader()
+	if err != nil {
+		return err
+	}
+	switch id {
+	case "OKAY":
+		result.Files++
+		result.Bytes += info.Size()
+		return nil
+	case "FAIL":
+		return fmt.Errorf("push %s: %w", remotePath, sync.readFailure(length))
+	}
+	return fmt.Errorf("push %s: unexpected response: %s", remotePath, id)
+}
+
+func (sync syncConn) pull(remotePath, localPath string, size uint32, onProgress func(TransferProgress), result *TransferResult) error {
+	if err := os.MkdirAll(filepath.Dir(localPath), 0o755); err != nil {
+		return err
+	}
+	file, err := os.Create(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := sync.send("RECV", uint32(len(remotePath)), []byte(remotePath)); err != nil {
+		return err
+	}
+
+	progress := newSyncProgress(remotePath, int64(size), onProgress)
+	for {
+		id, length, err := sync.readHeader()
+		if err != nil {
+			return err
+		}
+		switch id {
+		case "DATA":
+			if _, err := io.CopyN(file, sync.conn, int64(length)); err != nil {
+				return err
+			}
+			progress.add(int(length))
+			result.Bytes += int64(length)
+		case "DONE":
+			result.Files++
+			return file.Close()
+		case "FAIL":
+			return fmt.Errorf("pull %s: %w", remotePath, sync.readFailure(length))
+		default:
+			return fmt.Errorf("pull %s: unexpected response: %s", remotePath, id)
+		}
+	}
+}
+
+func (sync syncConn) pullDir(remoteDir, localDir string, onProgress func(TransferProgress), result *TransferResult) error {
+	entries, err := sync.list(remoteDir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(localDir, 0o755); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		remotePath := path.Join(remoteDir, entry.name)
+		localPath := filepath.Join(localDir, entry.name)
+		switch entry.mode & syncModeTypeMask {
+		case syncModeDir:
+			err = sync.pullDir(remotePath, localPath, onProgress, result)
+		case syncModeRegular:
+			err = sync.pull(remotePath, localPath, entry.size, onProgress, result)
+		default:
+			// symlinks and special files are skipped
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncProgress reports the percentage of the transferred file whenever it changes.
+type syncProgress struct {
+	path        string
+	size        int64
+	transferred int64
+	percent     int
+	onProgress  func(TransferProgress)
+}
+
+func newSyncProgress(path string, size int64, onProgress func(TransferProgress)) *syncProgress {
+	return &syncProgress{path: path, size: size, percent: -1, onProgress: onProgress}
+}
+
+func (progress *syncProgress) add(n int) {
+	if progress.onProgress == nil || progress.size == 0 {
+		return
+	}
+
+	progress.transferred += int64(n)
+	if percent := int(progress.transferred * 100 / progress.size); percent != progress.percent {
+		progress.percent = percent
+		progress.onProgress(TransferProgress{Path: progress.path, Percent: percent})
+	}
+}
@@ -0,0 +1,384 @@
+package adbmanager
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GivenFakeServer_WhenListDevices_ThenReturnsParsedDevices(t *testing.T) {
+	// Given
+	server := newFakeADBServer(t)
+	server.devices = "emulator-5554          device product:sdk_gphone64_x86_64 model:sdk_gphone64_x86_64 device:emu64xa transport_id:1\n" +
+		"R58M1234ABC            unauthorized usb:1-1 transport_id:2\n"
+
+	// When
+	devices, err := server.client().ListDevices()
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []string{"host:devices-l"}, server.Requests())
+	require.Equal(t, []Device{
+		{Serial: "emulator-5554", State: DeviceStateDevice, TransportID: "1", Product: "sdk_gphone64_x86_64", Model: "sdk_gphone64_x86_64", DeviceName: "emu64xa", Emulator: true},
+		{Serial: "R58M1234ABC", State: DeviceStateUnauthorized, TransportID: "2", USB: "1-1"},
+	}, devices)
+}
+
+func Test_GivenFakeServer_WhenDeviceInfo_ThenRunsGetpropThroughTheShellService(t *testing.T) {
+	// Given
+	server := newFakeADBServer(t)
+	server.shell = func(cmd string) string {
+		return "[ro.build.version.sdk]: [34]\r\n[ro.product.cpu.abilist]: [x86_64]\r\n"
+	}
+
+	// When
+	info, err := server.client().DeviceInfo("emulator-5554")
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []string{"host:transport:emulator-5554", "shell:getprop"}, server.Requests())
+	require.Equal(t, 34, info.APILevel)
+	require.Equal(t, []string{"x86_64"}, info.ABIs)
+}
+
+func Test_GivenUnknownSerial_WhenDeviceInfo_ThenReturnsServerFailure(t *testing.T) {
+	// Given
+	server := newFakeADBServer(t)
+
+	// When
+	_, err := server.client().DeviceInfo("emulator-5556")
+
+	// Then
+	require.EqualError(t, err, "getprop: host:transport:emulator-5556: device 'emulator-5556' not found")
+}
+
+func Test_GivenLocalDirectory_WhenPushAndPull_ThenFilesAreTransferredAndVerified(t *testing.T) {
+	// Given
+	server := newFakeADBServer(t)
+	server.shell = server.sha256sum
+
+	localDir := filepath.Join(t.TempDir(), "fixtures")
+	require.NoError(t, os.MkdirAll(filepath.Join(localDir, "nested"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(localDir, "data.json"), []byte(`{"key": "value"}`), 0o644))
+	large := strings.Repeat("0123456789", 10000)
+	require.NoError(t, os.WriteFile(filepath.Join(localDir, "nested", "large.txt"), []byte(large), 0o644))
+	var progress []TransferProgress
+
+	// When
+	pushResult, pushErr := server.client().Push("emulator-5554", localDir, "/sdcard/fixtures", TransferOptions{
+		Verify:     HashSHA256,
+		OnProgress: func(p TransferProgress) { progress = append(progress, p) },
+	})
+	pullDir := t.TempDir()
+	pullResult, pullErr := server.client().Pull("emulator-5554", "/sdcard/fixtures", pullDir, TransferOptions{Verify: HashSHA256})
+
+	// Then
+	require.NoError(t, pushErr)
+	require.Equal(t, 2, pushResult.Files)
+	require.Equal(t, int64(16+len(large)), pushResult.Bytes)
+	require.Equal(t, `{"key": "value"}`, string(server.files["/sdcard/fixtures/data.json"]))
+	require.Equal(t, large, string(server.files["/sdcard/fixtures/nested/large.txt"]))
+	require.Contains(t, progress, TransferProgress{Path: "/sdcard/fixtures/nested/large.txt", Percent: 100})
+
+	require.NoError(t, pullErr)
+	require.Equal(t, 2, pullResult.Files)
+	pulled, err := os.ReadFile(filepath.Join(pullDir, "fixtures", "nested", "large.txt"))
+	require.NoError(t, err)
+	require.Equal(t, large, string(pulled))
+}
+
+func Test_GivenExistingRemoteDirectory_WhenPushFile_ThenFileIsCopiedIntoIt(t *testing.T) {
+	// Given
+	server := newFakeADBServer(t)
+	server.files["/sdcard/Download/placeholder"] = nil
+	localFile := filepath.Join(t.TempDir(), "app.apk")
+	require.NoError(t, os.WriteFile(localFile, []byte("apk"), 0o644))
+
+	// When
+	result, err := server.client().Push("emulator-5554", localFile, "/sdcard/Download", TransferOptions{})
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, 1, result.Files)
+	require.Equal(t, "apk", string(server.files["/sdcard/Download/app.apk"]))
+}
+
+func Test_GivenMissingRemoteFile_WhenPull_ThenReturnsError(t *testing.T) {
+	// Given
+	server := newFakeADBServer(t)
+
+	// When
+	_, err := server.client().Pull("emulator-5554", "/sdcard/missing.txt", t.TempDir(), TransferOptions{})
+
+	// Then
+	require.EqualError(t, err, "transfer failed: remote object '/sdcard/missing.txt' does not exist")
+}
+
+func Test_GivenShellV2Device_WhenWireShell_ThenReturnsSeparateOutputsAndExitCode(t *testing.T) {
+	// Given
+	server := newFakeADBServer(t)
+	server.features = "cmd,shell_v2,stat_v2"
+	server.shellV2 = func(cmd string) ShellResult {
+		return ShellResult{Stdout: "out\n", Stderr: "err\n", ExitCode: 3}
+	}
+
+	// When
+	result, err := server.client().Shell(context.Background(), "emulator-5554", "echo", "a b")
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, ShellResult{Stdout: "out\n", Stderr: "err\n", ExitCode: 3}, result)
+	require.Equal(t, []string{"host-serial:emulator-5554:features", "host:transport:emulator-5554", "shell,v2,raw:echo 'a b'"}, server.Requests())
+}
+
+func Test_GivenLegacyDevice_WhenWireShell_ThenExitCodeIsParsedFromSentinel(t *testing.T) {
+	// Given
+	server := newFakeADBServer(t)
+	server.features = "cmd"
+	server.shell = func(cmd string) string {
+		return "out\r\n" + exitCodeSentinel + "1\r\n"
+	}
+
+	// When
+	result, err := server.client().Shell(context.Background(), "emulator-5554", "false")
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, ShellResult{Stdout: "out\n", ExitCode: 1}, result)
+	require.Equal(t, "shell:false ; echo "+exitCodeSentinel+"$?", server.Requests()[2])
+}
+
+// fakeADBServer implements the host and the sync protocol of the adb server for a single device (emulator-5554).
+// Directories are derived from the stored file paths.
+type fakeADBServer struct {
+	listener net.Listener
+	devices  string
+	features string
+	shell    func(cmd string) string
+	shellV2  func(cmd string) ShellResult
+
+	mu       sync.Mutex
+	files    map[string][]byte
+	requests []string
+}
+
+func newFakeADBServer(t *testing.T) *fakeADBServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	server := &fakeADBServer{listener: listener, files: map[string][]byte{}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.handle(conn)
+		}
+	}()
+	return server
+}
+
+func (server *fakeADBServer) client() WireClient {
+	return NewWireClient(server.listener.Addr().(*net.TCPAddr).Port)
+}
+
+func (server *fakeADBServer) Requests() []string {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return append([]string(nil), server.requests...)
+}
+
+func (server *fakeADBServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		request, err := readHostString(conn)
+		if err != nil {
+			return
+		}
+		server.mu.Lock()
+		server.requests = append(server.requests, request)
+		server.mu.Unlock()
+
+		switch {
+		case request == "host:devices-l":
+			fmt.Fprintf(conn, "OKAY%04x%s", len(server.devices), server.devices)
+			return
+		case request == "host-serial:emulator-5554:features":
+			fmt.Fprintf(conn, "OKAY%04x%s", len(server.features), server.features)
+			return
+		case strings.HasPrefix(request, "host:transport:"):
+			if serial := strings.TrimPrefix(request, "host:transport:"); serial != "emulator-5554" {
+				message := fmt.Sprintf("device '%s' not found", serial)
+				fmt.Fprintf(conn, "FAIL%04x%s", len(message), message)
+				return
+			}
+			fmt.Fprint(conn, "OKAY")
+		case strings.HasPrefix(request, "shell:"):
+			fmt.Fprint(conn, "OKAY"+server.shell(strings.TrimPrefix(request, "shell:")))
+			return
+		case strings.HasPrefix(request, "shell,v2,raw:"):
+			fmt.Fprint(conn, "OKAY")
+			server.handleShellV2(conn, strings.TrimPrefix(request, "shell,v2,raw:"))
+			return
+		case request == "sync:":
+			fmt.Fprint(conn, "OKAY")
+			server.handleSync(conn)
+			return
+		default:
+			message := "unknown request"
+			fmt.Fprintf(conn, "FAIL%04x%s", len(message), message)
+			return
+		}
+	}
+}
+
+func (server *fakeADBServer) handleShellV2(conn net.Conn, cmd string) {
+	// close stdin
+	if _, err := io.ReadFull(conn, make([]byte, 5)); err != nil {
+		return
+	}
+
+	result := server.shellV2(cmd)
+	writePacket := func(id byte, data []byte) {
+		header := []byte{id, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint32(header[1:], uint32(len(data)))
+		_, _ = conn.Write(append(header, data...))
+	}
+	writePacket(shellV2Stdout, []byte(result.Stdout))
+	writePacket(shellV2Stderr, []byte(result.Stderr))
+	writePacket(shellV2Exit, []byte{byte(result.ExitCode)})
+}
+
+func (server *fakeADBServer) handleSync(conn net.Conn) {
+	sync := syncConn{conn: conn}
+	for {
+		id, length, err := sync.readHeader()
+		if err != nil || id == "QUIT" {
+			return
+		}
+		arg := make([]byte, length)
+		if _, err := io.ReadFull(conn, arg); err != nil {
+			return
+		}
+
+		switch id {
+		case "STAT":
+			mode, size := server.stat(string(arg))
+			response := make([]byte, 16)
+			copy(response, "STAT")
+			binary.LittleEndian.PutUint32(response[4:], mode)
+			binary.LittleEndian.PutUint32(response[8:], size)
+			_, _ = conn.Write(response)
+		case "LIST":
+			for _, name := range server.children(string(arg)) {
+				mode, size := server.stat(string(arg) + "/" + name)
+				response := make([]byte, 20)
+				copy(response, "DENT")
+				binary.LittleEndian.PutUint32(response[4:], mode)
+				binary.LittleEndian.PutUint32(response[8:], size)
+				binary.LittleEndian.PutUint32(response[16:], uint32(len(name)))
+				_, _ = conn.Write(append(response, name...))
+			}
+			response := make([]byte, 20)
+			copy(response, "DONE")
+			_, _ = conn.Write(response)
+		case "SEND":
+			remotePath, _, _ := strings.Cut(string(arg), ",")
+			var data []byte
+			for {
+				id, length, err := sync.readHeader()
+				if err != nil {
+					return
+				}
+				if id == "DONE" {
+					break
+				}
+				chunk := make([]byte, length)
+				if _, err := io.ReadFull(conn, chunk); err != nil {
+					return
+				}
+				data = append(data, chunk...)
+			}
+			server.mu.Lock()
+			server.files[remotePath] = data
+			server.mu.Unlock()
+			_ = sync.send("OKAY", 0, nil)
+		case "RECV":
+			server.mu.Lock()
+			data := server.files[string(arg)]
+			server.mu.Unlock()
+			for len(data) > 0 {
+				n := min(len(data), syncMaxChunkSize)
+				_ = sync.send("DATA", uint32(n), data[:n])
+				data = data[n:]
+			}
+			_ = sync.send("DONE", 0, nil)
+		}
+	}
+}
+
+func (server *fakeADBServer) stat(pth string) (uint32, uint32) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	if data, ok := server.files[pth]; ok {
+		return syncModeRegular | 0o644, uint32(len(data))
+	}
+	for name := range server.files {
+		if strings.HasPrefix(name, pth+"/") {
+			return syncModeDir | 0o755, 0
+		}
+	}
+	return 0, 0
+}
+
+func (server *fakeADBServer) children(dir string) []string {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	seen := map[string]bool{}
+	for name := range server.files {
+		if rel, ok := strings.CutPrefix(name, dir+"/"); ok {
+			child, _, _ := strings.Cut(rel, "/")
+			seen[child] = true
+		}
+	}
+	children := []string{".", ".."}
+	for child := range seen {
+		children = append(children, child)
+	}
+	sort.Strings(children[2:])
+	return children
+}
+
+// sha256sum answers `find '<dir>' -type f -exec sha256sum {} +`.
+func (server *fakeADBServer) sha256sum(cmd string) string {
+	dir := strings.Trim(strings.Fields(cmd)[1], "'")
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	var lines []string
+	for name, data := range server.files {
+		if strings.HasPrefix(name, dir+"/") {
+			sum := sha256.Sum256(data)
+			lines = append(lines, hex.EncodeToString(sum[:])+"  "+name)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
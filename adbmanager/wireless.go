@@ -0,0 +1,190 @@
+package adbmanager
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+)
+
+// WirelessOutcome classifies the result of `adb pair`, `adb connect` and `adb disconnect`.
+type WirelessOutcome string
+
+// Wireless outcomes ...
+const (
+	WirelessOutcomePaired           WirelessOutcome = "paired"
+	WirelessOutcomeConnected        WirelessOutcome = "connected"
+	WirelessOutcomeAlreadyConnected WirelessOutcome = "already connected"
+	WirelessOutcomeDisconnected     WirelessOutcome = "disconnected"
+	// WirelessOutcomeRefused means nothing listens on the port, for example because wireless debugging is turned off.
+	WirelessOutcomeRefused WirelessOutcome = "refused"
+	// WirelessOutcomeAuthFailed means a wrong pairing code, or a connection to a device which is not paired with this host.
+	WirelessOutcomeAuthFailed WirelessOutcome = "auth failed"
+	// WirelessOutcomeUnreachable means the host could not be resolved or reached in time.
+	WirelessOutcomeUnreachable WirelessOutcome = "unreachable"
+	// WirelessOutcomeNotConnected means the disconnected device was not connected.
+	WirelessOutcomeNotConnected WirelessOutcome = "not connected"
+	WirelessOutcomeFailed       WirelessOutcome = "failed"
+)
+
+// mDNS service types advertised by devices, see: https://developer.android.com/tools/adb#connect-to-a-device-over-wi-fi
+const (
+	MDNSServiceTLSPairing = "_adb-tls-pairing._tcp"
+	MDNSServiceTLSConnect = "_adb-tls-connect._tcp"
+	// MDNSServiceConnect is advertised by devices listening for legacy (`adb tcpip`) connections.
+	MDNSServiceConnect = "_adb._tcp"
+)
+
+// WirelessError is returned when `adb pair`, `adb connect` or `adb disconnect` failed.
+type WirelessError struct {
+	Outcome WirelessOutcome
+	Address string
+	// Output is the complete adb output.
+	Output string
+}
+
+func (err *WirelessError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", err.Address, err.Outcome, err.Output)
+}
+
+// MDNSService is an entry of `adb mdns services`.
+type MDNSService struct {
+	// Name is the instance name, adb-<serial>-<random> for wireless debugging services.
+	Name string
+	Type string
+	// Address is the host:port of the service.
+	Address string
+}
+
+// PairCmd builds and returns a `Command` pairing with a device using the six digit code shown by the device
+// (Android 11+). The command line contains the code, so it should not be logged.
+func (model Model) PairCmd(address, code string, commandOptions *command.Opts) command.Command {
+	return model.adbCmd([]string{"pair", address, code}, commandOptions)
+}
+
+// Pair pairs with the device at the pairing address (host:port), a WirelessError is returned on failure.
+func (model Model) Pair(address, code string) error {
+	out, err := model.PairCmd(address, code, nil).RunAndReturnTrimmedCombinedOutput()
+	if outcome := classifyWirelessOutput(out); outcome != WirelessOutcomePaired {
+		return &WirelessError{Outcome: outcome, Address: address, Output: outputOrError(out, err)}
+	}
+	return nil
+}
+
+// Connect connects to the device at the connect address (host:port), it returns WirelessOutcomeConnected or
+// WirelessOutcomeAlreadyConnected, a WirelessError is returned on failure.
+func (model Model) Connect(address string) (WirelessOutcome, error) {
+	cmd := model.adbCmd([]string{"connect", address}, nil)
+	model.logger.Printf("$ %s", cmd.PrintableCommandArgs())
+	// older versions exit with 0 even if the connection failed
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	switch outcome := classifyWirelessOutput(out); outcome {
+	case WirelessOutcomeConnected, WirelessOutcomeAlreadyConnected:
+		return outcome, nil
+	default:
+		return "", &WirelessError{Outcome: outcome, Address: address, Output: outputOrError(out, err)}
+	}
+}
+
+// Disconnect disconnects the device at the given address, every device connected over the network is disconnected
+// if address is empty. A WirelessError is returned on failure.
+func (model Model) Disconnect(address string) error {
+	args := []string{"disconnect"}
+	if address != "" {
+		args = append(args, address)
+	}
+	cmd := model.adbCmd(args, nil)
+	model.logger.Printf("$ %s", cmd.PrintableCommandArgs())
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if outcome := classifyWirelessOutput(out); outcome != WirelessOutcomeDisconnected {
+		return &WirelessError{Outcome: outcome, Address: address, Output: outputOrError(out, err)}
+	}
+	return nil
+}
+
+// MDNSServices returns the adb services discovered by the adb server on the local network (`adb mdns services`).
+func (model Model) MDNSServices() ([]MDNSService, error) {
+	cmd := model.adbCmd([]string{"mdns", "services"}, nil)
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("mdns services: %s: %w", out, err)
+	}
+	return parseMDNSServices(out), nil
+}
+
+func outputOrError(out string, err error) string {
+	if out == "" && err != nil {
+		return err.Error()
+	}
+	return out
+}
+
+// classifyWirelessOutput classifies the output of `adb pair`, `adb connect` and `adb disconnect`:
+//
+// Successfully paired to 192.168.1.5:37099 [guid=adb-R58M1234ABC-xyz12]
+// already connected to 192.168.1.5:37521
+// failed to connect to '192.168.1.5:37521': Connection refused
+// failed to authenticate to 192.168.1.5:37521
+// error: no such device '192.168.1.5:37521'
+//
+// The output is classified line by line, the lines printed by adb when it starts the server are skipped.
+func classifyWirelessOutput(out string) WirelessOutcome {
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "*") { // * daemon not running; starting now at tcp:5037
+			continue
+		}
+		if outcome := classifyWirelessLine(line); outcome != WirelessOutcomeFailed {
+			return outcome
+		}
+	}
+	return WirelessOutcomeFailed
+}
+
+func classifyWirelessLine(line string) WirelessOutcome {
+	lower := strings.ToLower(line)
+	switch {
+	case strings.Contains(lower, "successfully paired"):
+		return WirelessOutcomePaired
+	case strings.Contains(lower, "already connected"):
+		return WirelessOutcomeAlreadyConnected
+	case strings.HasPrefix(lower, "connected to"):
+		return WirelessOutcomeConnected
+	case strings.HasPrefix(lower, "disconnected"):
+		return WirelessOutcomeDisconnected
+	case strings.Contains(lower, "refused"):
+		return WirelessOutcomeRefused
+	case strings.Contains(lower, "failed to authenticate"),
+		strings.Contains(lower, "wrong password"),
+		strings.Contains(lower, "unauthorized"):
+		return WirelessOutcomeAuthFailed
+	case strings.Contains(lower, "no such device"):
+		return WirelessOutcomeNotConnected
+	case strings.Contains(lower, "timed out"),
+		strings.Contains(lower, "no route to host"),
+		strings.Contains(lower, "unreachable"),
+		strings.Contains(lower, "cannot resolve host"),
+		strings.Contains(lower, "name or service not known"):
+		return WirelessOutcomeUnreachable
+	}
+	return WirelessOutcomeFailed
+}
+
+// List of discovered mdns services
+// adb-R58M1234ABC-xyz12	_adb-tls-connect._tcp	192.168.1.5:37521
+func parseMDNSServices(out string) []MDNSService {
+	var services []MDNSService
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || !strings.HasPrefix(fields[1], "_") {
+			continue
+		}
+		services = append(services, MDNSService{
+			Name: fields[0],
+			// some versions print the fully qualified type: _adb-tls-connect._tcp.
+			Type:    strings.TrimSuffix(fields[1], "."),
+			Address: fields[2],
+		})
+	}
+	return services
+}
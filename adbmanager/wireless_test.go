@@ -0,0 +1,143 @@
+package adbmanager
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GivenPairingCode_WhenPair_ThenPairsWithDevice(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: "Successfully paired to 192.168.1.5:37099 [guid=adb-R58M1234ABC-xyz12]"}
+	})
+
+	// When
+	err := mockModelWithFactory(factory).Pair("192.168.1.5:37099", "482913")
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"pair", "192.168.1.5:37099", "482913"}}, factory.Calls())
+}
+
+func Test_GivenWrongPairingCode_WhenPair_ThenReturnsAuthFailed(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: "Failed: Wrong password or connection was dropped.", exitCode: 1}
+	})
+
+	// When
+	err := mockModelWithFactory(factory).Pair("192.168.1.5:37099", "000000")
+
+	// Then
+	var wirelessErr *WirelessError
+	require.True(t, errors.As(err, &wirelessErr))
+	require.Equal(t, WirelessOutcomeAuthFailed, wirelessErr.Outcome)
+	require.EqualError(t, err, "192.168.1.5:37099: auth failed: Failed: Wrong password or connection was dropped.")
+}
+
+func Test_GivenAlreadyConnectedDevice_WhenConnect_ThenReturnsOutcome(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: "already connected to 192.168.1.5:37521"}
+	})
+
+	// When
+	outcome, err := mockModelWithFactory(factory).WithServerPort(5041).Connect("192.168.1.5:37521")
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, WirelessOutcomeAlreadyConnected, outcome)
+	require.Equal(t, [][]string{{"-P", "5041", "connect", "192.168.1.5:37521"}}, factory.Calls())
+}
+
+func Test_GivenServerNotRunning_WhenConnect_ThenReturnsConnected(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: "* daemon not running; starting now at tcp:5041\n* daemon started successfully\nconnected to 192.168.1.5:37521"}
+	})
+
+	// When
+	outcome, err := mockModelWithFactory(factory).WithServerPort(5041).Connect("192.168.1.5:37521")
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, WirelessOutcomeConnected, outcome)
+}
+
+func Test_GivenRefusedConnectionWithSuccessfulExitCode_WhenConnect_ThenReturnsError(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: "failed to connect to '192.168.1.5:37521': Connection refused"}
+	})
+
+	// When
+	_, err := mockModelWithFactory(factory).Connect("192.168.1.5:37521")
+
+	// Then
+	var wirelessErr *WirelessError
+	require.True(t, errors.As(err, &wirelessErr))
+	require.Equal(t, WirelessOutcomeRefused, wirelessErr.Outcome)
+}
+
+func Test_GivenNoAddress_WhenDisconnect_ThenDisconnectsEverything(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: "disconnected everything"}
+	})
+
+	// When
+	err := mockModelWithFactory(factory).Disconnect("")
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"disconnect"}}, factory.Calls())
+}
+
+func Test_GivenMDNSServices_WhenMDNSServices_ThenReturnsParsedServices(t *testing.T) {
+	// Given
+	factory := newFakeCommandFactory(func(args []string) fakeResult {
+		return fakeResult{stdout: "List of discovered mdns services\n" +
+			"adb-R58M1234ABC-xyz12\t_adb-tls-connect._tcp\t192.168.1.5:37521\n" +
+			"adb-R58M1234ABC-xyz12\t_adb-tls-pairing._tcp.\t192.168.1.5:37099\n"}
+	})
+
+	// When
+	services, err := mockModelWithFactory(factory).MDNSServices()
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"mdns", "services"}}, factory.Calls())
+	require.Equal(t, []MDNSService{
+		{Name: "adb-R58M1234ABC-xyz12", Type: MDNSServiceTLSConnect, Address: "192.168.1.5:37521"},
+		{Name: "adb-R58M1234ABC-xyz12", Type: MDNSServiceTLSPairing, Address: "192.168.1.5:37099"},
+	}, services)
+}
+
+func Test_classifyWirelessOutput(t *testing.T) {
+	tests := []struct {
+		out  string
+		want WirelessOutcome
+	}{
+		{out: "connected to 192.168.1.5:5555", want: WirelessOutcomeConnected},
+		{out: "cannot connect to 192.168.1.5:5555: Connection refused (111)", want: WirelessOutcomeRefused},
+		{out: "failed to authenticate to 192.168.1.5:37521", want: WirelessOutcomeAuthFailed},
+		{out: "failed to connect to '192.168.1.5:37521': Connection timed out", want: WirelessOutcomeUnreachable},
+		{out: "cannot connect to 192.168.1.99:5555: No route to host (113)", want: WirelessOutcomeUnreachable},
+		{out: "failed to resolve host: 'pixel.local': Name or service not known", want: WirelessOutcomeUnreachable},
+		{out: "disconnected 192.168.1.5:5555", want: WirelessOutcomeDisconnected},
+		{out: "error: no such device '192.168.1.5:5555'", want: WirelessOutcomeNotConnected},
+		{out: "error: protocol fault (couldn't read status message): Success", want: WirelessOutcomeFailed},
+		{out: "* daemon not running; starting now at tcp:5037\n* daemon started successfully\nconnected to 192.168.1.5:5555", want: WirelessOutcomeConnected},
+		{out: "* daemon not running; starting now at tcp:5037\r\n* daemon started successfully\r\ndisconnected everything", want: WirelessOutcomeDisconnected},
+		{out: "* daemon not running; starting now at tcp:5037\n* daemon started successfully", want: WirelessOutcomeFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.out, func(t *testing.T) {
+			if got := classifyWirelessOutput(tt.out); got != tt.want {
+				t.Errorf("classifyWirelessOutput() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package avd
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Environment is used to pass in environment variables used to locate the AVD home directory
+type Environment struct {
+	AVDHome         string // ANDROID_AVD_HOME
+	AndroidUserHome string // ANDROID_USER_HOME
+	AndroidSDKHome  string // ANDROID_SDK_HOME, deprecated
+	Home            string // HOME
+}
+
+// NewEnvironment gets needed environment variables
+func NewEnvironment() *Environment {
+	return &Environment{
+		AVDHome:         os.Getenv("ANDROID_AVD_HOME"),
+		AndroidUserHome: os.Getenv("ANDROID_USER_HOME"),
+		AndroidSDKHome:  os.Getenv("ANDROID_SDK_HOME"),
+		Home:            os.Getenv("HOME"),
+	}
+}
+
+// HomeDir returns the directory where the AVDs are stored.
+// See: https://developer.android.com/tools/variables#envar
+func HomeDir(envs Environment) string {
+	switch {
+	case envs.AVDHome != "":
+		return envs.AVDHome
+	case envs.AndroidUserHome != "":
+		return filepath.Join(envs.AndroidUserHome, "avd")
+	case envs.AndroidSDKHome != "":
+		return filepath.Join(envs.AndroidSDKHome, ".android", "avd")
+	default:
+		return filepath.Join(envs.Home, ".android", "avd")
+	}
+}
+
+// AVD locates the files of an Android Virtual Device.
+type AVD struct {
+	Name string
+	// Home is the AVD home directory, see HomeDir.
+	Home string
+}
+
+// Dir is the `<name>.avd` directory containing the AVD's config and disk images.
+func (avd AVD) Dir() string {
+	return filepath.Join(avd.Home, avd.Name+".avd")
+}
+
+// ConfigPath is the path of the AVD's `config.ini`.
+func (avd AVD) ConfigPath() string {
+	return filepath.Join(avd.Dir(), "config.ini")
+}
+
+// PointerPath is the path of the top-level `<name>.ini` file pointing to the AVD's directory.
+func (avd AVD) PointerPath() string {
+	return filepath.Join(avd.Home, avd.Name+".ini")
+}
+
+// ReadConfig ...
+func (avd AVD) ReadConfig() (*Config, error) {
+	return ReadConfig(avd.ConfigPath())
+}
+
+// WriteConfig ...
+func (avd AVD) WriteConfig(config *Config) error {
+	if err := os.MkdirAll(avd.Dir(), 0755); err != nil {
+		return err
+	}
+	return config.WriteFile(avd.ConfigPath())
+}
+
+// ReadPointer ...
+func (avd AVD) ReadPointer() (*Pointer, error) {
+	return ReadPointer(avd.PointerPath())
+}
+
+// WritePointer ...
+func (avd AVD) WritePointer(pointer *Pointer) error {
+	if err := os.MkdirAll(avd.Home, 0755); err != nil {
+		return err
+	}
+	return pointer.WriteFile(avd.PointerPath())
+}
@@ -0,0 +1,468 @@
+package avd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-android/v2/sdkcomponent"
+)
+
+// Config keys, see: https://android.googlesource.com/platform/external/qemu/+/refs/heads/master/android/avd/hardware-properties.ini
+const (
+	keyAVDID             = "AvdId"
+	keyDisplayName       = "avd.ini.displayname"
+	keyABIType           = "abi.type"
+	keyCPUArch           = "hw.cpu.arch"
+	keyCPUCores          = "hw.cpu.ncore"
+	keyImageSysdir       = "image.sysdir.1"
+	keyTagID             = "tag.id"
+	keyTagDisplay        = "tag.display"
+	keyDeviceName        = "hw.device.name"
+	keyRAMSize           = "hw.ramSize"
+	keyVMHeapSize        = "vm.heapSize"
+	keyLCDWidth          = "hw.lcd.width"
+	keyLCDHeight         = "hw.lcd.height"
+	keyLCDDensity        = "hw.lcd.density"
+	keyKeyboard          = "hw.keyboard"
+	keyGPUEnabled        = "hw.gpu.enabled"
+	keyGPUMode           = "hw.gpu.mode"
+	keyDataPartitionSize = "disk.dataPartition.size"
+	keySDCardSize        = "sdcard.size"
+)
+
+// Config is the typed model of an AVD's config.ini.
+//
+// Only the commonly tuned keys have typed fields, every other key is available through Get and Set.
+// When writing the config, zero valued (or nil) fields leave the corresponding key untouched,
+// unknown keys and the original key order are preserved.
+type Config struct {
+	AVDID       string
+	DisplayName string
+	ABIType     string
+	CPUArch     string
+	CPUCores    int
+	// ImageSysdir is the system image directory, relative to the Android SDK root.
+	ImageSysdir string
+	TagID       string
+	TagDisplay  string
+	DeviceName  string
+	// RAMSize is the device RAM size in MB.
+	RAMSize int
+	// VMHeapSize is the max heap size of a single app in MB.
+	VMHeapSize int
+	LCDWidth   int
+	LCDHeight  int
+	LCDDensity int
+	Keyboard   *bool
+	GPUEnabled *bool
+	GPUMode    string
+	// DataPartitionSize is the size of the userdata partition, for example 6G or 6442450944 (bytes).
+	DataPartitionSize string
+	SDCardSize        string
+
+	ini *IniFile
+}
+
+// NewConfig returns an empty config.
+func NewConfig() *Config {
+	return &Config{ini: &IniFile{}}
+}
+
+// ParseConfig ...
+func ParseConfig(r io.Reader) (*Config, error) {
+	ini, err := ParseIni(r)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{ini: ini}
+	parser := iniFieldParser{ini: ini}
+	for _, key := range typedKeys {
+		config.loadField(&parser, key)
+	}
+	if parser.err != nil {
+		return nil, parser.err
+	}
+
+	return config, nil
+}
+
+// ReadConfig ...
+func ReadConfig(pth string) (*Config, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	config, err := ParseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", pth, err)
+	}
+	return config, nil
+}
+
+// Get returns the raw value of any config key. Typed fields are not synced to the raw values until the config is written.
+func (config *Config) Get(key string) (string, bool) {
+	return config.ini.Get(key)
+}
+
+// Set sets the raw value of any config key, and updates the typed field of the key. If the value can't be parsed,
+// the typed field is reset to its zero value and the raw value is written.
+func (config *Config) Set(key, value string) {
+	config.ini.Set(key, value)
+	config.loadField(&iniFieldParser{ini: config.ini}, key)
+}
+
+// Delete removes the key and resets its typed field.
+func (config *Config) Delete(key string) {
+	config.ini.Delete(key)
+	config.loadField(&iniFieldParser{ini: config.ini}, key)
+}
+
+// typedKeys are the keys with a typed Config field.
+var typedKeys = []string{
+	keyAVDID, keyDisplayName, keyABIType, keyCPUArch, keyCPUCores, keyImageSysdir, keyTagID, keyTagDisplay,
+	keyDeviceName, keyRAMSize, keyVMHeapSize, keyLCDWidth, keyLCDHeight, keyLCDDensity, keyKeyboard, keyGPUEnabled,
+	keyGPUMode, keyDataPartitionSize, keySDCardSize,
+}
+
+// loadField sets the typed field of the key from the raw value, keys without a typed field are ignored.
+func (config *Config) loadField(parser *iniFieldParser, key string) {
+	switch key {
+	case keyAVDID:
+		config.AVDID = parser.string(key)
+	case keyDisplayName:
+		config.DisplayName = parser.string(key)
+	case keyABIType:
+		config.ABIType = parser.string(key)
+	case keyCPUArch:
+		config.CPUArch = parser.string(key)
+	case keyCPUCores:
+		config.CPUCores = parser.int(key)
+	case keyImageSysdir:
+		config.ImageSysdir = parser.string(key)
+	case keyTagID:
+		config.TagID = parser.string(key)
+	case keyTagDisplay:
+		config.TagDisplay = parser.string(key)
+	case keyDeviceName:
+		config.DeviceName = parser.string(key)
+	case keyRAMSize:
+		config.RAMSize = parser.megabytes(key)
+	case keyVMHeapSize:
+		config.VMHeapSize = parser.megabytes(key)
+	case keyLCDWidth:
+		config.LCDWidth = parser.int(key)
+	case keyLCDHeight:
+		config.LCDHeight = parser.int(key)
+	case keyLCDDensity:
+		config.LCDDensity = parser.int(key)
+	case keyKeyboard:
+		config.Keyboard = parser.bool(key)
+	case keyGPUEnabled:
+		config.GPUEnabled = parser.bool(key)
+	case keyGPUMode:
+		config.GPUMode = parser.string(key)
+	case keyDataPartitionSize:
+		config.DataPartitionSize = parser.string(key)
+	case keySDCardSize:
+		config.SDCardSize = parser.string(key)
+	}
+}
+
+// SetSystemImage points the AVD to the given system image and updates the ABI and tag related keys accordingly.
+func (config *Config) SetSystemImage(image sdkcomponent.SystemImage) {
+	tag := image.Tag
+	if tag == "" {
+		tag = "default"
+	}
+
+	// avdmanager writes the sysdir with a trailing separator
+	config.ImageSysdir = filepath.ToSlash(image.InstallPathInAndroidHome()) + "/"
+	config.ABIType = image.ABI
+	config.CPUArch = cpuArch(image.ABI)
+	config.TagID = tag
+	config.TagDisplay = tagDisplay(tag)
+}
+
+// SystemImage returns the system image component the AVD is based on.
+func (config *Config) SystemImage() (sdkcomponent.SystemImage, error) {
+	sysdir := strings.Trim(filepath.ToSlash(config.ImageSysdir), "/")
+	parts := strings.Split(sysdir, "/")
+	if len(parts) != 4 || parts[0] != "system-images" {
+		return sdkcomponent.SystemImage{}, fmt.Errorf("unexpected %s: %s", keyImageSysdir, config.ImageSysdir)
+	}
+
+	return sdkcomponent.SystemImage{
+		Platform: parts[1],
+		Tag:      parts[2],
+		ABI:      parts[3],
+	}, nil
+}
+
+// WriteTo writes the config in config.ini format.
+func (config *Config) WriteTo(w io.Writer) (int64, error) {
+	writer := iniFieldWriter{ini: config.ini}
+	writer.string(keyAVDID, config.AVDID)
+	writer.string(keyDisplayName, config.DisplayName)
+	writer.string(keyABIType, config.ABIType)
+	writer.string(keyCPUArch, config.CPUArch)
+	writer.int(keyCPUCores, config.CPUCores)
+	writer.string(keyImageSysdir, config.ImageSysdir)
+	writer.string(keyTagID, config.TagID)
+	writer.string(keyTagDisplay, config.TagDisplay)
+	writer.string(keyDeviceName, config.DeviceName)
+	writer.megabytes(keyRAMSize, config.RAMSize)
+	writer.megabytes(keyVMHeapSize, config.VMHeapSize)
+	writer.int(keyLCDWidth, config.LCDWidth)
+	writer.int(keyLCDHeight, config.LCDHeight)
+	writer.int(keyLCDDensity, config.LCDDensity)
+	writer.bool(keyKeyboard, config.Keyboard)
+	writer.bool(keyGPUEnabled, config.GPUEnabled)
+	writer.string(keyGPUMode, config.GPUMode)
+	writer.string(keyDataPartitionSize, config.DataPartitionSize)
+	writer.string(keySDCardSize, config.SDCardSize)
+
+	return config.ini.WriteTo(w)
+}
+
+// WriteFile ...
+func (config *Config) WriteFile(pth string) error {
+	var buf bytes.Buffer
+	if _, err := config.WriteTo(&buf); err != nil {
+		return err
+	}
+	return os.WriteFile(pth, buf.Bytes(), 0644)
+}
+
+// Pointer is the model of the top-level `<name>.ini` file, which points to the AVD's directory.
+type Pointer struct {
+	Encoding string
+	// Path is the absolute path of the `<name>.avd` directory.
+	Path string
+	// PathRel is the path of the `<name>.avd` directory relative to the parent of the AVD home (~/.android).
+	PathRel string
+	// Target is the platform of the system image, for example android-33.
+	Target string
+
+	ini *IniFile
+}
+
+// NewPointer returns the pointer file model for the given AVD based on the given system image.
+func NewPointer(avd AVD, image sdkcomponent.SystemImage) *Pointer {
+	return &Pointer{
+		Encoding: "UTF-8",
+		Path:     avd.Dir(),
+		PathRel:  filepath.ToSlash(filepath.Join("avd", avd.Name+".avd")),
+		Target:   image.Platform,
+		ini:      &IniFile{},
+	}
+}
+
+// ParsePointer ...
+func ParsePointer(r io.Reader) (*Pointer, error) {
+	ini, err := ParseIni(r)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := iniFieldParser{ini: ini}
+	return &Pointer{
+		Encoding: parser.string("avd.ini.encoding"),
+		Path:     parser.string("path"),
+		PathRel:  parser.string("path.rel"),
+		Target:   parser.string("target"),
+		ini:      ini,
+	}, nil
+}
+
+// ReadPointer ...
+func ReadPointer(pth string) (*Pointer, error) {
+	f, err := os.Open(pth)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	pointer, err := ParsePointer(f)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", pth, err)
+	}
+	return pointer, nil
+}
+
+// WriteTo writes the pointer file in ini format.
+func (pointer *Pointer) WriteTo(w io.Writer) (int64, error) {
+	writer := iniFieldWriter{ini: pointer.ini}
+	writer.string("avd.ini.encoding", pointer.Encoding)
+	writer.string("path", pointer.Path)
+	writer.string("path.rel", pointer.PathRel)
+	writer.string("target", pointer.Target)
+
+	return pointer.ini.WriteTo(w)
+}
+
+// WriteFile ...
+func (pointer *Pointer) WriteFile(pth string) error {
+	var buf bytes.Buffer
+	if _, err := pointer.WriteTo(&buf); err != nil {
+		return err
+	}
+	return os.WriteFile(pth, buf.Bytes(), 0644)
+}
+
+// tagDisplay returns the display name of a system image tag, as written by avdmanager.
+func tagDisplay(tag string) string {
+	switch tag {
+	case "default":
+		return "Default Android System Image"
+	case "google_apis":
+		return "Google APIs"
+	case "google_apis_playstore":
+		return "Google Play"
+	case "google_atd":
+		return "Google APIs ATD"
+	case "aosp_atd":
+		return "AOSP ATD"
+	case "android-tv":
+		return "Android TV"
+	case "google-tv":
+		return "Google TV"
+	case "android-wear":
+		return "Wear OS"
+	case "android-automotive":
+		return "Android Automotive"
+	}
+
+	words := strings.FieldsFunc(tag, func(r rune) bool { return r == '_' || r == '-' })
+	for i, word := range words {
+		words[i] = strings.ToUpper(word[:1]) + word[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+// cpuArch maps a system image ABI to the emulator's hw.cpu.arch value.
+func cpuArch(abi string) string {
+	switch abi {
+	case "arm64-v8a":
+		return "arm64"
+	case "armeabi-v7a", "armeabi":
+		return "arm"
+	default:
+		return abi
+	}
+}
+
+// iniFieldParser reads typed values from an ini file and keeps the first error.
+type iniFieldParser struct {
+	ini *IniFile
+	err error
+}
+
+func (parser *iniFieldParser) string(key string) string {
+	value, _ := parser.ini.Get(key)
+	return value
+}
+
+func (parser *iniFieldParser) int(key string) int {
+	value, ok := parser.ini.Get(key)
+	if !ok || value == "" {
+		return 0
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil && parser.err == nil {
+		parser.err = fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return i
+}
+
+func (parser *iniFieldParser) megabytes(key string) int {
+	value, ok := parser.ini.Get(key)
+	if !ok || value == "" {
+		return 0
+	}
+
+	mb, err := parseMegabytes(value)
+	if err != nil && parser.err == nil {
+		parser.err = fmt.Errorf("invalid %s: %s", key, value)
+	}
+	return mb
+}
+
+func (parser *iniFieldParser) bool(key string) *bool {
+	value, ok := parser.ini.Get(key)
+	if !ok {
+		return nil
+	}
+
+	b := strings.EqualFold(value, "yes") || strings.EqualFold(value, "true")
+	return &b
+}
+
+// iniFieldWriter writes typed values into an ini file, zero values leave the key untouched.
+type iniFieldWriter struct {
+	ini *IniFile
+}
+
+func (writer iniFieldWriter) string(key, value string) {
+	if value != "" {
+		writer.ini.Set(key, value)
+	}
+}
+
+func (writer iniFieldWriter) int(key string, value int) {
+	if value != 0 {
+		writer.ini.Set(key, strconv.Itoa(value))
+	}
+}
+
+func (writer iniFieldWriter) megabytes(key string, value int) {
+	if value == 0 {
+		return
+	}
+	// keep the original notation (2048, 2048M, 2G) if the value is unchanged
+	if current, ok := writer.ini.Get(key); ok {
+		if mb, err := parseMegabytes(current); err == nil && mb == value {
+			return
+		}
+	}
+	writer.ini.Set(key, strconv.Itoa(value)+"M")
+}
+
+// parseMegabytes parses sizes like 2048, 2048M, 2048MB or 2G into MB.
+func parseMegabytes(value string) (int, error) {
+	multiplier := 1
+	number := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(value), "B"), "M")
+	if gigabytes, found := strings.CutSuffix(number, "G"); found {
+		number = gigabytes
+		multiplier = 1024
+	}
+
+	mb, err := strconv.Atoi(number)
+	if err != nil {
+		return 0, err
+	}
+	return mb * multiplier, nil
+}
+
+func (writer iniFieldWriter) bool(key string, value *bool) {
+	if value == nil {
+		return
+	}
+	if *value {
+		writer.ini.Set(key, "yes")
+	} else {
+		writer.ini.Set(key, "no")
+	}
+}
@@ -0,0 +1,150 @@
+package avd
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bitrise-io/go-android/v2/sdkcomponent"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConfig(t *testing.T) {
+	config, err := ParseConfig(strings.NewReader(pixelConfig))
+	require.NoError(t, err)
+
+	require.Equal(t, "Pixel_5_API_33", config.AVDID)
+	require.Equal(t, "x86_64", config.ABIType)
+	require.Equal(t, 2048, config.RAMSize)
+	require.Equal(t, 256, config.VMHeapSize)
+	require.Equal(t, 1080, config.LCDWidth)
+	require.Equal(t, 2340, config.LCDHeight)
+	require.Equal(t, 440, config.LCDDensity)
+	require.Equal(t, 4, config.CPUCores)
+	require.NotNil(t, config.Keyboard)
+	require.True(t, *config.Keyboard)
+	require.Equal(t, "6442450944", config.DataPartitionSize)
+
+	image, err := config.SystemImage()
+	require.NoError(t, err)
+	require.Equal(t, sdkcomponent.SystemImage{Platform: "android-33", Tag: "google_apis", ABI: "x86_64"}, image)
+
+	value, ok := config.Get("fastboot.forceColdBoot")
+	require.True(t, ok)
+	require.Equal(t, "no", value)
+}
+
+func TestParseConfig_InvalidValue(t *testing.T) {
+	_, err := ParseConfig(strings.NewReader("hw.ramSize=lots\n"))
+	require.EqualError(t, err, "invalid hw.ramSize: lots")
+}
+
+func TestConfig_WriteTo_PreservesUnknownKeysAndOrder(t *testing.T) {
+	config, err := ParseConfig(strings.NewReader(pixelConfig))
+	require.NoError(t, err)
+
+	keyboard := false
+	config.RAMSize = 4096
+	config.Keyboard = &keyboard
+	config.DataPartitionSize = "8G"
+	config.SetSystemImage(sdkcomponent.SystemImage{Platform: "android-34", ABI: "arm64-v8a"})
+	config.Set("hw.camera.back", "emulated")
+
+	var buf bytes.Buffer
+	_, err = config.WriteTo(&buf)
+	require.NoError(t, err)
+
+	expected := strings.NewReplacer(
+		"hw.ramSize=2048\n", "hw.ramSize=4096M\n",
+		"hw.keyboard=yes\n", "hw.keyboard=no\n",
+		"disk.dataPartition.size=6442450944\n", "disk.dataPartition.size=8G\n",
+		"image.sysdir.1=system-images/android-33/google_apis/x86_64/\n", "image.sysdir.1=system-images/android-34/default/arm64-v8a/\n",
+		"abi.type=x86_64\n", "abi.type=arm64-v8a\n",
+		"hw.cpu.arch=x86_64\n", "hw.cpu.arch=arm64\n",
+		"tag.id=google_apis\n", "tag.id=default\n",
+		"tag.display=Google APIs\n", "tag.display=Default Android System Image\n",
+	).Replace(pixelConfig) + "hw.camera.back=emulated\n"
+	require.Equal(t, expected, buf.String())
+}
+
+func TestConfig_Set_UpdatesTypedField(t *testing.T) {
+	config, err := ParseConfig(strings.NewReader(pixelConfig))
+	require.NoError(t, err)
+
+	config.Set("abi.type", "arm64-v8a")
+	config.Set("hw.ramSize", "3G")
+	config.Delete("hw.keyboard")
+
+	require.Equal(t, "arm64-v8a", config.ABIType)
+	require.Equal(t, 3072, config.RAMSize)
+	require.Nil(t, config.Keyboard)
+
+	var buf bytes.Buffer
+	_, err = config.WriteTo(&buf)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "abi.type=arm64-v8a\n")
+	require.Contains(t, buf.String(), "hw.ramSize=3G\n")
+	require.NotContains(t, buf.String(), "hw.keyboard")
+}
+
+func TestConfig_WriteTo_KeepsUnchangedSizeNotation(t *testing.T) {
+	config, err := ParseConfig(strings.NewReader("hw.ramSize=2G\nvm.heapSize=256M\n"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	_, err = config.WriteTo(&buf)
+	require.NoError(t, err)
+	require.Equal(t, "hw.ramSize=2G\nvm.heapSize=256M\n", buf.String())
+}
+
+func TestAVD_WriteAndReadBack(t *testing.T) {
+	avd := AVD{Name: "Pixel_5_API_33", Home: t.TempDir()}
+	image := sdkcomponent.SystemImage{Platform: "android-33", Tag: "google_apis", ABI: "x86_64"}
+
+	config := NewConfig()
+	config.AVDID = avd.Name
+	config.SetSystemImage(image)
+	require.NoError(t, avd.WriteConfig(config))
+	require.NoError(t, avd.WritePointer(NewPointer(avd, image)))
+
+	readConfig, err := avd.ReadConfig()
+	require.NoError(t, err)
+	require.Equal(t, "system-images/android-33/google_apis/x86_64/", readConfig.ImageSysdir)
+	require.Equal(t, "x86_64", readConfig.CPUArch)
+
+	pointer, err := avd.ReadPointer()
+	require.NoError(t, err)
+	require.Equal(t, "UTF-8", pointer.Encoding)
+	require.Equal(t, filepath.Join(avd.Home, "Pixel_5_API_33.avd"), pointer.Path)
+	require.Equal(t, "avd/Pixel_5_API_33.avd", pointer.PathRel)
+	require.Equal(t, "android-33", pointer.Target)
+}
+
+func TestHomeDir(t *testing.T) {
+	require.Equal(t, "/avd-home", HomeDir(Environment{AVDHome: "/avd-home", AndroidUserHome: "/user-home", Home: "/home"}))
+	require.Equal(t, "/user-home/avd", HomeDir(Environment{AndroidUserHome: "/user-home", Home: "/home"}))
+	require.Equal(t, "/sdk-home/.android/avd", HomeDir(Environment{AndroidSDKHome: "/sdk-home", Home: "/home"}))
+	require.Equal(t, "/home/.android/avd", HomeDir(Environment{Home: "/home"}))
+}
+
+const pixelConfig = `AvdId=Pixel_5_API_33
+PlayStore.enabled=false
+abi.type=x86_64
+avd.ini.displayname=Pixel 5 API 33
+avd.ini.encoding=UTF-8
+disk.dataPartition.size=6442450944
+fastboot.forceColdBoot=no
+hw.cpu.arch=x86_64
+hw.cpu.ncore=4
+hw.device.name=pixel_5
+hw.keyboard=yes
+hw.lcd.density=440
+hw.lcd.height=2340
+hw.lcd.width=1080
+hw.ramSize=2048
+image.sysdir.1=system-images/android-33/google_apis/x86_64/
+tag.display=Google APIs
+tag.id=google_apis
+vm.heapSize=256
+`
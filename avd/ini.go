@@ -0,0 +1,114 @@
+package avd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// IniFile is an ordered `key=value` file, the format of the AVD config.ini and <name>.ini files.
+// Unknown keys, comments, blank lines and the key order are preserved when writing the file back.
+type IniFile struct {
+	lines []iniLine
+}
+
+type iniLine struct {
+	key   string
+	value string
+	// raw is set for lines which are not key-value pairs (comments, blank lines) and written back as is.
+	raw string
+}
+
+func (line iniLine) isEntry() bool {
+	return line.key != ""
+}
+
+// ParseIni ...
+func ParseIni(r io.Reader) (*IniFile, error) {
+	file := &IniFile{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		text := scanner.Text()
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";") {
+			file.lines = append(file.lines, iniLine{raw: text})
+			continue
+		}
+
+		key, value, found := strings.Cut(trimmed, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			return nil, fmt.Errorf("invalid line, expected key=value: %s", text)
+		}
+		file.lines = append(file.lines, iniLine{key: key, value: strings.TrimSpace(value)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return file, nil
+}
+
+// Get returns the value of the key and whether it is set.
+func (file *IniFile) Get(key string) (string, bool) {
+	for _, line := range file.lines {
+		if line.isEntry() && line.key == key {
+			return line.value, true
+		}
+	}
+	return "", false
+}
+
+// Set updates the value of an existing key in place, or appends the key to the end of the file.
+func (file *IniFile) Set(key, value string) {
+	for i, line := range file.lines {
+		if line.isEntry() && line.key == key {
+			file.lines[i].value = value
+			return
+		}
+	}
+	file.lines = append(file.lines, iniLine{key: key, value: value})
+}
+
+// Delete removes the key from the file.
+func (file *IniFile) Delete(key string) {
+	var lines []iniLine
+	for _, line := range file.lines {
+		if line.isEntry() && line.key == key {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	file.lines = lines
+}
+
+// Keys returns the keys in file order.
+func (file *IniFile) Keys() []string {
+	var keys []string
+	for _, line := range file.lines {
+		if line.isEntry() {
+			keys = append(keys, line.key)
+		}
+	}
+	return keys
+}
+
+// WriteTo writes the file in `key=value` format.
+func (file *IniFile) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	for _, line := range file.lines {
+		text := line.raw
+		if line.isEntry() {
+			text = line.key + "=" + line.value
+		}
+
+		n, err := io.WriteString(w, text+"\n")
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,44 @@
+package avd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIniFile_RoundTrip(t *testing.T) {
+	input := `# written by hand
+avd.ini.encoding=UTF-8
+hw.ramSize = 2048
+
+custom.key=a=b
+`
+	file, err := ParseIni(strings.NewReader(input))
+	require.NoError(t, err)
+
+	value, ok := file.Get("custom.key")
+	require.True(t, ok)
+	require.Equal(t, "a=b", value)
+	require.Equal(t, []string{"avd.ini.encoding", "hw.ramSize", "custom.key"}, file.Keys())
+
+	file.Set("hw.ramSize", "4096")
+	file.Set("hw.keyboard", "yes")
+	file.Delete("avd.ini.encoding")
+
+	var buf bytes.Buffer
+	_, err = file.WriteTo(&buf)
+	require.NoError(t, err)
+	require.Equal(t, `# written by hand
+hw.ramSize=4096
+
+custom.key=a=b
+hw.keyboard=yes
+`, buf.String())
+}
+
+func TestParseIni_InvalidLine(t *testing.T) {
+	_, err := ParseIni(strings.NewReader("hw.ramSize=2048\nnot a key value pair\n"))
+	require.EqualError(t, err, "invalid line, expected key=value: not a key value pair")
+}
@@ -0,0 +1,200 @@
+package avd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-android/v2/sdkcomponent"
+)
+
+const (
+	// snapshotProtoFileName is written by the emulator into every complete snapshot directory.
+	snapshotProtoFileName = "snapshot.pb"
+	// snapshotMetadataFileName is written next to the emulator's files by RecordSnapshot.
+	snapshotMetadataFileName = "go-android-snapshot.ini"
+	keySystemImage           = "system_image"
+)
+
+// InvalidateSnapshotsOptions ...
+type InvalidateSnapshotsOptions struct {
+	// DeleteUnrecorded deletes the snapshots without a recorded system image fingerprint too (for example the
+	// emulator's default_boot snapshot), they are kept by default.
+	DeleteUnrecorded bool
+}
+
+// Snapshot is an emulator snapshot of an AVD, saved in the AVD's snapshots directory.
+type Snapshot struct {
+	Name string
+	Dir  string
+	// SystemImage is the fingerprint of the system image the snapshot was saved with (see SystemImageFingerprint),
+	// empty if the snapshot was not recorded by RecordSnapshot.
+	SystemImage string
+	ModTime     time.Time
+}
+
+// SystemImageFingerprint identifies the installed version of a system image: its SDK style path and the
+// Pkg.Revision of its source.properties, for example `system-images;android-33;google_apis;x86_64@9`.
+func SystemImageFingerprint(androidHome string, image sdkcomponent.SystemImage) (string, error) {
+	pth := filepath.Join(androidHome, image.InstallPathInAndroidHome(), "source.properties")
+	f, err := os.Open(pth)
+	if err != nil {
+		return "", fmt.Errorf("read system image revision: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	properties, err := ParseIni(f)
+	if err != nil {
+		return "", fmt.Errorf("parse %s: %w", pth, err)
+	}
+	revision, ok := properties.Get("Pkg.Revision")
+	if !ok || revision == "" {
+		return "", fmt.Errorf("Pkg.Revision not found in %s", pth)
+	}
+
+	return image.GetSDKStylePath() + "@" + revision, nil
+}
+
+// SnapshotsDir is the directory where the emulator saves the AVD's snapshots, one directory per snapshot.
+func (avd AVD) SnapshotsDir() string {
+	return filepath.Join(avd.Dir(), "snapshots")
+}
+
+// SnapshotCacheKey returns a cache key for the AVD's snapshots directory, it changes when the system image
+// fingerprint changes.
+func (avd AVD) SnapshotCacheKey(systemImageFingerprint string) string {
+	hash := sha256.Sum256([]byte(systemImageFingerprint))
+	return fmt.Sprintf("avd-snapshots-%s-%s", avd.Name, hex.EncodeToString(hash[:])[:16])
+}
+
+// Snapshots lists the complete snapshots of the AVD by name, incomplete snapshot directories (without snapshot.pb)
+// are skipped.
+func (avd AVD) Snapshots() ([]Snapshot, error) {
+	entries, err := os.ReadDir(avd.SnapshotsDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("list snapshots: %w", err)
+	}
+
+	var snapshots []Snapshot
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		dir := filepath.Join(avd.SnapshotsDir(), entry.Name())
+		info, err := os.Stat(filepath.Join(dir, snapshotProtoFileName))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("list snapshots: %w", err)
+		}
+
+		systemImage, err := readSnapshotSystemImage(dir)
+		if err != nil {
+			return nil, fmt.Errorf("list snapshots: %w", err)
+		}
+
+		snapshots = append(snapshots, Snapshot{
+			Name:        entry.Name(),
+			Dir:         dir,
+			SystemImage: systemImage,
+			ModTime:     info.ModTime(),
+		})
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Name < snapshots[j].Name })
+
+	return snapshots, nil
+}
+
+// ValidateSnapshotName returns an error if the name can't be used as a snapshot name: it is the name of the
+// snapshot's directory and an argument of the emulator console's snapshot commands.
+func ValidateSnapshotName(name string) error {
+	if name == "" || name == "." || name == ".." || name != filepath.Base(name) || strings.ContainsAny(name, "/\\ \t\r\n") {
+		return fmt.Errorf("invalid snapshot name: %q", name)
+	}
+	return nil
+}
+
+// RecordSnapshot stores the fingerprint of the system image the named (already saved) snapshot belongs to.
+func (avd AVD) RecordSnapshot(name, systemImageFingerprint string) error {
+	if err := ValidateSnapshotName(name); err != nil {
+		return err
+	}
+	dir := filepath.Join(avd.SnapshotsDir(), name)
+	if _, err := os.Stat(filepath.Join(dir, snapshotProtoFileName)); err != nil {
+		return fmt.Errorf("record snapshot %s: %w", name, err)
+	}
+
+	metadata := &IniFile{}
+	metadata.Set(keySystemImage, systemImageFingerprint)
+	var buf bytes.Buffer
+	if _, err := metadata.WriteTo(&buf); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, snapshotMetadataFileName), buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("record snapshot %s: %w", name, err)
+	}
+	return nil
+}
+
+// DeleteSnapshot removes the named snapshot, the emulator must not be running.
+func (avd AVD) DeleteSnapshot(name string) error {
+	if err := ValidateSnapshotName(name); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(avd.SnapshotsDir(), name)); err != nil {
+		return fmt.Errorf("delete snapshot %s: %w", name, err)
+	}
+	return nil
+}
+
+// InvalidateSnapshots deletes the snapshots which were recorded with another system image fingerprint, and returns
+// their names. Snapshots taken on another system image version would cold boot (or crash) the emulator.
+func (avd AVD) InvalidateSnapshots(systemImageFingerprint string, opts InvalidateSnapshotsOptions) ([]string, error) {
+	snapshots, err := avd.Snapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []string
+	for _, snapshot := range snapshots {
+		if snapshot.SystemImage == systemImageFingerprint || (snapshot.SystemImage == "" && !opts.DeleteUnrecorded) {
+			continue
+		}
+		if err := avd.DeleteSnapshot(snapshot.Name); err != nil {
+			return deleted, err
+		}
+		deleted = append(deleted, snapshot.Name)
+	}
+	return deleted, nil
+}
+
+func readSnapshotSystemImage(dir string) (string, error) {
+	f, err := os.Open(filepath.Join(dir, snapshotMetadataFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	metadata, err := ParseIni(f)
+	if err != nil {
+		return "", err
+	}
+	systemImage, _ := metadata.Get(keySystemImage)
+	return systemImage, nil
+}
@@ -0,0 +1,103 @@
+package avd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-android/v2/sdkcomponent"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSystemImageFingerprint(t *testing.T) {
+	androidHome := t.TempDir()
+	image := sdkcomponent.SystemImage{Platform: "android-33", Tag: "google_apis", ABI: "x86_64"}
+	writeSourceProperties(t, androidHome, image, "9")
+
+	fingerprint, err := SystemImageFingerprint(androidHome, image)
+	require.NoError(t, err)
+	require.Equal(t, "system-images;android-33;google_apis;x86_64@9", fingerprint)
+
+	_, err = SystemImageFingerprint(androidHome, sdkcomponent.SystemImage{Platform: "android-34", ABI: "x86_64"})
+	require.Error(t, err)
+}
+
+func TestAVD_Snapshots(t *testing.T) {
+	avd := AVD{Name: "Pixel_5_API_33", Home: t.TempDir()}
+	writeSnapshot(t, avd, "default_boot")
+	writeSnapshot(t, avd, "logged_in")
+	require.NoError(t, os.MkdirAll(filepath.Join(avd.SnapshotsDir(), "interrupted"), 0755))
+	require.NoError(t, avd.RecordSnapshot("logged_in", "system-images;android-33;google_apis;x86_64@9"))
+
+	snapshots, err := avd.Snapshots()
+	require.NoError(t, err)
+	require.Len(t, snapshots, 2)
+	require.Equal(t, "default_boot", snapshots[0].Name)
+	require.Equal(t, "", snapshots[0].SystemImage)
+	require.Equal(t, "logged_in", snapshots[1].Name)
+	require.Equal(t, "system-images;android-33;google_apis;x86_64@9", snapshots[1].SystemImage)
+	require.Equal(t, filepath.Join(avd.Dir(), "snapshots", "logged_in"), snapshots[1].Dir)
+
+	require.Error(t, avd.RecordSnapshot("interrupted", "system-images;android-33;google_apis;x86_64@9"))
+}
+
+func TestAVD_Snapshots_NoSnapshotsDir(t *testing.T) {
+	snapshots, err := AVD{Name: "Pixel_5_API_33", Home: t.TempDir()}.Snapshots()
+	require.NoError(t, err)
+	require.Empty(t, snapshots)
+}
+
+func TestAVD_InvalidateSnapshots(t *testing.T) {
+	avd := AVD{Name: "Pixel_5_API_33", Home: t.TempDir()}
+	writeSnapshot(t, avd, "default_boot")
+	writeSnapshot(t, avd, "old_image")
+	writeSnapshot(t, avd, "current_image")
+	require.NoError(t, avd.RecordSnapshot("old_image", "system-images;android-33;google_apis;x86_64@8"))
+	require.NoError(t, avd.RecordSnapshot("current_image", "system-images;android-33;google_apis;x86_64@9"))
+
+	deleted, err := avd.InvalidateSnapshots("system-images;android-33;google_apis;x86_64@9", InvalidateSnapshotsOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"old_image"}, deleted)
+
+	snapshots, err := avd.Snapshots()
+	require.NoError(t, err)
+	require.Len(t, snapshots, 2)
+	require.Equal(t, "current_image", snapshots[0].Name)
+	require.Equal(t, "default_boot", snapshots[1].Name)
+	require.NoDirExists(t, filepath.Join(avd.SnapshotsDir(), "old_image"))
+
+	deleted, err = avd.InvalidateSnapshots("system-images;android-33;google_apis;x86_64@9", InvalidateSnapshotsOptions{DeleteUnrecorded: true})
+	require.NoError(t, err)
+	require.Equal(t, []string{"default_boot"}, deleted)
+}
+
+func TestAVD_SnapshotCacheKey(t *testing.T) {
+	avd := AVD{Name: "Pixel_5_API_33", Home: t.TempDir()}
+
+	key := avd.SnapshotCacheKey("system-images;android-33;google_apis;x86_64@9")
+	require.Regexp(t, `^avd-snapshots-Pixel_5_API_33-[0-9a-f]{16}$`, key)
+	require.Equal(t, key, AVD{Name: "Pixel_5_API_33", Home: t.TempDir()}.SnapshotCacheKey("system-images;android-33;google_apis;x86_64@9"))
+	require.NotEqual(t, key, avd.SnapshotCacheKey("system-images;android-33;google_apis;x86_64@10"))
+}
+
+func TestAVD_Snapshot_InvalidName(t *testing.T) {
+	avd := AVD{Name: "Pixel_5_API_33", Home: t.TempDir()}
+	for _, name := range []string{"", ".", "..", "../" + avd.Name + ".avd", "logged in"} {
+		require.Error(t, avd.DeleteSnapshot(name), name)
+		require.Error(t, avd.RecordSnapshot(name, "system-images;android-33;google_apis;x86_64@9"), name)
+	}
+	require.DirExists(t, avd.Home)
+}
+
+func writeSnapshot(t *testing.T, avd AVD, name string) {
+	dir := filepath.Join(avd.SnapshotsDir(), name)
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, snapshotProtoFileName), []byte{0x08, 0x01}, 0644))
+}
+
+func writeSourceProperties(t *testing.T, androidHome string, image sdkcomponent.SystemImage, revision string) {
+	dir := filepath.Join(androidHome, image.InstallPathInAndroidHome())
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	properties := "Pkg.Desc=Google APIs Intel x86_64 Atom System Image\nPkg.Revision=" + revision + "\nAndroidVersion.ApiLevel=33\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "source.properties"), []byte(properties), 0644))
+}
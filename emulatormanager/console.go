@@ -0,0 +1,345 @@
+package emulatormanager
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	consoleAuthTokenFileName     = ".emulator_console_auth_token"
+	defaultConsoleCommandTimeout = time.Minute
+)
+
+// ErrConsoleClosed is returned by the commands of a console whose connection was closed after a failed read or
+// write (for example a timed out command), a late response could otherwise be read as the next command's response.
+var ErrConsoleClosed = errors.New("console connection is closed")
+
+// NetworkSpeed is the value of the console's `network speed` command, custom speeds can be set in the
+// `<upload>:<download>` (kbps) form.
+type NetworkSpeed string
+
+// Network speeds ...
+const (
+	NetworkSpeedGSM   NetworkSpeed = "gsm"
+	NetworkSpeedGPRS  NetworkSpeed = "gprs"
+	NetworkSpeedEDGE  NetworkSpeed = "edge"
+	NetworkSpeedUMTS  NetworkSpeed = "umts"
+	NetworkSpeedHSDPA NetworkSpeed = "hsdpa"
+	NetworkSpeedLTE   NetworkSpeed = "lte"
+	NetworkSpeedFull  NetworkSpeed = "full"
+)
+
+// NetworkDelay is the value of the console's `network delay` command, custom latencies can be set in the
+// `<min>:<max>` (ms) form.
+type NetworkDelay string
+
+// Network delays ...
+const (
+	NetworkDelayGPRS NetworkDelay = "gprs"
+	NetworkDelayEDGE NetworkDelay = "edge"
+	NetworkDelayUMTS NetworkDelay = "umts"
+	NetworkDelayNone NetworkDelay = "none"
+)
+
+// BatteryStatus is the value of the console's `power status` command.
+type BatteryStatus string
+
+// Battery statuses ...
+const (
+	BatteryStatusUnknown     BatteryStatus = "unknown"
+	BatteryStatusCharging    BatteryStatus = "charging"
+	BatteryStatusDischarging BatteryStatus = "discharging"
+	BatteryStatusNotCharging BatteryStatus = "not-charging"
+	BatteryStatusFull        BatteryStatus = "full"
+)
+
+// ConsoleOptions ...
+type ConsoleOptions struct {
+	// AuthTokenPath is the file of the console auth token, defaults to ~/.emulator_console_auth_token.
+	AuthTokenPath string
+	// CommandTimeout is the time to wait for the response of a command, defaults to 1m.
+	CommandTimeout time.Duration
+}
+
+// Console is a client of the emulator console, see: https://developer.android.com/studio/run/emulator-console
+// Commands are serialized, a Console can be used from multiple goroutines.
+type Console struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	mu sync.Mutex
+	// err is set once the connection is closed because of a failed read or write.
+	err error
+}
+
+// DialConsole connects to the console of the emulator listening on the given console port and authenticates
+// with the token of the auth token file. ctx bounds connecting and authenticating.
+func DialConsole(ctx context.Context, port int, opts ConsoleOptions) (*Console, error) {
+	if opts.CommandTimeout == 0 {
+		opts.CommandTimeout = defaultConsoleCommandTimeout
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("connect to emulator console: %w", err)
+	}
+	console := &Console{conn: conn, reader: bufio.NewReader(conn), timeout: opts.CommandTimeout}
+
+	if err := console.authenticate(ctx, opts.AuthTokenPath); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("connect to emulator console: %w", err)
+	}
+	return console, nil
+}
+
+// Console connects to the console of the emulator.
+func (emulator *Emulator) Console(ctx context.Context, opts ConsoleOptions) (*Console, error) {
+	return DialConsole(ctx, emulator.Port, opts)
+}
+
+// Close ends the console session.
+func (console *Console) Close() error {
+	console.mu.Lock()
+	defer console.mu.Unlock()
+
+	if console.err != nil {
+		return nil
+	}
+	_ = console.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	_, _ = fmt.Fprint(console.conn, "quit\n")
+	return console.conn.Close()
+}
+
+// Command runs a console command and returns its output without the closing `OK` line.
+// The console's `KO: <reason>` response is returned as an error.
+func (console *Console) Command(args ...string) ([]string, error) {
+	for _, arg := range args {
+		if strings.ContainsAny(arg, "\r\n") {
+			return nil, fmt.Errorf("invalid console command argument: %q", arg)
+		}
+	}
+	cmd := strings.Join(args, " ")
+
+	console.mu.Lock()
+	defer console.mu.Unlock()
+
+	if console.err != nil {
+		return nil, fmt.Errorf("%s: %w", cmd, console.err)
+	}
+	if err := console.conn.SetDeadline(time.Now().Add(console.timeout)); err != nil {
+		return nil, fmt.Errorf("%s: %w", cmd, console.closeAfter(err))
+	}
+	if _, err := fmt.Fprintf(console.conn, "%s\n", cmd); err != nil {
+		return nil, fmt.Errorf("%s: %w", cmd, console.closeAfter(err))
+	}
+	lines, err := console.readResponse()
+	var failure consoleFailure
+	if err != nil && !errors.As(err, &failure) {
+		err = console.closeAfter(err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", cmd, err)
+	}
+	return lines, nil
+}
+
+// closeAfter closes the connection after a failed read or write, the state of the session is unknown.
+func (console *Console) closeAfter(err error) error {
+	_ = console.conn.Close()
+	console.err = fmt.Errorf("%w: %s", ErrConsoleClosed, err)
+	return err
+}
+
+// SetNetworkSpeed ...
+func (console *Console) SetNetworkSpeed(speed NetworkSpeed) error {
+	_, err := console.Command("network", "speed", string(speed))
+	return err
+}
+
+// SetNetworkDelay ...
+func (console *Console) SetNetworkDelay(delay NetworkDelay) error {
+	_, err := console.Command("network", "delay", string(delay))
+	return err
+}
+
+// SetBatteryCapacity sets the battery charge level in percent.
+func (console *Console) SetBatteryCapacity(percent int) error {
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("invalid battery capacity: %d", percent)
+	}
+	_, err := console.Command("power", "capacity", strconv.Itoa(percent))
+	return err
+}
+
+// SetBatteryStatus ...
+func (console *Console) SetBatteryStatus(status BatteryStatus) error {
+	_, err := console.Command("power", "status", string(status))
+	return err
+}
+
+// SetACCharger connects or disconnects the AC charger, the battery is not discharged while it is connected.
+func (console *Console) SetACCharger(connected bool) error {
+	state := "off"
+	if connected {
+		state = "on"
+	}
+	_, err := console.Command("power", "ac", state)
+	return err
+}
+
+// SetLocation sends a GPS fix, note that the console command takes the longitude first.
+func (console *Console) SetLocation(latitude, longitude float64) error {
+	_, err := console.Command("geo", "fix", formatCoordinate(longitude), formatCoordinate(latitude))
+	return err
+}
+
+// SendSMS simulates an incoming SMS.
+func (console *Console) SendSMS(phoneNumber, text string) error {
+	_, err := console.Command("sms", "send", phoneNumber, text)
+	return err
+}
+
+// SaveSnapshot saves the state of the emulator into the named snapshot.
+func (console *Console) SaveSnapshot(name string) error {
+	_, err := console.Command("avd", "snapshot", "save", name)
+	return err
+}
+
+// LoadSnapshot restores the state of the emulator from the named snapshot.
+func (console *Console) LoadSnapshot(name string) error {
+	_, err := console.Command("avd", "snapshot", "load", name)
+	return err
+}
+
+// DeleteSnapshot ...
+func (console *Console) DeleteSnapshot(name string) error {
+	_, err := console.Command("avd", "snapshot", "delete", name)
+	return err
+}
+
+// ListSnapshots returns the names of the snapshots of the AVD.
+func (console *Console) ListSnapshots() ([]string, error) {
+	lines, err := console.Command("avd", "snapshot", "list")
+	if err != nil {
+		return nil, err
+	}
+	return parseSnapshotList(lines), nil
+}
+
+// authenticate reads the greeting and sends the auth token if the console requires it:
+//
+// Android Console: Authentication required
+// Android Console: type 'auth <auth_token>' to authenticate
+// Android Console: you can find your <auth_token> in
+// '/home/user/.emulator_console_auth_token'
+// OK
+func (console *Console) authenticate(ctx context.Context, authTokenPath string) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(console.timeout)
+	}
+	if err := console.conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	defer context.AfterFunc(ctx, func() { console.conn.Close() })()
+
+	greeting, err := console.readResponse()
+	if err != nil {
+		return fmt.Errorf("read greeting: %w", contextErrOr(ctx, err))
+	}
+	if !strings.Contains(strings.Join(greeting, "\n"), "Authentication required") {
+		// the auth token file is empty
+		return nil
+	}
+
+	token, err := readConsoleAuthToken(authTokenPath)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(console.conn, "auth %s\n", token); err != nil {
+		return contextErrOr(ctx, err)
+	}
+	if _, err := console.readResponse(); err != nil {
+		return fmt.Errorf("authenticate: %w", contextErrOr(ctx, err))
+	}
+	return nil
+}
+
+// consoleFailure is the reason of a `KO: <reason>` response, the session can be used for further commands.
+type consoleFailure string
+
+func (failure consoleFailure) Error() string {
+	return string(failure)
+}
+
+// readResponse reads the lines of a response until its `OK` or `KO: <reason>` line.
+func (console *Console) readResponse() ([]string, error) {
+	var lines []string
+	for {
+		line, err := console.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case line == "OK":
+			return lines, nil
+		case strings.HasPrefix(line, "KO"):
+			return nil, consoleFailure(strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(line, "KO"), ":")))
+		}
+		lines = append(lines, line)
+	}
+}
+
+func readConsoleAuthToken(pth string) (string, error) {
+	if pth == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("find console auth token: %w", err)
+		}
+		pth = filepath.Join(home, consoleAuthTokenFileName)
+	}
+
+	token, err := os.ReadFile(pth)
+	if err != nil {
+		return "", fmt.Errorf("read console auth token: %w", err)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+func contextErrOr(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+func formatCoordinate(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// List of snapshots present on all disks:
+// ID        TAG                 VM SIZE                DATE       VM CLOCK
+// --        default_boot         137M 2024-05-14 10:12:43   00:01:24.110
+func parseSnapshotList(lines []string) []string {
+	var names []string
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "--" {
+			names = append(names, fields[1])
+		}
+	}
+	return names
+}
@@ -0,0 +1,241 @@
+package emulatormanager
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeConsole is an emulator console server, it requires authentication if token is set and answers every command
+// with OK unless responses has an entry for it.
+type fakeConsole struct {
+	listener  net.Listener
+	token     string
+	responses map[string]string
+
+	mu       sync.Mutex
+	commands []string
+	// delays postpone the response of the commands.
+	delays map[string]time.Duration
+}
+
+func newFakeConsole(t *testing.T, token string, responses map[string]string) *fakeConsole {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	console := &fakeConsole{listener: listener, token: token, responses: responses}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go console.handle(conn)
+		}
+	}()
+	return console
+}
+
+func (console *fakeConsole) port() int {
+	return console.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (console *fakeConsole) Commands() []string {
+	console.mu.Lock()
+	defer console.mu.Unlock()
+	return append([]string(nil), console.commands...)
+}
+
+func (console *fakeConsole) handle(conn net.Conn) {
+	defer conn.Close()
+
+	authenticated := console.token == ""
+	if authenticated {
+		fmt.Fprint(conn, "Android Console: type 'help' for a list of commands\r\nOK\r\n")
+	} else {
+		fmt.Fprint(conn, "Android Console: Authentication required\r\n"+
+			"Android Console: type 'auth <auth_token>' to authenticate\r\n"+
+			"Android Console: you can find your <auth_token> in \r\n"+
+			"'/home/user/.emulator_console_auth_token'\r\nOK\r\n")
+	}
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		cmd := strings.TrimSpace(scanner.Text())
+		switch {
+		case cmd == "quit":
+			return
+		case strings.HasPrefix(cmd, "auth "):
+			if strings.TrimPrefix(cmd, "auth ") != console.token {
+				fmt.Fprint(conn, "KO: authentication token does not match ~/.emulator_console_auth_token\r\n")
+				continue
+			}
+			authenticated = true
+			fmt.Fprint(conn, "Android Console: type 'help' for a list of commands\r\nOK\r\n")
+		case !authenticated:
+			fmt.Fprint(conn, "KO: unknown command, try 'help'\r\n")
+		default:
+			console.mu.Lock()
+			console.commands = append(console.commands, cmd)
+			delay := console.delays[cmd]
+			console.mu.Unlock()
+			time.Sleep(delay)
+
+			response, ok := console.responses[cmd]
+			if !ok {
+				response = "OK"
+			}
+			fmt.Fprintf(conn, "%s\r\n", strings.ReplaceAll(response, "\n", "\r\n"))
+		}
+	}
+}
+
+func writeAuthToken(t *testing.T, token string) string {
+	pth := filepath.Join(t.TempDir(), consoleAuthTokenFileName)
+	require.NoError(t, os.WriteFile(pth, []byte(token), 0600))
+	return pth
+}
+
+func dialFakeConsole(t *testing.T, server *fakeConsole, token string) *Console {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	console, err := DialConsole(ctx, server.port(), ConsoleOptions{AuthTokenPath: writeAuthToken(t, token)})
+	require.NoError(t, err)
+	t.Cleanup(func() { console.Close() })
+	return console
+}
+
+func Test_GivenAuthToken_WhenDialConsole_ThenAuthenticates(t *testing.T) {
+	// Given
+	server := newFakeConsole(t, "s3cr3t", nil)
+	console := dialFakeConsole(t, server, "s3cr3t\n")
+
+	// When
+	err := console.SetNetworkSpeed(NetworkSpeedEDGE)
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []string{"network speed edge"}, server.Commands())
+}
+
+func Test_GivenWrongAuthToken_WhenDialConsole_ThenReturnsError(t *testing.T) {
+	// Given
+	server := newFakeConsole(t, "s3cr3t", nil)
+
+	// When
+	_, err := DialConsole(context.Background(), server.port(), ConsoleOptions{AuthTokenPath: writeAuthToken(t, "wrong")})
+
+	// Then
+	require.EqualError(t, err, "connect to emulator console: authenticate: authentication token does not match ~/.emulator_console_auth_token")
+}
+
+func Test_GivenSimulationCommands_WhenRunning_ThenSendsConsoleCommands(t *testing.T) {
+	// Given
+	server := newFakeConsole(t, "", nil)
+	console := dialFakeConsole(t, server, "")
+
+	// When
+	require.NoError(t, console.SetNetworkSpeed("128:512"))
+	require.NoError(t, console.SetNetworkDelay(NetworkDelayUMTS))
+	require.NoError(t, console.SetBatteryCapacity(15))
+	require.NoError(t, console.SetBatteryStatus(BatteryStatusDischarging))
+	require.NoError(t, console.SetACCharger(false))
+	require.NoError(t, console.SetLocation(47.4979, 19.0402))
+	require.NoError(t, console.SendSMS("+36301234567", "Your code is 482913"))
+	require.NoError(t, console.SaveSnapshot("logged_in"))
+	require.NoError(t, console.LoadSnapshot("logged_in"))
+
+	// Then
+	require.Equal(t, []string{
+		"network speed 128:512",
+		"network delay umts",
+		"power capacity 15",
+		"power status discharging",
+		"power ac off",
+		"geo fix 19.0402 47.4979",
+		"sms send +36301234567 Your code is 482913",
+		"avd snapshot save logged_in",
+		"avd snapshot load logged_in",
+	}, server.Commands())
+}
+
+func Test_GivenSnapshots_WhenListSnapshots_ThenReturnsNames(t *testing.T) {
+	// Given
+	server := newFakeConsole(t, "", map[string]string{
+		"avd snapshot list": `List of snapshots present on all disks:
+ID        TAG                 VM SIZE                DATE       VM CLOCK
+--        default_boot         137M 2024-05-14 10:12:43   00:01:24.110
+--        logged_in            141M 2024-05-14 10:20:02   00:03:51.482
+OK`,
+	})
+	console := dialFakeConsole(t, server, "")
+
+	// When
+	names, err := console.ListSnapshots()
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []string{"default_boot", "logged_in"}, names)
+}
+
+func Test_GivenFailingCommand_WhenCommand_ThenReturnsConsoleError(t *testing.T) {
+	// Given
+	server := newFakeConsole(t, "", map[string]string{
+		"avd snapshot load missing": "KO: Snapshot 'missing' does not exist",
+	})
+	console := dialFakeConsole(t, server, "")
+
+	// When
+	err := console.LoadSnapshot("missing")
+	nextErr := console.SetBatteryCapacity(50)
+
+	// Then
+	require.EqualError(t, err, "avd snapshot load missing: Snapshot 'missing' does not exist")
+	require.NoError(t, nextErr)
+}
+
+func Test_GivenArgumentWithNewline_WhenCommand_ThenReturnsErrorWithoutSending(t *testing.T) {
+	// Given
+	server := newFakeConsole(t, "", nil)
+	console := dialFakeConsole(t, server, "")
+
+	// When
+	err := console.SendSMS("+36301234567", "first line\nkill")
+
+	// Then
+	require.Error(t, err)
+	require.Empty(t, server.Commands())
+}
+
+func Test_GivenTimedOutCommand_WhenNextCommand_ThenReturnsConsoleClosed(t *testing.T) {
+	// Given
+	server := newFakeConsole(t, "", nil)
+	server.mu.Lock()
+	server.delays = map[string]time.Duration{"avd snapshot save logged_in": 200 * time.Millisecond}
+	server.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	console, err := DialConsole(ctx, server.port(), ConsoleOptions{AuthTokenPath: writeAuthToken(t, ""), CommandTimeout: 50 * time.Millisecond})
+	require.NoError(t, err)
+	saveErr := console.SaveSnapshot("logged_in")
+
+	// When
+	err = console.SetBatteryCapacity(50)
+
+	// Then
+	require.Error(t, saveErr)
+	require.ErrorIs(t, err, ErrConsoleClosed)
+	require.NoError(t, console.Close())
+	require.Equal(t, []string{"avd snapshot save logged_in"}, server.Commands())
+}
@@ -0,0 +1,348 @@
+package emulatormanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-android/v2/avd"
+	"github.com/bitrise-io/go-android/v2/sdk"
+	"github.com/bitrise-io/go-android/v2/sdkcomponent"
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+// Emulator console ports are even numbers in this range, the adb port of an emulator is the console port + 1.
+// See: https://developer.android.com/studio/run/emulator-commandline#common
+const (
+	minConsolePort = 5554
+	maxConsolePort = 5682
+)
+
+const (
+	maxStartAttempts       = 3
+	consolePortBindTimeout = 10 * time.Second
+)
+
+var errConsolePortInUse = errors.New("console port is already in use")
+
+// GPUMode is the value of the emulator's `-gpu` flag.
+type GPUMode string
+
+// GPU modes, see: https://developer.android.com/studio/run/emulator-acceleration#command-gpu
+const (
+	GPUModeAuto                GPUMode = "auto"
+	GPUModeHost                GPUMode = "host"
+	GPUModeSwiftshaderIndirect GPUMode = "swiftshader_indirect"
+	GPUModeAngleIndirect       GPUMode = "angle_indirect"
+	GPUModeGuest               GPUMode = "guest"
+)
+
+// Model ...
+type Model struct {
+	emulatorPth   string
+	avdmanagerPth string
+	adbPth        string
+	cmdFactory    command.Factory
+	logger        log.Logger
+}
+
+// New ...
+func New(sdk sdk.AndroidSdkInterface, cmdFactory command.Factory, logger log.Logger) (*Model, error) {
+	emulatorPth, err := findTool(
+		filepath.Join(sdk.GetAndroidHome(), "emulator", "emulator"),
+		filepath.Join(sdk.GetAndroidHome(), "tools", "emulator"), // legacy
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	cmdlineToolsPth, err := sdk.CmdlineToolsPath()
+	if err != nil {
+		return nil, err
+	}
+	avdmanagerPth, err := findTool(filepath.Join(cmdlineToolsPth, "avdmanager"))
+	if err != nil {
+		return nil, err
+	}
+
+	adbPth, err := findTool(filepath.Join(sdk.GetAndroidHome(), "platform-tools", "adb"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Model{
+		emulatorPth:   emulatorPth,
+		avdmanagerPth: avdmanagerPth,
+		adbPth:        adbPth,
+		cmdFactory:    cmdFactory,
+		logger:        logger,
+	}, nil
+}
+
+func findTool(pths ...string) (string, error) {
+	for _, pth := range pths {
+		if exist, err := pathutil.IsPathExists(pth); err != nil {
+			return "", fmt.Errorf("failed to check if %s exist: %w", filepath.Base(pth), err)
+		} else if exist {
+			return pth, nil
+		}
+	}
+	return "", fmt.Errorf("%s not exist at: %s", filepath.Base(pths[0]), strings.Join(pths, ", "))
+}
+
+// CreateAVDOptions ...
+type CreateAVDOptions struct {
+	Name        string
+	SystemImage sdkcomponent.SystemImage
+	// Device is the hardware profile id or index (see `avdmanager list device`), the avdmanager default is used if empty.
+	Device string
+	// SDCard is the size of a new SD card image (for example 512M), or the path of an existing one.
+	SDCard string
+	// Force overwrites an existing AVD with the same name.
+	Force bool
+}
+
+// CreateAVDCmd returns a command that creates an AVD based on the given (already installed) system image.
+func (model Model) CreateAVDCmd(opts CreateAVDOptions, commandOptions *command.Opts) command.Command {
+	args := []string{"create", "avd", "--name", opts.Name, "--package", opts.SystemImage.GetSDKStylePath()}
+	if opts.SystemImage.Tag != "" {
+		args = append(args, "--tag", opts.SystemImage.Tag)
+	}
+	if opts.SystemImage.ABI != "" {
+		args = append(args, "--abi", opts.SystemImage.ABI)
+	}
+	if opts.Device != "" {
+		args = append(args, "--device", opts.Device)
+	}
+	if opts.SDCard != "" {
+		args = append(args, "--sdcard", opts.SDCard)
+	}
+	if opts.Force {
+		args = append(args, "--force")
+	}
+
+	var cmdOpts command.Opts
+	if commandOptions != nil {
+		cmdOpts = *commandOptions
+	}
+	if cmdOpts.Stdin == nil {
+		cmdOpts.Stdin = strings.NewReader("no\n") // Do you wish to create a custom hardware profile? [no]
+	}
+
+	return model.cmdFactory.Create(model.avdmanagerPth, args, &cmdOpts)
+}
+
+// StartOptions ...
+type StartOptions struct {
+	AVDName string
+	// Port is the console port of the emulator, a free one is picked if zero.
+	Port int
+	// Headless runs the emulator without a window (`-no-window`).
+	Headless bool
+	// NoSnapshot disables both loading and saving the quick boot snapshot (`-no-snapshot`).
+	NoSnapshot bool
+	NoAudio    bool
+	NoBootAnim bool
+	WipeData   bool
+	GPUMode    GPUMode
+	// Snapshot is the name of the snapshot to boot from instead of the quick boot snapshot (`-snapshot`),
+	// the emulator cold boots if it doesn't exist. It can't be combined with NoSnapshot.
+	Snapshot string
+	// NoSnapshotSave keeps the loaded snapshot unchanged when the emulator exits (`-no-snapshot-save`).
+	NoSnapshotSave bool
+	// AdditionalArgs are appended to the emulator command as is.
+	AdditionalArgs []string
+}
+
+// Validate returns an error if the options can't be rendered into a valid emulator command.
+func (opts StartOptions) Validate() error {
+	if opts.Snapshot == "" {
+		return nil
+	}
+	if opts.NoSnapshot {
+		return errors.New("snapshot can't be loaded with snapshots disabled (NoSnapshot)")
+	}
+	return avd.ValidateSnapshotName(opts.Snapshot)
+}
+
+// StartEmulatorCmd returns a command that runs the emulator on the given console port.
+func (model Model) StartEmulatorCmd(opts StartOptions, port int, commandOptions *command.Opts) (command.Command, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid start options: %w", err)
+	}
+
+	args := []string{"@" + opts.AVDName, "-port", strconv.Itoa(port)}
+	if opts.Headless {
+		args = append(args, "-no-window")
+	}
+	if opts.NoSnapshot {
+		args = append(args, "-no-snapshot")
+	}
+	if opts.Snapshot != "" {
+		args = append(args, "-snapshot", opts.Snapshot)
+	}
+	if opts.NoSnapshotSave {
+		args = append(args, "-no-snapshot-save")
+	}
+	if opts.NoAudio {
+		args = append(args, "-no-audio")
+	}
+	if opts.NoBootAnim {
+		args = append(args, "-no-boot-anim")
+	}
+	if opts.WipeData {
+		args = append(args, "-wipe-data")
+	}
+	if opts.GPUMode != "" {
+		args = append(args, "-gpu", string(opts.GPUMode))
+	}
+	args = append(args, opts.AdditionalArgs...)
+
+	return model.cmdFactory.Create(model.emulatorPth, args, commandOptions), nil
+}
+
+// KillCmd returns a command that asks the emulator with the given serial to shut down.
+func (model Model) KillCmd(serial string, commandOptions *command.Opts) command.Command {
+	return model.cmdFactory.Create(model.adbPth, []string{"-s", serial, "emu", "kill"}, commandOptions)
+}
+
+// Emulator is a running emulator process started by Model.Start.
+type Emulator struct {
+	Serial string
+	Port   int
+
+	doneChan chan struct{}
+	err      error
+}
+
+// Done returns a channel that is closed when the emulator process exits.
+func (emulator *Emulator) Done() <-chan struct{} {
+	return emulator.doneChan
+}
+
+// Err returns the exit error of the emulator process, it is only valid after Done is closed.
+func (emulator *Emulator) Err() error {
+	return emulator.err
+}
+
+// Start starts the emulator in the background and returns its serial. It doesn't wait for the device to boot,
+// see adbmanager.Model.WaitForDeviceContext.
+//
+// If opts.Port is zero, a free console port is picked. Another emulator might take the same port before this one
+// binds it, in that case the emulator is started again on another port.
+func (model Model) Start(opts StartOptions, commandOptions *command.Opts) (*Emulator, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid start options: %w", err)
+	}
+	if opts.Port != 0 {
+		return model.startOnPort(opts, opts.Port, commandOptions, 0)
+	}
+
+	taken := map[int]bool{}
+	for attempt := 1; ; attempt++ {
+		port, err := findFreeConsolePort(func(port int) bool { return !taken[port] && isPortFree(port) })
+		if err != nil {
+			return nil, err
+		}
+
+		emulator, err := model.startOnPort(opts, port, commandOptions, consolePortBindTimeout)
+		if errors.Is(err, errConsolePortInUse) && attempt < maxStartAttempts {
+			model.logger.Warnf("Console port %d was taken by another emulator, retrying on another port", port)
+			taken[port] = true
+			continue
+		}
+		return emulator, err
+	}
+}
+
+// startOnPort starts the emulator, if bindTimeout is non-zero it waits until the emulator binds the console port
+// (or bindTimeout passes) and returns errConsolePortInUse if the port was taken.
+func (model Model) startOnPort(opts StartOptions, port int, commandOptions *command.Opts, bindTimeout time.Duration) (*Emulator, error) {
+	var cmdOpts command.Opts
+	if commandOptions != nil {
+		cmdOpts = *commandOptions
+	}
+	watcher := newConsolePortWatcher()
+	cmdOpts.Stdout = watcher.stream(cmdOpts.Stdout)
+	cmdOpts.Stderr = watcher.stream(cmdOpts.Stderr)
+
+	cmd, err := model.StartEmulatorCmd(opts, port, &cmdOpts)
+	if err != nil {
+		return nil, err
+	}
+	model.logger.Printf("$ %s", cmd.PrintableCommandArgs())
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start emulator: %w", err)
+	}
+
+	emulator := &Emulator{
+		Serial:   fmt.Sprintf("emulator-%d", port),
+		Port:     port,
+		doneChan: make(chan struct{}),
+	}
+	go func() {
+		emulator.err = cmd.Wait()
+		close(emulator.doneChan)
+	}()
+
+	if bindTimeout == 0 {
+		return emulator, nil
+	}
+	timer := time.NewTimer(bindTimeout)
+	defer timer.Stop()
+	select {
+	case <-watcher.bound:
+	case <-timer.C:
+	case <-emulator.Done():
+		if watcher.isPortInUse() {
+			return nil, fmt.Errorf("start emulator on port %d: %w", port, errConsolePortInUse)
+		}
+	}
+	return emulator, nil
+}
+
+// Stop kills the emulator via `adb emu kill` and waits for the process to exit or ctx to be done.
+func (model Model) Stop(ctx context.Context, emulator *Emulator) error {
+	select {
+	case <-emulator.Done():
+		return nil
+	default:
+	}
+
+	cmd := model.KillCmd(emulator.Serial, nil)
+	if out, err := cmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("kill emulator (%s): %s: %w", emulator.Serial, out, err)
+	}
+
+	select {
+	case <-emulator.Done():
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("wait for emulator (%s) to exit: %w", emulator.Serial, ctx.Err())
+	}
+}
+
+func findFreeConsolePort(isFree func(port int) bool) (int, error) {
+	for port := minConsolePort; port <= maxConsolePort; port += 2 {
+		if isFree(port) && isFree(port+1) {
+			return port, nil
+		}
+	}
+	return 0, errors.New("no free emulator console port found")
+}
+
+func isPortFree(port int) bool {
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return false
+	}
+	_ = listener.Close()
+	return true
+}
@@ -0,0 +1,158 @@
+package emulatormanager
+
+import (
+	"testing"
+
+	"github.com/bitrise-io/go-android/v2/sdkcomponent"
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GivenSystemImage_WhenCreateAVDCmd_ThenCreatesExpectedCommand(t *testing.T) {
+	// Given
+	opts := CreateAVDOptions{
+		Name: "pixel_api_33",
+		SystemImage: sdkcomponent.SystemImage{
+			Platform: "android-33",
+			Tag:      "google_apis",
+			ABI:      "x86_64",
+		},
+		Device: "pixel_5",
+		SDCard: "512M",
+		Force:  true,
+	}
+
+	// When
+	testCommand := mockModel().CreateAVDCmd(opts, nil)
+
+	// Then
+	actualArgs := testCommand.PrintableCommandArgs()
+	expectedArgs := `avdmanager "create" "avd" "--name" "pixel_api_33" "--package" "system-images;android-33;google_apis;x86_64" "--tag" "google_apis" "--abi" "x86_64" "--device" "pixel_5" "--sdcard" "512M" "--force"`
+	require.Equal(t, expectedArgs, actualArgs)
+}
+
+func Test_GivenDefaultOptions_WhenStartEmulatorCmd_ThenCreatesExpectedCommand(t *testing.T) {
+	// When
+	testCommand, err := mockModel().StartEmulatorCmd(StartOptions{AVDName: "pixel_api_33"}, 5554, nil)
+
+	// Then
+	require.NoError(t, err)
+	actualArgs := testCommand.PrintableCommandArgs()
+	expectedArgs := `emulator "@pixel_api_33" "-port" "5554"`
+	require.Equal(t, expectedArgs, actualArgs)
+}
+
+func Test_GivenHeadlessOptions_WhenStartEmulatorCmd_ThenCreatesExpectedCommand(t *testing.T) {
+	// Given
+	opts := StartOptions{
+		AVDName:        "pixel_api_33",
+		Headless:       true,
+		NoSnapshot:     true,
+		NoAudio:        true,
+		NoBootAnim:     true,
+		WipeData:       true,
+		GPUMode:        GPUModeSwiftshaderIndirect,
+		AdditionalArgs: []string{"-memory", "2048"},
+	}
+
+	// When
+	testCommand, err := mockModel().StartEmulatorCmd(opts, 5556, nil)
+
+	// Then
+	require.NoError(t, err)
+	actualArgs := testCommand.PrintableCommandArgs()
+	expectedArgs := `emulator "@pixel_api_33" "-port" "5556" "-no-window" "-no-snapshot" "-no-audio" "-no-boot-anim" "-wipe-data" "-gpu" "swiftshader_indirect" "-memory" "2048"`
+	require.Equal(t, expectedArgs, actualArgs)
+}
+
+func Test_GivenSnapshotOptions_WhenStartEmulatorCmd_ThenLoadsSnapshotWithoutSaving(t *testing.T) {
+	// Given
+	opts := StartOptions{AVDName: "pixel_api_33", Snapshot: "logged_in", NoSnapshotSave: true}
+
+	// When
+	testCommand, err := mockModel().StartEmulatorCmd(opts, 5554, nil)
+
+	// Then
+	require.NoError(t, err)
+	expectedArgs := `emulator "@pixel_api_33" "-port" "5554" "-snapshot" "logged_in" "-no-snapshot-save"`
+	require.Equal(t, expectedArgs, testCommand.PrintableCommandArgs())
+}
+
+func Test_GivenSnapshotWithNoSnapshot_WhenStartEmulatorCmd_ThenFails(t *testing.T) {
+	// Given
+	opts := StartOptions{AVDName: "pixel_api_33", Snapshot: "logged_in", NoSnapshot: true}
+
+	// When
+	_, err := mockModel().StartEmulatorCmd(opts, 5554, nil)
+
+	// Then
+	require.EqualError(t, err, "invalid start options: snapshot can't be loaded with snapshots disabled (NoSnapshot)")
+}
+
+func Test_GivenSerial_WhenKillCmd_ThenCreatesExpectedCommand(t *testing.T) {
+	// When
+	testCommand := mockModel().KillCmd("emulator-5554", nil)
+
+	// Then
+	require.Equal(t, `adb "-s" "emulator-5554" "emu" "kill"`, testCommand.PrintableCommandArgs())
+}
+
+func Test_findFreeConsolePort(t *testing.T) {
+	tests := []struct {
+		name    string
+		busy    map[int]bool
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "First port is free",
+			want: 5554,
+		},
+		{
+			name: "Console port is busy",
+			busy: map[int]bool{5554: true},
+			want: 5556,
+		},
+		{
+			name: "ADB port is busy",
+			busy: map[int]bool{5555: true, 5557: true},
+			want: 5558,
+		},
+		{
+			name:    "All ports are busy",
+			busy:    allPorts(),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := findFreeConsolePort(func(port int) bool { return !tt.busy[port] })
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// Helpers
+
+func allPorts() map[int]bool {
+	ports := map[int]bool{}
+	for port := minConsolePort; port <= maxConsolePort+1; port++ {
+		ports[port] = true
+	}
+	return ports
+}
+
+func mockModel() Model {
+	return Model{
+		emulatorPth:   "emulator",
+		avdmanagerPth: "avdmanager",
+		adbPth:        "adb",
+		cmdFactory:    command.NewFactory(env.NewRepository()),
+	}
+}
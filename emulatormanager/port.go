@@ -0,0 +1,79 @@
+package emulatormanager
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+)
+
+const maxScannedLineLength = 4096
+
+// consolePortWatcher scans the emulator output for the result of binding the console port:
+//
+// INFO    | Listening for console connections on port: 5554
+// emulator: control console listening on port 5554, ADB on port 5555
+// ERROR   | Console port 5554 is already in use
+type consolePortWatcher struct {
+	bound chan struct{}
+
+	mu        sync.Mutex
+	portInUse bool
+	closed    bool
+}
+
+func newConsolePortWatcher() *consolePortWatcher {
+	return &consolePortWatcher{bound: make(chan struct{})}
+}
+
+// stream returns a writer for one of the emulator's outputs, which passes the output on to out (if not nil).
+func (watcher *consolePortWatcher) stream(out io.Writer) io.Writer {
+	return &consolePortStream{watcher: watcher, out: out}
+}
+
+func (watcher *consolePortWatcher) isPortInUse() bool {
+	watcher.mu.Lock()
+	defer watcher.mu.Unlock()
+	return watcher.portInUse
+}
+
+func (watcher *consolePortWatcher) scanLine(line string) {
+	line = strings.ToLower(line)
+	if !strings.Contains(line, "port") {
+		return
+	}
+
+	watcher.mu.Lock()
+	defer watcher.mu.Unlock()
+	switch {
+	case strings.Contains(line, "in use"), strings.Contains(line, "bind"):
+		watcher.portInUse = true
+	case strings.Contains(line, "listening") && !watcher.closed:
+		watcher.closed = true
+		close(watcher.bound)
+	}
+}
+
+type consolePortStream struct {
+	watcher *consolePortWatcher
+	out     io.Writer
+	line    bytes.Buffer
+}
+
+func (stream *consolePortStream) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b == '\n' {
+			stream.watcher.scanLine(stream.line.String())
+			stream.line.Reset()
+			continue
+		}
+		if stream.line.Len() < maxScannedLineLength {
+			stream.line.WriteByte(b)
+		}
+	}
+
+	if stream.out == nil {
+		return len(p), nil
+	}
+	return stream.out.Write(p)
+}
@@ -0,0 +1,91 @@
+package emulatormanager
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeEmulatorFactory creates emulator commands which print the n-th output and exit with an error if it is
+// the last one.
+type fakeEmulatorFactory struct {
+	outputs []string
+
+	mu    sync.Mutex
+	calls [][]string
+}
+
+func (f *fakeEmulatorFactory) Create(name string, args []string, opts *command.Opts) command.Command {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	output := f.outputs[len(f.calls)]
+	f.calls = append(f.calls, args)
+	return &fakeEmulatorCommand{Command: command.NewFactory(nil).Create(name, args, nil), opts: opts, output: output}
+}
+
+type fakeEmulatorCommand struct {
+	command.Command
+	opts   *command.Opts
+	output string
+	done   chan error
+}
+
+func (c *fakeEmulatorCommand) Start() error {
+	c.done = make(chan error, 1)
+	go func() {
+		_, _ = io.WriteString(c.opts.Stderr, c.output)
+		if strings.HasPrefix(c.output, "ERROR") {
+			c.done <- errors.New("exit status 1")
+			return
+		}
+		c.done <- nil
+	}()
+	return nil
+}
+
+func (c *fakeEmulatorCommand) Wait() error {
+	return <-c.done
+}
+
+func Test_GivenConsolePortTakenByAnotherEmulator_WhenStart_ThenRetriesOnAnotherPort(t *testing.T) {
+	// Given
+	factory := &fakeEmulatorFactory{outputs: []string{
+		"ERROR   | Console port 5554 is already in use\n",
+		"INFO    | Listening for console connections on port: 5556\n",
+	}}
+	model := mockModel()
+	model.cmdFactory = factory
+	model.logger = log.NewLogger()
+
+	// When
+	emulator, err := model.Start(StartOptions{AVDName: "pixel_api_33"}, nil)
+
+	// Then
+	require.NoError(t, err)
+	require.Len(t, factory.calls, 2)
+	require.Equal(t, factory.calls[1][2], emulator.Serial[len("emulator-"):])
+	require.NotEqual(t, factory.calls[0][2], factory.calls[1][2])
+}
+
+func Test_GivenExplicitPortInUse_WhenStart_ThenDoesNotRetry(t *testing.T) {
+	// Given
+	factory := &fakeEmulatorFactory{outputs: []string{"ERROR   | Console port 5554 is already in use\n"}}
+	model := mockModel()
+	model.cmdFactory = factory
+	model.logger = log.NewLogger()
+
+	// When
+	emulator, err := model.Start(StartOptions{AVDName: "pixel_api_33", Port: 5554}, nil)
+
+	// Then
+	require.NoError(t, err)
+	<-emulator.Done()
+	require.Error(t, emulator.Err())
+	require.Len(t, factory.calls, 1)
+}
@@ -0,0 +1,32 @@
+package emulatormanager
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bitrise-io/go-android/v2/avd"
+)
+
+// SaveSnapshot saves the state of the running emulator into the named snapshot of the AVD via the emulator console,
+// and records the system image fingerprint (see avd.SystemImageFingerprint) the snapshot belongs to.
+// Call it once the device is booted and set up, the snapshot can be loaded on later boots with StartOptions.Snapshot.
+func (model Model) SaveSnapshot(ctx context.Context, emulator *Emulator, device avd.AVD, name, systemImageFingerprint string) error {
+	if err := avd.ValidateSnapshotName(name); err != nil {
+		return fmt.Errorf("save snapshot: %w", err)
+	}
+
+	console, err := emulator.Console(ctx, ConsoleOptions{})
+	if err != nil {
+		return fmt.Errorf("save snapshot %s: %w", name, err)
+	}
+	defer func() {
+		_ = console.Close()
+	}()
+
+	model.logger.Printf("Saving snapshot %s of %s", name, emulator.Serial)
+	if err := console.SaveSnapshot(name); err != nil {
+		return fmt.Errorf("save snapshot: %w", err)
+	}
+
+	return device.RecordSnapshot(name, systemImageFingerprint)
+}
@@ -0,0 +1,51 @@
+package emulatormanager
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-android/v2/avd"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GivenRunningEmulator_WhenSaveSnapshot_ThenSavesAndRecordsSnapshot(t *testing.T) {
+	// Given
+	server := newFakeConsole(t, "", nil)
+	emulator := &Emulator{Serial: fmt.Sprintf("emulator-%d", server.port()), Port: server.port()}
+	device := avd.AVD{Name: "Pixel_5_API_33", Home: t.TempDir()}
+	// the fake console doesn't write the snapshot
+	snapshotDir := filepath.Join(device.SnapshotsDir(), "logged_in")
+	require.NoError(t, os.MkdirAll(snapshotDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(snapshotDir, "snapshot.pb"), nil, 0644))
+
+	model := mockModel()
+	model.logger = log.NewLogger()
+
+	// When
+	err := model.SaveSnapshot(context.Background(), emulator, device, "logged_in", "system-images;android-33;google_apis;x86_64@9")
+
+	// Then
+	require.NoError(t, err)
+	require.Equal(t, []string{"avd snapshot save logged_in"}, server.Commands())
+	snapshots, err := device.Snapshots()
+	require.NoError(t, err)
+	require.Equal(t, "system-images;android-33;google_apis;x86_64@9", snapshots[0].SystemImage)
+}
+
+func Test_GivenInvalidName_WhenSaveSnapshot_ThenFailsWithoutSaving(t *testing.T) {
+	// Given
+	server := newFakeConsole(t, "", nil)
+	emulator := &Emulator{Serial: fmt.Sprintf("emulator-%d", server.port()), Port: server.port()}
+	device := avd.AVD{Name: "Pixel_5_API_33", Home: t.TempDir()}
+
+	// When
+	err := mockModel().SaveSnapshot(context.Background(), emulator, device, "..", "system-images;android-33;google_apis;x86_64@9")
+
+	// Then
+	require.EqualError(t, err, `save snapshot: invalid snapshot name: ".."`)
+	require.Empty(t, server.Commands())
+}
@@ -0,0 +1,91 @@
+package androidartifact
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/bitrise-io/go-utils/sliceutil"
+)
+
+// densityDPIs maps the density split params to dpi values,
+// based on: https://developer.android.com/training/multiscreen/screendensities#TaskProvideAltBmp
+var densityDPIs = map[string]int{
+	"ldpi":    120,
+	"mdpi":    160,
+	"hdpi":    240,
+	"xhdpi":   320,
+	"xxhdpi":  480,
+	"xxxhdpi": 640,
+}
+
+// SelectSplitAPK returns the split APK of the artifact which best matches a device with the given ABIs (in order of
+// preference, like ro.product.cpu.abilist) and screen density (dpi).
+//
+// An APK matching the device's most preferred ABI wins over a more generic (universal or density only) APK.
+// Among the density splits, the one with the closest density not lower than the device's density is selected,
+// as Android scales down resources better than up. If the artifact has no splits, its APK is returned.
+func SelectSplitAPK(artifact Artifact, deviceABIs []string, deviceDensity int) (string, error) {
+	if len(artifact.Split) == 0 {
+		if artifact.APK != "" {
+			return artifact.APK, nil
+		}
+		return "", fmt.Errorf("artifact has no APK")
+	}
+
+	selected := ""
+	var selectedABIScore, selectedDensityScore int
+	for _, pth := range artifact.Split {
+		abiScore, densityScore, ok := splitScore(ParseArtifactPath(pth).SplitInfo, deviceABIs, deviceDensity)
+		if !ok {
+			continue
+		}
+
+		if selected == "" || abiScore < selectedABIScore || (abiScore == selectedABIScore && densityScore < selectedDensityScore) {
+			selected = pth
+			selectedABIScore = abiScore
+			selectedDensityScore = densityScore
+		}
+	}
+
+	if selected != "" {
+		return selected, nil
+	}
+	if artifact.UniversalApk != "" {
+		return artifact.UniversalApk, nil
+	}
+	return "", fmt.Errorf("none of the split APKs supports the device ABIs: %v", deviceABIs)
+}
+
+// splitScore returns the scores of the split APK, lower is better. ok is false if the APK doesn't support any of the ABIs.
+func splitScore(info ArtifactSplitInfo, deviceABIs []string, deviceDensity int) (abiScore int, densityScore int, ok bool) {
+	// APKs without an ABI split param (universal or density only splits) support every ABI,
+	// but are less preferred than an APK built for one of the device's ABIs.
+	abiScore = len(deviceABIs)
+	densityScore = 1 << 20 // no density split param: contains every density
+	for _, param := range info.SplitParams {
+		switch {
+		case param == universalSplitParam:
+		case sliceutil.IsStringInSlice(param, abis) || sliceutil.IsStringInSlice(param, unsupportedAbis):
+			idx := sliceutil.IndexOfStringInSlice(param, deviceABIs)
+			if idx < 0 {
+				return 0, 0, false
+			}
+			abiScore = idx
+		default:
+			dpi, isDensity := densityDPIs[param]
+			if !isDensity {
+				var err error
+				if dpi, err = strconv.Atoi(param); err != nil {
+					continue
+				}
+			}
+
+			if dpi >= deviceDensity {
+				densityScore = dpi - deviceDensity
+			} else {
+				densityScore = 1<<10 + deviceDensity - dpi
+			}
+		}
+	}
+	return abiScore, densityScore, true
+}
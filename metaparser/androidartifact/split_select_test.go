@@ -0,0 +1,105 @@
+package androidartifact
+
+import "testing"
+
+func TestSelectSplitAPK(t *testing.T) {
+	abiSplits := Artifact{
+		Split: []string{
+			"app-armeabi-v7a-debug.apk",
+			"app-arm64-v8a-debug.apk",
+			"app-x86-debug.apk",
+			"app-x86_64-debug.apk",
+			"app-universal-debug.apk",
+		},
+		UniversalApk: "app-universal-debug.apk",
+	}
+	densitySplits := Artifact{
+		Split: []string{
+			"app-mdpi-debug.apk",
+			"app-hdpi-debug.apk",
+			"app-xhdpi-debug.apk",
+			"app-xxhdpi-debug.apk",
+			"app-420-debug.apk",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		artifact Artifact
+		abis     []string
+		density  int
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "single APK",
+			artifact: Artifact{APK: "app-debug.apk"},
+			abis:     []string{"x86_64"},
+			want:     "app-debug.apk",
+		},
+		{
+			name:     "primary ABI wins",
+			artifact: abiSplits,
+			abis:     []string{"x86_64", "x86", "arm64-v8a"},
+			want:     "app-x86_64-debug.apk",
+		},
+		{
+			name:     "secondary ABI wins over universal",
+			artifact: Artifact{Split: []string{"app-armeabi-v7a-debug.apk", "app-universal-debug.apk"}, UniversalApk: "app-universal-debug.apk"},
+			abis:     []string{"arm64-v8a", "armeabi-v7a", "armeabi"},
+			want:     "app-armeabi-v7a-debug.apk",
+		},
+		{
+			name:     "universal fallback",
+			artifact: abiSplits,
+			abis:     []string{"riscv64"},
+			want:     "app-universal-debug.apk",
+		},
+		{
+			name:     "exact density",
+			artifact: densitySplits,
+			abis:     []string{"x86_64"},
+			density:  320,
+			want:     "app-xhdpi-debug.apk",
+		},
+		{
+			name:     "closest higher density",
+			artifact: densitySplits,
+			abis:     []string{"x86_64"},
+			density:  400,
+			want:     "app-420-debug.apk",
+		},
+		{
+			name:     "closest lower density if there is no higher",
+			artifact: densitySplits,
+			abis:     []string{"x86_64"},
+			density:  640,
+			want:     "app-xxhdpi-debug.apk",
+		},
+		{
+			name:     "density and ABI split",
+			artifact: Artifact{Split: []string{"app-hdpiX86-debug.apk", "app-xxhdpiX86-debug.apk", "app-hdpiArm64-v8a-debug.apk"}},
+			abis:     []string{"arm64-v8a"},
+			density:  480,
+			want:     "app-hdpiArm64-v8a-debug.apk",
+		},
+		{
+			name:     "no matching ABI",
+			artifact: Artifact{Split: []string{"app-x86-debug.apk"}},
+			abis:     []string{"arm64-v8a"},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SelectSplitAPK(tt.artifact, tt.abis, tt.density)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SelectSplitAPK() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("SelectSplitAPK() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
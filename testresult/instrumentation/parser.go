@@ -0,0 +1,279 @@
+package instrumentation
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Line prefixes of the `am instrument -r` raw output.
+// See: https://android.googlesource.com/platform/frameworks/base/+/refs/heads/main/cmds/am/src/com/android/commands/am/Instrument.java
+const (
+	statusPrefix     = "INSTRUMENTATION_STATUS: "
+	statusCodePrefix = "INSTRUMENTATION_STATUS_CODE: "
+	resultPrefix     = "INSTRUMENTATION_RESULT: "
+	codePrefix       = "INSTRUMENTATION_CODE: "
+	failedPrefix     = "INSTRUMENTATION_FAILED: "
+	abortedPrefix    = "INSTRUMENTATION_ABORTED: "
+	// Bundle keys are prefixed with INSTRUMENTATION_ too, a line not starting with this is a continuation of the
+	// previous multi-line value (for example a stack trace).
+	instrumentationPrefix = "INSTRUMENTATION_"
+)
+
+// Status codes reported by the AndroidJUnitRunner (and the legacy InstrumentationTestRunner).
+const (
+	statusCodeStart             = 1
+	statusCodeInProgress        = 2
+	statusCodeOK                = 0
+	statusCodeError             = -1
+	statusCodeFailure           = -2
+	statusCodeIgnored           = -3
+	statusCodeAssumptionFailure = -4
+	instrumentationCodeOK       = -1
+)
+
+// EventType ...
+type EventType int
+
+// Event types emitted by the Parser.
+const (
+	EventTestStarted EventType = iota
+	EventTestPassed
+	EventTestFailed
+	EventTestErrored
+	EventTestIgnored
+	EventTestAssumptionFailure
+	// EventRunFailed is emitted when the instrumentation run itself fails, for example when the app process crashes.
+	EventRunFailed
+)
+
+// Event ...
+type Event struct {
+	Type      EventType
+	ClassName string
+	TestName  string
+	// StackTrace is set for failed, errored and assumption failure events.
+	StackTrace string
+	// Message is set for EventRunFailed.
+	Message string
+	// Current is the 1 based index of the test, NumTests is the number of tests in the run.
+	Current  int
+	NumTests int
+}
+
+// Parser is a streaming parser of the `am instrument -r` output. It implements io.Writer, so it can be used as
+// the stdout of the instrumentation command; call Close once the command has finished.
+type Parser struct {
+	onEvent func(Event)
+	now     func() time.Time
+
+	buf []byte
+
+	// key-value pairs of the current status or result block
+	bundle  map[string]string
+	lastKey string
+
+	running   *TestResult
+	startTime time.Time
+	result    Result
+	gotCode   bool
+}
+
+// NewParser returns a Parser which calls onEvent (if not nil) for every test and run level event.
+func NewParser(onEvent func(Event)) *Parser {
+	return &Parser{
+		onEvent: onEvent,
+		now:     time.Now,
+		bundle:  map[string]string{},
+	}
+}
+
+// Parse parses a complete `am instrument -r` output.
+func Parse(r io.Reader) (Result, error) {
+	parser := NewParser(nil)
+	if _, err := io.Copy(parser, r); err != nil {
+		return Result{}, err
+	}
+	return parser.Close()
+}
+
+// Write ...
+func (parser *Parser) Write(p []byte) (int, error) {
+	parser.buf = append(parser.buf, p...)
+	for {
+		i := bytes.IndexByte(parser.buf, '\n')
+		if i < 0 {
+			break
+		}
+		parser.parseLine(strings.TrimSuffix(string(parser.buf[:i]), "\r"))
+		parser.buf = parser.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Close flushes the remaining output and returns the result of the run. If the output ended while a test was running,
+// or without an INSTRUMENTATION_CODE, the run is reported as crashed.
+func (parser *Parser) Close() (Result, error) {
+	if len(parser.buf) > 0 {
+		parser.parseLine(string(parser.buf))
+		parser.buf = nil
+	}
+
+	if !parser.gotCode && parser.result.RunFailure == "" {
+		parser.failRun("Test run failed to complete, instrumentation output ended unexpectedly", true)
+	}
+	if parser.running != nil {
+		parser.finishTest(TestStatusErrored, "Test did not complete: "+parser.result.RunFailure)
+	}
+
+	return parser.result, nil
+}
+
+func (parser *Parser) parseLine(line string) {
+	switch {
+	case strings.HasPrefix(line, statusPrefix):
+		parser.parseKeyValue(strings.TrimPrefix(line, statusPrefix))
+	case strings.HasPrefix(line, resultPrefix):
+		parser.parseKeyValue(strings.TrimPrefix(line, resultPrefix))
+	case strings.HasPrefix(line, statusCodePrefix):
+		code, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, statusCodePrefix)))
+		if err == nil {
+			parser.handleStatus(code)
+		}
+		parser.resetBundle()
+	case strings.HasPrefix(line, codePrefix):
+		code, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, codePrefix)))
+		if err == nil {
+			parser.handleResult(code)
+		}
+		parser.resetBundle()
+	case strings.HasPrefix(line, failedPrefix):
+		parser.failRun(strings.TrimSpace(strings.TrimPrefix(line, failedPrefix)), false)
+		parser.lastKey = ""
+	case strings.HasPrefix(line, abortedPrefix):
+		message := strings.TrimSpace(strings.TrimPrefix(line, abortedPrefix))
+		parser.failRun(message, isCrashMessage(message))
+		parser.lastKey = ""
+	case strings.HasPrefix(line, instrumentationPrefix):
+		parser.lastKey = ""
+	case parser.lastKey != "":
+		parser.bundle[parser.lastKey] += "\n" + line
+	}
+}
+
+func (parser *Parser) parseKeyValue(keyValue string) {
+	key, value, _ := strings.Cut(keyValue, "=")
+	parser.bundle[key] = value
+	parser.lastKey = key
+}
+
+func (parser *Parser) resetBundle() {
+	parser.bundle = map[string]string{}
+	parser.lastKey = ""
+}
+
+func (parser *Parser) handleStatus(code int) {
+	className := parser.bundle["class"]
+	testName := parser.bundle["test"]
+	current, _ := strconv.Atoi(parser.bundle["current"])
+	numTests, _ := strconv.Atoi(parser.bundle["numtests"])
+	if numTests > 0 {
+		parser.result.NumTests = numTests
+	}
+
+	// Instrumentation level errors are reported as a status without a test:
+	// INSTRUMENTATION_STATUS: Error=Unable to find instrumentation info for: ComponentInfo{...}
+	// INSTRUMENTATION_STATUS_CODE: -1
+	if errorMessage := strings.TrimSpace(parser.bundle["Error"]); errorMessage != "" && testName == "" {
+		parser.failRun(errorMessage, false)
+		return
+	}
+
+	switch code {
+	case statusCodeStart:
+		if parser.running != nil {
+			parser.finishTest(TestStatusErrored, "Test did not report a result before the next test started")
+		}
+		parser.running = &TestResult{ClassName: className, TestName: testName}
+		parser.startTime = parser.now()
+		parser.emit(Event{Type: EventTestStarted, ClassName: className, TestName: testName, Current: current, NumTests: numTests})
+	case statusCodeInProgress:
+		// intermediate status, used by some runners to stream output
+	case statusCodeOK:
+		parser.finishTest(TestStatusPassed, "")
+	case statusCodeError:
+		parser.finishTest(TestStatusErrored, strings.TrimSpace(parser.bundle["stack"]))
+	case statusCodeFailure:
+		parser.finishTest(TestStatusFailed, strings.TrimSpace(parser.bundle["stack"]))
+	case statusCodeIgnored:
+		parser.finishTest(TestStatusIgnored, "")
+	case statusCodeAssumptionFailure:
+		parser.finishTest(TestStatusAssumptionFailure, strings.TrimSpace(parser.bundle["stack"]))
+	}
+}
+
+func (parser *Parser) handleResult(code int) {
+	parser.gotCode = true
+	parser.result.Code = code
+	if stream := strings.TrimSpace(parser.bundle["stream"]); stream != "" {
+		parser.result.Output = stream
+	}
+
+	// A crashed app process is reported as:
+	// INSTRUMENTATION_RESULT: shortMsg=Process crashed.
+	// INSTRUMENTATION_CODE: 0
+	if shortMsg := strings.TrimSpace(parser.bundle["shortMsg"]); shortMsg != "" {
+		parser.failRun(shortMsg, isCrashMessage(shortMsg))
+	} else if code != instrumentationCodeOK {
+		parser.failRun("Instrumentation finished with code: "+strconv.Itoa(code), false)
+	}
+
+	if parser.running != nil {
+		parser.finishTest(TestStatusErrored, "Test did not complete: "+parser.result.RunFailure)
+	}
+}
+
+func (parser *Parser) finishTest(status TestStatus, stackTrace string) {
+	test := parser.running
+	if test == nil {
+		// a result without a start (for example an ignored test reported on its own)
+		test = &TestResult{ClassName: parser.bundle["class"], TestName: parser.bundle["test"]}
+	} else {
+		test.Duration = parser.now().Sub(parser.startTime)
+	}
+	parser.running = nil
+
+	test.Status = status
+	test.StackTrace = stackTrace
+	parser.result.Tests = append(parser.result.Tests, *test)
+
+	parser.emit(Event{
+		Type:       status.eventType(),
+		ClassName:  test.ClassName,
+		TestName:   test.TestName,
+		StackTrace: stackTrace,
+		NumTests:   parser.result.NumTests,
+	})
+}
+
+func (parser *Parser) failRun(message string, crashed bool) {
+	if parser.result.RunFailure != "" {
+		return
+	}
+	parser.result.RunFailure = message
+	parser.result.Crashed = crashed
+	parser.emit(Event{Type: EventRunFailed, Message: message})
+}
+
+// isCrashMessage matches messages like `Process crashed.` and `System has crashed.`
+func isCrashMessage(message string) bool {
+	return strings.Contains(strings.ToLower(message), "crashed")
+}
+
+func (parser *Parser) emit(event Event) {
+	if parser.onEvent != nil {
+		parser.onEvent(event)
+	}
+}
@@ -0,0 +1,240 @@
+package instrumentation
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse_CompletedRun(t *testing.T) {
+	result := parseWithFixedClock(t, completedRunOutput)
+
+	require.Equal(t, Result{
+		Tests: []TestResult{
+			{ClassName: "com.example.LoginTest", TestName: "validLogin", Status: TestStatusPassed, Duration: time.Second},
+			{ClassName: "com.example.LoginTest", TestName: "invalidPassword", Status: TestStatusFailed, Duration: time.Second, StackTrace: "java.lang.AssertionError: expected:<true> but was:<false>\n\tat org.junit.Assert.fail(Assert.java:89)\n\tat com.example.LoginTest.invalidPassword(LoginTest.kt:42)"},
+			{ClassName: "com.example.SettingsTest", TestName: "darkMode", Status: TestStatusIgnored},
+			{ClassName: "com.example.SettingsTest", TestName: "tabletLayout", Status: TestStatusAssumptionFailure, Duration: time.Second, StackTrace: "org.junit.AssumptionViolatedException: got: <false>, expected: is <true>\n\tat org.junit.Assume.assumeTrue(Assume.java:68)"},
+		},
+		NumTests: 4,
+		Code:     -1,
+		Output:   "Time: 2.345\nThere was 1 failure:\n1) invalidPassword(com.example.LoginTest)\n\nFAILURES!!!\nTests run: 3,  Failures: 1",
+	}, result)
+	require.True(t, result.Failed())
+}
+
+func TestParse_ProcessCrash(t *testing.T) {
+	result := parseWithFixedClock(t, processCrashOutput)
+
+	require.Equal(t, []TestResult{
+		{ClassName: "com.example.LoginTest", TestName: "validLogin", Status: TestStatusPassed, Duration: time.Second},
+		{ClassName: "com.example.LoginTest", TestName: "crashingTest", Status: TestStatusErrored, Duration: time.Second, StackTrace: "Test did not complete: Process crashed."},
+	}, result.Tests)
+	require.Equal(t, "Process crashed.", result.RunFailure)
+	require.True(t, result.Crashed)
+}
+
+func TestParse_TruncatedOutput(t *testing.T) {
+	output := strings.Split(processCrashOutput, "INSTRUMENTATION_RESULT")[0]
+	result := parseWithFixedClock(t, output)
+
+	require.Len(t, result.Tests, 2)
+	require.Equal(t, TestStatusErrored, result.Tests[1].Status)
+	require.Equal(t, "Test run failed to complete, instrumentation output ended unexpectedly", result.RunFailure)
+	require.True(t, result.Crashed)
+}
+
+func TestParse_InstrumentationFailed(t *testing.T) {
+	result := parseWithFixedClock(t, `INSTRUMENTATION_STATUS: id=ActivityManagerService
+INSTRUMENTATION_STATUS: Error=Unable to find instrumentation info for: ComponentInfo{com.example.test/androidx.test.runner.AndroidJUnitRunner}
+INSTRUMENTATION_STATUS_CODE: -1
+android.util.AndroidException: INSTRUMENTATION_FAILED: com.example.test/androidx.test.runner.AndroidJUnitRunner
+	at com.android.commands.am.Instrument.run(Instrument.java:519)
+`)
+
+	require.Empty(t, result.Tests)
+	require.Equal(t, "Unable to find instrumentation info for: ComponentInfo{com.example.test/androidx.test.runner.AndroidJUnitRunner}", result.RunFailure)
+	require.False(t, result.Crashed)
+	require.True(t, result.Failed())
+}
+
+func TestParser_StreamsEvents(t *testing.T) {
+	var events []Event
+	parser := NewParser(func(event Event) {
+		events = append(events, event)
+	})
+
+	// write the output in small chunks, splitting lines
+	for i := 0; i < len(completedRunOutput); i += 7 {
+		end := min(i+7, len(completedRunOutput))
+		_, err := parser.Write([]byte(completedRunOutput[i:end]))
+		require.NoError(t, err)
+	}
+	_, err := parser.Close()
+	require.NoError(t, err)
+
+	var types []EventType
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	require.Equal(t, []EventType{
+		EventTestStarted, EventTestPassed,
+		EventTestStarted, EventTestFailed,
+		EventTestIgnored,
+		EventTestStarted, EventTestAssumptionFailure,
+	}, types)
+	require.Equal(t, 2, events[2].Current)
+	require.Equal(t, 4, events[2].NumTests)
+	require.Equal(t, "invalidPassword", events[3].TestName)
+}
+
+func TestResult_TestReport(t *testing.T) {
+	result := parseWithFixedClock(t, completedRunOutput)
+
+	report := result.TestReport("emulator-5554")
+
+	require.Len(t, report.TestSuites, 2)
+	login := report.TestSuites[0]
+	require.Equal(t, "com.example.LoginTest", login.Name)
+	require.Equal(t, 2, login.Tests)
+	require.Equal(t, 1, login.Failures)
+	require.Equal(t, "java.lang.AssertionError: expected:<true> but was:<false>", login.TestCases[1].Failure.Message)
+	require.Equal(t, 2.0, login.Time)
+
+	settings := report.TestSuites[1]
+	require.Equal(t, 2, settings.Tests)
+	require.Equal(t, 2, settings.Skipped)
+	require.NotNil(t, settings.TestCases[1].Skipped)
+}
+
+func TestResult_TestReport_RunFailureWithoutTests(t *testing.T) {
+	report := Result{RunFailure: "Unable to find instrumentation info"}.TestReport("emulator-5554")
+
+	require.Len(t, report.TestSuites, 1)
+	require.Equal(t, 1, report.TestSuites[0].Errors)
+	require.Equal(t, "Unable to find instrumentation info", report.TestSuites[0].TestCases[0].Error.Message)
+}
+
+// Helpers
+
+// parseWithFixedClock parses the output with a clock advancing 1 second on every call,
+// so every started test takes 1 second.
+func parseWithFixedClock(t *testing.T, output string) Result {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	parser := NewParser(nil)
+	parser.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	_, err := parser.Write([]byte(output))
+	require.NoError(t, err)
+	result, err := parser.Close()
+	require.NoError(t, err)
+
+	return result
+}
+
+const completedRunOutput = `INSTRUMENTATION_STATUS: class=com.example.LoginTest
+INSTRUMENTATION_STATUS: current=1
+INSTRUMENTATION_STATUS: id=AndroidJUnitRunner
+INSTRUMENTATION_STATUS: numtests=4
+INSTRUMENTATION_STATUS: stream=
+com.example.LoginTest:
+INSTRUMENTATION_STATUS: test=validLogin
+INSTRUMENTATION_STATUS_CODE: 1
+INSTRUMENTATION_STATUS: class=com.example.LoginTest
+INSTRUMENTATION_STATUS: current=1
+INSTRUMENTATION_STATUS: id=AndroidJUnitRunner
+INSTRUMENTATION_STATUS: numtests=4
+INSTRUMENTATION_STATUS: stream=.
+INSTRUMENTATION_STATUS: test=validLogin
+INSTRUMENTATION_STATUS_CODE: 0
+INSTRUMENTATION_STATUS: class=com.example.LoginTest
+INSTRUMENTATION_STATUS: current=2
+INSTRUMENTATION_STATUS: id=AndroidJUnitRunner
+INSTRUMENTATION_STATUS: numtests=4
+INSTRUMENTATION_STATUS: stream=
+INSTRUMENTATION_STATUS: test=invalidPassword
+INSTRUMENTATION_STATUS_CODE: 1
+INSTRUMENTATION_STATUS: class=com.example.LoginTest
+INSTRUMENTATION_STATUS: current=2
+INSTRUMENTATION_STATUS: id=AndroidJUnitRunner
+INSTRUMENTATION_STATUS: numtests=4
+INSTRUMENTATION_STATUS: stack=java.lang.AssertionError: expected:<true> but was:<false>
+	at org.junit.Assert.fail(Assert.java:89)
+	at com.example.LoginTest.invalidPassword(LoginTest.kt:42)
+
+INSTRUMENTATION_STATUS: stream=
+Error in invalidPassword(com.example.LoginTest):
+java.lang.AssertionError: expected:<true> but was:<false>
+	at org.junit.Assert.fail(Assert.java:89)
+	at com.example.LoginTest.invalidPassword(LoginTest.kt:42)
+
+INSTRUMENTATION_STATUS: test=invalidPassword
+INSTRUMENTATION_STATUS_CODE: -2
+INSTRUMENTATION_STATUS: class=com.example.SettingsTest
+INSTRUMENTATION_STATUS: current=3
+INSTRUMENTATION_STATUS: id=AndroidJUnitRunner
+INSTRUMENTATION_STATUS: numtests=4
+INSTRUMENTATION_STATUS: stream=
+com.example.SettingsTest:
+INSTRUMENTATION_STATUS: test=darkMode
+INSTRUMENTATION_STATUS_CODE: -3
+INSTRUMENTATION_STATUS: class=com.example.SettingsTest
+INSTRUMENTATION_STATUS: current=4
+INSTRUMENTATION_STATUS: id=AndroidJUnitRunner
+INSTRUMENTATION_STATUS: numtests=4
+INSTRUMENTATION_STATUS: stream=
+INSTRUMENTATION_STATUS: test=tabletLayout
+INSTRUMENTATION_STATUS_CODE: 1
+INSTRUMENTATION_STATUS: class=com.example.SettingsTest
+INSTRUMENTATION_STATUS: current=4
+INSTRUMENTATION_STATUS: id=AndroidJUnitRunner
+INSTRUMENTATION_STATUS: numtests=4
+INSTRUMENTATION_STATUS: stack=org.junit.AssumptionViolatedException: got: <false>, expected: is <true>
+	at org.junit.Assume.assumeTrue(Assume.java:68)
+
+INSTRUMENTATION_STATUS: stream=
+INSTRUMENTATION_STATUS: test=tabletLayout
+INSTRUMENTATION_STATUS_CODE: -4
+INSTRUMENTATION_RESULT: stream=
+
+Time: 2.345
+There was 1 failure:
+1) invalidPassword(com.example.LoginTest)
+
+FAILURES!!!
+Tests run: 3,  Failures: 1
+
+
+INSTRUMENTATION_CODE: -1
+`
+
+const processCrashOutput = `INSTRUMENTATION_STATUS: class=com.example.LoginTest
+INSTRUMENTATION_STATUS: current=1
+INSTRUMENTATION_STATUS: id=AndroidJUnitRunner
+INSTRUMENTATION_STATUS: numtests=2
+INSTRUMENTATION_STATUS: stream=
+com.example.LoginTest:
+INSTRUMENTATION_STATUS: test=validLogin
+INSTRUMENTATION_STATUS_CODE: 1
+INSTRUMENTATION_STATUS: class=com.example.LoginTest
+INSTRUMENTATION_STATUS: current=1
+INSTRUMENTATION_STATUS: id=AndroidJUnitRunner
+INSTRUMENTATION_STATUS: numtests=2
+INSTRUMENTATION_STATUS: stream=.
+INSTRUMENTATION_STATUS: test=validLogin
+INSTRUMENTATION_STATUS_CODE: 0
+INSTRUMENTATION_STATUS: class=com.example.LoginTest
+INSTRUMENTATION_STATUS: current=2
+INSTRUMENTATION_STATUS: id=AndroidJUnitRunner
+INSTRUMENTATION_STATUS: numtests=2
+INSTRUMENTATION_STATUS: stream=
+INSTRUMENTATION_STATUS: test=crashingTest
+INSTRUMENTATION_STATUS_CODE: 1
+INSTRUMENTATION_RESULT: shortMsg=Process crashed.
+INSTRUMENTATION_CODE: 0
+`
@@ -0,0 +1,177 @@
+package instrumentation
+
+import (
+	"encoding/xml"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-steputils/v2/testreport"
+)
+
+// TestStatus ...
+type TestStatus string
+
+// Test statuses.
+const (
+	TestStatusPassed            TestStatus = "passed"
+	TestStatusFailed            TestStatus = "failed"
+	TestStatusErrored           TestStatus = "errored"
+	TestStatusIgnored           TestStatus = "ignored"
+	TestStatusAssumptionFailure TestStatus = "assumption failure"
+)
+
+func (status TestStatus) eventType() EventType {
+	switch status {
+	case TestStatusPassed:
+		return EventTestPassed
+	case TestStatusFailed:
+		return EventTestFailed
+	case TestStatusIgnored:
+		return EventTestIgnored
+	case TestStatusAssumptionFailure:
+		return EventTestAssumptionFailure
+	default:
+		return EventTestErrored
+	}
+}
+
+// TestResult ...
+type TestResult struct {
+	ClassName  string
+	TestName   string
+	Status     TestStatus
+	StackTrace string
+	// Duration is measured between the start and end status of the test, so it is only meaningful when the output
+	// is parsed while the tests are running.
+	Duration time.Duration
+}
+
+// Result is the parsed result of an instrumentation run.
+type Result struct {
+	Tests    []TestResult
+	NumTests int
+	// Code is the INSTRUMENTATION_CODE of the run, -1 (Activity.RESULT_OK) on success.
+	Code int
+	// Output is the final `stream` of the run, for example the JUnit summary.
+	Output string
+	// RunFailure is set if the run did not complete successfully.
+	RunFailure string
+	// Crashed is true if the instrumented process crashed (or the output ended) in the middle of the run.
+	Crashed bool
+}
+
+// Failed returns true if the run failed or any of the tests failed.
+func (result Result) Failed() bool {
+	if result.RunFailure != "" {
+		return true
+	}
+	for _, test := range result.Tests {
+		if test.Status == TestStatusFailed || test.Status == TestStatusErrored {
+			return true
+		}
+	}
+	return false
+}
+
+// TestReport converts the result into the test report model, grouping the tests into one suite per test class.
+// A run failure without a test to attach it to is reported as an errored test case of a suite named after the run.
+func (result Result) TestReport(runName string) testreport.TestReport {
+	report := testreport.TestReport{XMLName: xml.Name{Local: "testsuites"}}
+	suiteIndexes := map[string]int{}
+
+	for _, test := range result.Tests {
+		idx, ok := suiteIndexes[test.ClassName]
+		if !ok {
+			idx = len(report.TestSuites)
+			suiteIndexes[test.ClassName] = idx
+			report.TestSuites = append(report.TestSuites, testreport.TestSuite{
+				XMLName: xml.Name{Local: "testsuite"},
+				Name:    test.ClassName,
+			})
+		}
+
+		suite := &report.TestSuites[idx]
+		testCase := convertTestResult(test)
+		suite.TestCases = append(suite.TestCases, testCase)
+		suite.Tests++
+		suite.Time += testCase.Time
+		switch {
+		case testCase.Failure != nil:
+			suite.Failures++
+		case testCase.Error != nil:
+			suite.Errors++
+		case testCase.Skipped != nil:
+			suite.Skipped++
+		}
+	}
+
+	if result.RunFailure != "" && !result.HasErroredTest() {
+		report.TestSuites = append(report.TestSuites, testreport.TestSuite{
+			XMLName: xml.Name{Local: "testsuite"},
+			Name:    runName,
+			Tests:   1,
+			Errors:  1,
+			TestCases: []testreport.TestCase{{
+				XMLName:   xml.Name{Local: "testcase"},
+				Name:      "run",
+				ClassName: runName,
+				Error: &testreport.Error{
+					XMLName: xml.Name{Local: "error"},
+					Message: result.RunFailure,
+				},
+			}},
+		})
+	}
+
+	return report
+}
+
+// HasErroredTest returns true if any of the tests errored, for example because the run crashed during the test.
+func (result Result) HasErroredTest() bool {
+	for _, test := range result.Tests {
+		if test.Status == TestStatusErrored {
+			return true
+		}
+	}
+	return false
+}
+
+func convertTestResult(test TestResult) testreport.TestCase {
+	testCase := testreport.TestCase{
+		XMLName:   xml.Name{Local: "testcase"},
+		Name:      test.TestName,
+		ClassName: test.ClassName,
+		Time:      test.Duration.Seconds(),
+	}
+
+	message := firstLine(test.StackTrace)
+	switch test.Status {
+	case TestStatusFailed:
+		testCase.Failure = &testreport.Failure{
+			XMLName: xml.Name{Local: "failure"},
+			Message: message,
+			Value:   test.StackTrace,
+		}
+	case TestStatusErrored:
+		testCase.Error = &testreport.Error{
+			XMLName: xml.Name{Local: "error"},
+			Message: message,
+			Value:   test.StackTrace,
+		}
+	case TestStatusIgnored:
+		testCase.Skipped = &testreport.Skipped{XMLName: xml.Name{Local: "skipped"}}
+	case TestStatusAssumptionFailure:
+		testCase.Skipped = &testreport.Skipped{
+			XMLName: xml.Name{Local: "skipped"},
+			Message: message,
+			Value:   test.StackTrace,
+		}
+	}
+
+	return testCase
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return strings.TrimSpace(line)
+}